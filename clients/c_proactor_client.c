@@ -6,11 +6,15 @@
 #include <proton/message.h>
 #include <proton/proactor.h>
 #include <proton/sasl.h>
+#include <proton/ssl.h>
 #include <proton/types.h>
 #include <proton/version.h>
 
+#include <errno.h>
+#include <fcntl.h>
 #include <inttypes.h>
 #include <memory.h>
+#include <poll.h>
 #include <pthread.h>
 #include <signal.h>
 #include <stdarg.h>
@@ -18,9 +22,11 @@
 #include <stdio.h>
 #include <stdlib.h>
 #include <string.h>
+#include <sys/socket.h>
 #include <sys/time.h>
 #include <sys/types.h>
 #include <sys/types.h>
+#include <sys/un.h>
 #include <time.h>
 #include <unistd.h>
 
@@ -44,6 +50,7 @@ get_timestamp_seconds ( void )
 #define MAX_NAME   100
 #define MAX_ADDRS  1000
 #define MAX_MESSAGE 2000000
+#define MAX_CONTROL_LINE 1000
 
 
 typedef
@@ -111,8 +118,14 @@ struct context_s
   int               max_flight_times;
   int               n_flight_times;
   char              flight_times_file_name [ 1000 ];
-  char              events_path [ 1000 ];
-  char              start_signal_path[1000];
+
+  // The control channel to Mercury.
+  char              control_socket_path [ 1000 ];
+  int               control_fd;
+  char              control_buffer [ MAX_CONTROL_LINE ];
+  size_t            control_buffered;
+  bool              dump_requested;
+  bool              stop_requested;
 
   double            grand_start_time,
                     send_start_time,
@@ -123,6 +136,27 @@ struct context_s
   bool              soak;
 
   int               report_frequency;
+
+  // Progress also goes to Mercury on a timer, so that slow
+  // runs are not taken for stalled ones.
+  double            last_progress_time,
+                    last_control_check;
+  int               last_progress_count;
+
+  // How long the connection took to open, from connect to
+  // the peer's open. Negative until it has opened.
+  double            connect_start_time,
+                    connect_msec;
+
+  // TLS. If there is no CA file, the client does not use it.
+  char            * ssl_ca_file;
+  bool              ssl_verify_peer_name;
+  pn_ssl_domain_t * ssl_domain;
+
+  // SASL. If there is no mechanism, the client uses ANONYMOUS.
+  char            * sasl_mechanism;
+  char            * sasl_user;
+  char            * sasl_password;
 }
 context_t,
 * context_p;
@@ -216,6 +250,187 @@ log_no_timestamp ( context_p context, char const * format, ... )
 
 
 
+/*
+  Send one line to Mercury over the control channel.
+*/
+void
+control_send ( context_p context, char const * format, ... )
+{
+  if ( context->control_fd < 0 )
+    return;
+
+  char line [ MAX_CONTROL_LINE ];
+  va_list ap;
+  va_start ( ap, format );
+  int len = vsnprintf ( line, sizeof(line) - 1, format, ap );
+  va_end ( ap );
+  if ( len < 0 )
+    return;
+  if ( len > (int) sizeof(line) - 2 )
+    len = sizeof(line) - 2;
+  line [ len ++ ] = '\n';
+
+  int written = 0;
+  while ( written < len )
+  {
+    ssize_t n = write ( context->control_fd, line + written, len - written );
+    if ( n < 0 )
+    {
+      if ( errno == EAGAIN || errno == EWOULDBLOCK || errno == EINTR )
+      {
+        struct pollfd pfd = { context->control_fd, POLLOUT, 0 };
+        poll ( & pfd, 1, 100 );
+        continue;
+      }
+      log ( context, "error : control channel write failed: %s\n", strerror(errno) );
+      return;
+    }
+    written += n;
+  }
+}
+
+
+
+
+
+void
+control_command ( context_p context, char * command )
+{
+  log ( context, "control command |%s|\n", command );
+
+  if ( ! strcmp ( command, "start" ) )
+  {
+    context->start_signal_received = true;
+  }
+  else
+  if ( ! strcmp ( command, "dump" ) )
+  {
+    context->dump_requested = true;
+  }
+  else
+  if ( ! strcmp ( command, "stop" ) )
+  {
+    context->stop_requested = true;
+  }
+  else
+  {
+    log ( context, "error : unknown control command |%s|\n", command );
+  }
+}
+
+
+
+
+
+/*
+  Read and act on whatever commands Mercury has sent,
+  waiting up to timeout_msec for something to arrive.
+  Returns false if the control channel has closed.
+*/
+bool
+control_read ( context_p context, int timeout_msec )
+{
+  if ( context->control_fd < 0 )
+    return false;
+
+  struct pollfd pfd = { context->control_fd, POLLIN, 0 };
+  if ( poll ( & pfd, 1, timeout_msec ) <= 0 )
+    return true;
+
+  ssize_t n = read ( context->control_fd,
+                     context->control_buffer + context->control_buffered,
+                     sizeof(context->control_buffer) - context->control_buffered - 1
+                   );
+  if ( n == 0 || ( n < 0 && errno != EAGAIN && errno != EWOULDBLOCK && errno != EINTR ) )
+  {
+    log ( context, "control channel closed.\n" );
+    close ( context->control_fd );
+    context->control_fd = -1;
+    return false;
+  }
+  if ( n < 0 )
+    return true;
+
+  context->control_buffered += n;
+  context->control_buffer [ context->control_buffered ] = 0;
+
+  char * line = context->control_buffer;
+  char * newline;
+  while ( (newline = strchr ( line, '\n' )) )
+  {
+    * newline = 0;
+    control_command ( context, line );
+    line = newline + 1;
+  }
+
+  // Keep any partial line for next time.
+  context->control_buffered = strlen ( line );
+  memmove ( context->control_buffer, line, context->control_buffered + 1 );
+
+  return true;
+}
+
+
+
+
+
+/*
+  Connect to Mercury's control socket and introduce ourselves.
+  Mercury may still be starting up, so keep trying for a while.
+*/
+void
+control_connect ( context_p context )
+{
+  if ( ! context->control_socket_path[0] )
+  {
+    // No Mercury to talk to. Run stand-alone:
+    // start at once, and dump data when done.
+    log ( context, "no control socket: running stand-alone.\n" );
+    context->start_signal_received = true;
+    context->dump_requested        = true;
+    return;
+  }
+
+  struct sockaddr_un addr;
+  memset ( & addr, 0, sizeof(addr) );
+  addr.sun_family = AF_UNIX;
+  strncpy ( addr.sun_path, context->control_socket_path, sizeof(addr.sun_path) - 1 );
+
+  for ( int attempt = 0; attempt < 100; attempt ++ )
+  {
+    int fd = socket ( AF_UNIX, SOCK_STREAM, 0 );
+    if ( fd < 0 )
+    {
+      fprintf ( stderr, "client error: can't make control socket: %s\n", strerror(errno) );
+      exit ( 1 );
+    }
+
+    if ( 0 == connect ( fd, (struct sockaddr *) & addr, sizeof(addr) ) )
+    {
+      fcntl ( fd, F_SETFL, fcntl ( fd, F_GETFL, 0 ) | O_NONBLOCK );
+      context->control_fd = fd;
+      control_send ( context, 
+                     "hello %s %d %s", 
+                     context->name, 
+                     getpid(), 
+                     context->sending ? "send" : "receive" 
+                   );
+      log ( context, "connected to control socket |%s|\n", context->control_socket_path );
+      return;
+    }
+
+    close ( fd );
+    usleep ( 100000 );
+  }
+
+  fprintf ( stderr, "client error: can't connect to control socket |%s|\n", context->control_socket_path );
+  exit ( 1 );
+}
+
+
+
+
+
 bool
 start_signal_received ( context_p context )
 {
@@ -223,13 +438,14 @@ start_signal_received ( context_p context )
     return true;
   }
 
-  if ( -1 !=  access ( context->start_signal_path, F_OK ) )
+  control_read ( context, 0 );
+
+  if ( context->start_signal_received )
   {
     log ( context, "start signal received\n" );
-    return context->start_signal_received = true;
   }
 
-  return false;
+  return context->start_signal_received;
 }
 
 
@@ -237,15 +453,48 @@ start_signal_received ( context_p context )
 
 
 void
-signal_mercury ( context_p context, char * msg ) 
+report_progress ( context_p context )
 {
-  log ( context, "signalling mercury...\n" );
+  context->last_progress_time  = get_timestamp_seconds();
+  context->last_progress_count = context->total_sent + context->total_received + context->total_accepted;
+  control_send ( context, 
+                 "progress %d %d %d %d %d %d", 
+                 context->total_sent,
+                 context->total_received,
+                 context->total_accepted,
+                 context->rejected,
+                 context->released,
+                 context->modified
+               );
+}
 
-  char done_signal [ 1000 ];
-  sprintf ( done_signal, "%s/%s_%d", context->events_path, msg, getpid() );
-  FILE * fp = fopen ( done_signal, "w" );
-  fprintf ( fp, "\n" );
-  fclose ( fp );
+
+
+
+
+/*
+  Call this often while messages are moving. It reads Mercury's
+  commands -- a sender must see "stop" while it is still sending
+  -- and reports progress once a second if anything has changed,
+  however slowly the messages go. Nothing is reported while
+  nothing moves, so that Mercury can still tell a stall.
+*/
+void
+control_tick ( context_p context )
+{
+  double now = get_timestamp_seconds();
+
+  if ( now - context->last_control_check >= 0.1 )
+  {
+    context->last_control_check = now;
+    control_read ( context, 0 );
+  }
+
+  if ( now - context->last_progress_time >= 1.0  &&
+       context->total_sent + context->total_received + context->total_accepted != context->last_progress_count )
+  {
+    report_progress ( context );
+  }
 }
 
 
@@ -328,22 +577,26 @@ dump_flight_times ( context_p context )
 void
 wait_for_dump_data_signal ( context_p context )
 {
-  char signal_path[1000];
-  sprintf ( signal_path, "%s/dump_data", context->events_path );
-
-  while ( 1 )
+  while ( ! context->dump_requested )
   { 
-    if ( -1 !=  access ( signal_path, F_OK ) )
+    if ( context->stop_requested )
     {
-      log ( context, "dumping data\n" );
-      log ( context, "total_bytes_received %zu\n", context->bytes_received );
-      dump_flight_times ( context );
+      log ( context, "stop requested: not dumping data.\n" );
+      return;
+    }
+
+    if ( ! control_read ( context, 1000 ) )
+    {
+      log ( context, "error : control channel lost while waiting for dump_data.\n" );
       return;
     }
-    
-    log ( context, "still waiting for |%s|\n", signal_path );
-    sleep ( 5 );
   }
+
+  log ( context, "dumping data\n" );
+  log ( context, "total_bytes_received %zu\n", context->bytes_received );
+  int n_flight_times = context->sending ? 0 : context->n_flight_times;
+  dump_flight_times ( context );
+  control_send ( context, "dumped %d", n_flight_times );
 }
 
 
@@ -511,6 +764,14 @@ send_message ( context_p context )
     return;
   }
 
+  control_tick ( context );
+  if ( context->stop_requested )
+  {
+    log ( context, "stop requested: sender halting.\n" );
+    halt ( context );
+    return;
+  }
+
   double now = get_timestamp_seconds();
   //double time_since_start = now - context->grand_start_time;
 
@@ -650,12 +911,38 @@ process_event ( context_p context, pn_event_t * event )
     case PN_CONNECTION_BOUND: 
       event_transport = pn_event_transport ( event );
       pn_transport_require_auth ( event_transport, false );
-      pn_sasl_allowed_mechs ( pn_sasl(event_transport), "ANONYMOUS" );
+      if ( context->sasl_mechanism )
+      {
+        pn_sasl_allowed_mechs ( pn_sasl(event_transport), context->sasl_mechanism );
+      }
+      else
+      {
+        pn_sasl_allowed_mechs ( pn_sasl(event_transport), "ANONYMOUS" );
+      }
+      if ( context->ssl_domain )
+      {
+        pn_ssl_t * ssl = pn_ssl ( event_transport );
+        if ( pn_ssl_init ( ssl, context->ssl_domain, 0 ) )
+        {
+          log ( context, "can't set up TLS on the connection.\n" );
+        }
+        else
+        if ( context->ssl_verify_peer_name )
+        {
+          pn_ssl_set_peer_hostname ( ssl, context->host );
+        }
+      }
     break;
 
 
     case PN_CONNECTION_REMOTE_OPEN : 
       pn_connection_open ( pn_event_connection( event ) ); 
+      if ( context->connect_msec < 0 )
+      {
+        context->connect_msec = 1000.0 * (get_timestamp_seconds() - context->connect_start_time);
+        log ( context, "connection opened in %.3f msec.\n", context->connect_msec );
+        control_send ( context, "connected %.3f", context->connect_msec );
+      }
     break;
 
 
@@ -752,9 +1039,23 @@ process_event ( context_p context, pn_event_t * event )
           case PN_ACCEPTED:
             context->accepted ++;
             context->total_accepted ++;
+            control_tick ( context );
+
+            if ( ! ( context->total_accepted % context->report_frequency ) )
+            {
+              report_progress ( context );
+            }
 
             if ( context->total_accepted >= context->total_expected_messages && (! context->soak) )
             {
+              control_send ( context, 
+                             "done_sending %d %d %d %d %d", 
+                             context->total_sent,
+                             context->total_accepted,
+                             context->rejected,
+                             context->released,
+                             context->modified
+                           );
               double send_stop_time = get_timestamp_seconds();
               double total_time = send_stop_time - context->send_start_time;
               double throughput = context->total_accepted / total_time;
@@ -797,6 +1098,13 @@ process_event ( context_p context, pn_event_t * event )
         // As the receiver, we only count that a message has been received.
         context->received ++;
         context->total_received ++;
+        control_tick ( context );
+        if ( context->stop_requested )
+        {
+          log ( context, "stop requested: receiver halting.\n" );
+          halt ( context );
+          break;
+        }
 
         /*
         */
@@ -805,6 +1113,11 @@ process_event ( context_p context, pn_event_t * event )
           log ( context, "%d messages received, %ld bytes.\n", context->total_received, context->bytes_received );
         }
 
+        if ( ! ( context->total_received % context->report_frequency ) )
+        {
+          report_progress ( context );
+        }
+
 
         int index = find_addr ( context, event_link );
         if ( index < 0 )
@@ -821,10 +1134,10 @@ process_event ( context_p context, pn_event_t * event )
         // we either dump stats and keep going, or halt.
         if ( context->received >= context->total_expected_messages) 
         {
-          // TODO : do not dump flight times here! Wait for dump signal from Mercury!
-          signal_mercury ( context, (char *) "done_receiving" );
+          // Flight times are not dumped here. Mercury will tell
+          // us when to do that, once all receivers are done.
+          control_send ( context, "done_receiving %d", context->total_received );
 
-          //dump_flight_times ( context );
           if ( ! context->soak )
           {
             log ( context, "%d messages received. receiver halting.\n", context->total_received );
@@ -919,7 +1232,26 @@ init_context ( context_p context, int argc, char ** argv )
   context->start_signal_received   = false;
   context->dumped_flight_times     = false;
   context->soak                    = false;
-  context->report_frequency        = 10000;
+  context->report_frequency        = 1000;
+  context->last_progress_time      = 0;
+  context->last_control_check      = 0;
+  context->last_progress_count     = 0;
+  context->connect_start_time      = 0;
+  context->connect_msec            = -1;
+
+  context->control_socket_path[0]  = 0;
+  context->control_fd              = -1;
+  context->control_buffered        = 0;
+  context->dump_requested          = false;
+  context->stop_requested          = false;
+
+  context->ssl_ca_file             = 0;
+  context->ssl_verify_peer_name    = true;
+  context->ssl_domain              = 0;
+
+  context->sasl_mechanism          = 0;
+  context->sasl_user               = 0;
+  context->sasl_password           = 0;
 
 
   for ( int i = 1; i < argc; ++ i )
@@ -1024,12 +1356,11 @@ init_context ( context_p context, int argc, char ** argv )
               );
       i ++;
     }
-    // events_path ----------------------------------------------
+    // control_socket ----------------------------------------------
     else
-    if ( ! strcmp ( "--events_path", argv[i] ) )
+    if ( ! strcmp ( "--control_socket", argv[i] ) )
     {
-      strcpy ( context->events_path, NEXT_ARG );
-      sprintf ( context->start_signal_path, "%s/start_sending", context->events_path );
+      strcpy ( context->control_socket_path, NEXT_ARG );
       i ++;
     }
     // messages ----------------------------------------------
@@ -1049,6 +1380,54 @@ init_context ( context_p context, int argc, char ** argv )
       // is still used -- but it only controls how many flight times are
       // stored until they are dumped. Then the array starts to fill again.
     }
+    // ssl_ca ----------------------------------------------
+    else
+    if ( ! strcmp ( "--ssl_ca", argv[i] ) )
+    {
+      context->ssl_ca_file = strdup ( NEXT_ARG );
+      i ++;
+    }
+    // ssl_verify ----------------------------------------------
+    else
+    if ( ! strcmp ( "--ssl_verify", argv[i] ) )
+    {
+      if ( ! strcmp ( "peer_name", NEXT_ARG ) )
+      {
+        context->ssl_verify_peer_name = true;
+      }
+      else
+      if ( ! strcmp ( "peer", NEXT_ARG ) )
+      {
+        context->ssl_verify_peer_name = false;
+      }
+      else
+      {
+        fprintf ( stderr, "value for --ssl_verify should be 'peer' or 'peer_name'.\n" );
+        exit ( 1 );
+      }
+      i ++;
+    }
+    // sasl_mechanism ----------------------------------------------
+    else
+    if ( ! strcmp ( "--sasl_mechanism", argv[i] ) )
+    {
+      context->sasl_mechanism = strdup ( NEXT_ARG );
+      i ++;
+    }
+    // sasl_user ----------------------------------------------
+    else
+    if ( ! strcmp ( "--sasl_user", argv[i] ) )
+    {
+      context->sasl_user = strdup ( NEXT_ARG );
+      i ++;
+    }
+    // sasl_password ----------------------------------------------
+    else
+    if ( ! strcmp ( "--sasl_password", argv[i] ) )
+    {
+      context->sasl_password = strdup ( NEXT_ARG );
+      i ++;
+    }
     // unknown ----------------------------------------------
     else
     {
@@ -1082,7 +1461,11 @@ log_context ( context_p context )
   log_no_timestamp ( context, "  log                : %s\n", context->log_file_name );
   log_no_timestamp ( context, "  messages           : %d\n", context->expected_messages );
   log_no_timestamp ( context, "  soak               : %s\n", context->soak ? "true" : "false" );
-  log_no_timestamp ( context, "  events path        : %s\n", context->events_path );
+  log_no_timestamp ( context, "  control socket     : %s\n", context->control_socket_path );
+  log_no_timestamp ( context, "  ssl ca             : %s\n", context->ssl_ca_file ? context->ssl_ca_file : "none" );
+  log_no_timestamp ( context, "  ssl verify         : %s\n", context->ssl_verify_peer_name ? "peer_name" : "peer" );
+  log_no_timestamp ( context, "  sasl mechanism     : %s\n", context->sasl_mechanism ? context->sasl_mechanism : "ANONYMOUS" );
+  log_no_timestamp ( context, "  sasl user          : %s\n", context->sasl_user ? context->sasl_user : "none" );
   log_no_timestamp ( context, "}\n" );
 }
 
@@ -1125,11 +1508,32 @@ main ( int argc, char ** argv )
 
   context.message = pn_message();
 
+  control_connect ( & context );
+
+  if ( context.ssl_ca_file )
+  {
+    context.ssl_domain = pn_ssl_domain ( PN_SSL_MODE_CLIENT );
+    if ( ! context.ssl_domain  ||
+         pn_ssl_domain_set_trusted_ca_db ( context.ssl_domain, context.ssl_ca_file ) ||
+         pn_ssl_domain_set_peer_authentication ( context.ssl_domain,
+                                                 context.ssl_verify_peer_name ? PN_SSL_VERIFY_PEER_NAME : PN_SSL_VERIFY_PEER,
+                                                 context.ssl_ca_file ) )
+    {
+      fprintf ( stderr, "can't use |%s| as the TLS CA.\n", context.ssl_ca_file );
+      exit ( 1 );
+    }
+  }
+
 
   char addr[PN_MAX_ADDR];
   pn_proactor_addr ( addr, sizeof(addr), context.host, context.port );
   context.proactor   = pn_proactor();
   context.connection = pn_connection();
+  if ( context.sasl_user )
+    pn_connection_set_user ( context.connection, context.sasl_user );
+  if ( context.sasl_password )
+    pn_connection_set_password ( context.connection, context.sasl_password );
+  context.connect_start_time = get_timestamp_seconds();
   pn_proactor_connect ( context.proactor, context.connection, addr );
 
   int batch_done = 0;
@@ -1157,6 +1561,9 @@ main ( int argc, char ** argv )
   log ( & context, "Waiting for dump_data signal.\n" );
   wait_for_dump_data_signal ( & context );
 
+  if ( context.control_fd >= 0 )
+    close ( context.control_fd );
+
   log ( & context, "client exiting.\n" );
 
   return 0;
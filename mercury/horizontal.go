@@ -1,17 +1,12 @@
 package main
 
 import (
-            "bufio"
             "fmt"
-            "io/ioutil"
             "os"
-            "os/exec"
-            "path/filepath"
-            "sort"
-            "strconv"
-            "strings"
             "time"
 
+            "chart"
+            "results"
          rn "router_network"
             "utils"
        )
@@ -22,41 +17,13 @@ var fp=fmt.Fprintf
 
 
 
-type message_result struct {
-  arrival_time float64 
-  latency      float64
-}
-
-
-type message_result_list [] *message_result
-
-
-// Functions to satisfy the Sort interface.
-func ( mrl message_result_list ) Len ( ) ( int ) {
-  return len ( mrl )
-}
-
-
-func ( mrl message_result_list ) Less ( i, j int ) bool {
-  return mrl[i].arrival_time < mrl[j].arrival_time
-}
-
-
-func ( mrl message_result_list ) Swap ( i, j int ) {
-  mrl[i] , mrl[j] = mrl[j], mrl[i]
-}
-
-
-
-
-
 type test_result struct {
   test_time      time.Time
   n_routers      int
   n_client_pairs int
-  results        message_result_list
+  results        * results.Results
   mean_latency   float64
-  layency_99     float64
+  latency_99     float64
 }
 
 
@@ -74,54 +41,13 @@ func new_test_result ( test_time time.Time, n_routers, n_pairs int ) ( * test_re
 
 
 
-func ( t * test_result ) read ( dir string, signifier string ) ( error ) {
-  // Get a list of all the file names in 'dir' 
-  // whose names contain 'signifier'.
-  var file_names [] string
-  _ = filepath.Walk ( dir,
-                      func ( path string, info os.FileInfo, err error) error {
-                        if ! info.IsDir ( ) {
-                          if strings.Contains ( path, signifier ) {
-                            file_names = append ( file_names, path )
-                          }
-                        }
-                        return nil
-                      } )
-
-  for _, file_name := range file_names {
-    // Get the message results out of the file.
-    // Two floats per line.
-    content, err := ioutil.ReadFile ( file_name )
-    if err != nil {
-      return err
-    }
-
-    lines := strings.Split ( string(content), "\n" )
-
-    for _, line := range lines {
-
-      if line == "" {
-        // This file is finished.
-        break
-      }
-
-      numbers := strings.Split ( line, " " )
-      result := message_result{}
-      result.arrival_time, err = strconv.ParseFloat ( numbers[0], 64 )
-      if err != nil {
-        return err
-      }
-      result.latency, err      = strconv.ParseFloat ( numbers[1], 64 )
-      if err != nil {
-        return err
-      }
-      t.results = append ( t.results, &result )
-    }
+func ( t * test_result ) read ( dir string ) ( error ) {
+  var err error
+  t.results, err = results.Load ( dir )
+  if err == nil {
+    fp ( os.Stdout, "read: %d results.\n", len ( t.results.Messages ) )
   }
-
-  fp ( os.Stdout, "MDEBUG read: %d results.\n", len ( t.results ) )
-
-  return nil
+  return err
 }
 
 
@@ -130,71 +56,18 @@ func ( t * test_result ) read ( dir string, signifier string ) ( error ) {
 
 func ( t * test_result ) process ( graphics_path string ) {
 
-  sort.Sort ( message_result_list ( t.results ) )
-
-  first_time := t.results[0].arrival_time
-
-  for _, result := range ( t.results ) {
-    result.arrival_time -= first_time
-  }
-
   // Delete first and last 1-second intervals.
-  last_time  := t.results[len(t.results)-1].arrival_time
+  trimmed := t.results.Trim ( time.Second, time.Second )
 
-  for _, result := range ( t.results ) {
+  stats := trimmed.Stats ( 99 )
+  t.mean_latency = stats.Mean
+  t.latency_99   = stats.Percentiles[0]
 
-    // first 1-second
-    if result.arrival_time < 1.0 {
-      result.arrival_time = -1.0
-    }
-
-    // last 1-second
-    if last_time - result.arrival_time <= 1.0 {
-      result.arrival_time = -1.0
-    }
-  }
-
-
-  // Make the timeline data file.
-  f, _ := os.Create ( graphics_path + "/timeline.data" )
-  defer f.Close()
-  w := bufio.NewWriter ( f )
-
-  for _, result := range ( t.results ) {
-    if result.arrival_time >= 0 {
-      fp ( w, "%.6f %.6f\n", result.arrival_time, result.latency )
-    }
-  }
-  w.Flush ( )
-
-  // Make the timeline gnuplot file.
-  gnuplot_script := "set autoscale\n"
-  gnuplot_script += "unset key\n"
-  gnuplot_script += "set ylabel \"latency\"\n"
-  gnuplot_script += "set xlabel \"time\"\n"
-  gnuplot_script += "set terminal jpeg size 2000,500\n"
-  gnuplot_script += fmt.Sprintf ( "set output \"timeline_n-routers_%d_n-clients_%d.jpg\"\n", t.n_routers, t.n_client_pairs )
-  gnuplot_script += fmt.Sprintf ( "set title \"Timeline View of Trimmed Data -- %d Client-Pairs\"\n", t.n_client_pairs )
-  gnuplot_script += "plot \"timeline.data\" with points\n"
-
-  // Write out the gnuplot script.
-  gnuplot_script_name := graphics_path + "/timeline.gplot"
-  file, err := os.Create ( gnuplot_script_name )
-  if err != nil {
-    fp ( os.Stdout, "Can't create gplot script file: |%s|\n", err.Error() )
-    os.Exit ( 1 )
-  }
-  defer file.Close()
-  fmt.Fprintf ( file, "%s", gnuplot_script )
-
-  args_list := [] string { "timeline.gplot" }
-  command := exec.Command ( "/usr/bin/gnuplot", args_list ... )
-  command.Dir = graphics_path
-  //err = command.Run ( )
-  output, err := command.CombinedOutput ( )
-  if err != nil {
-    fp ( os.Stdout, "test_result.process : error running gnuplot: stdout: |%s|  error: |%s|\n", output, err.Error() )
-    return
+  c := chart.Timeline ( trimmed, fmt.Sprintf ( "Timeline View of Trimmed Data -- %d Client-Pairs", t.n_client_pairs ) )
+  c.Width, c.Height = 2000, 500
+  base_name := fmt.Sprintf ( "timeline_n-routers_%d_n-clients_%d", t.n_routers, t.n_client_pairs )
+  if err := c.Write_all ( graphics_path, base_name ); err != nil {
+    fp ( os.Stdout, "test_result.process : %s\n", err.Error() )
   }
 }
 
@@ -202,53 +75,12 @@ func ( t * test_result ) process ( graphics_path string ) {
 
 
 
-func listen_for_messages_from_clients ( event_path string, 
-                                        receiver_count int,
-                                        client_events_channel chan string ) {
-  previous_count := 0
-  same_count     := 0
-
-  for {
-    time.Sleep ( 5 * time.Second )
-
-    done_receiving_count := 0
-    files, _ := ioutil.ReadDir ( event_path )
-    for _, f := range files {
-      if strings.HasPrefix ( f.Name(), "done_receiving" ) {
-        done_receiving_count ++
-      }
-    }
-
-    if done_receiving_count > 0 {
-      if done_receiving_count == previous_count {
-        same_count ++
-      }
-
-      if same_count > 5 {
-        client_events_channel <- "not changing"
-        break
-      }
-
-      previous_count = done_receiving_count
-    }
-
-    if done_receiving_count >= receiver_count {
-      client_events_channel <- "done receiving"
-      break
-    }
-  }
-}
-
-
-
-
 
 func run_linear_network ( test_name    string,
                           run_name     string, 
                           mercury_root string,
                           n_routers    int,
-                          n_pairs      int,
-                          client_events_channel chan string ) ( string )  {
+                          n_pairs      int ) ( string )  {
 
   log_path    := test_name + "/" + run_name + "/log"
   config_path := test_name + "/" + run_name + "/config"
@@ -271,40 +103,28 @@ func run_linear_network ( test_name    string,
                                    "/home/mick/latest/install/dispatch" )
 
   // N router linear network in which each connects to the previous.
-  first_router_name := 'A'
-  last_router_name  := first_router_name
-
-  for i := 0; i < n_routers; i ++ {
-    current_router   := 'A' + i
-    last_router_name = rune(current_router)
-    network.Add_router ( string(rune(current_router)),
-                         "latest",
-                         config_path,
-                         log_path )
-    if i > 0 {
-      network.Connect_router ( string(rune(current_router)), 
-                               string(rune(current_router - 1)) )
-    }
+  router_names, err := network.Add_linear ( n_routers, "latest", config_path, log_path )
+  if err != nil {
+    fp ( os.Stdout, "run_linear_network error: %s\n", err.Error() )
+    os.Exit ( 1 )
   }
+  first_router_name := router_names [ 0 ]
+  last_router_name  := router_names [ len(router_names) - 1 ]
 
   network.Init ( )
   network.Set_results_path ( result_path )
   network.Set_events_path  ( event_path )
 
-  // Send both signals right now.
-  // TODO -- do these the right way.
-  os.Create ( event_path + "/start_sending" )
-  os.Create ( event_path + "/dump_data" )
-
   for i := 0; i < n_pairs; i ++ {
 
     sender_name := fmt.Sprintf ( "sender_%05d", i )
 
     network.Add_sender ( sender_name,
                          ".",        // config_path
+                         "0.0.0.0",  // host
                          100,        // n_messages
                          100,        // max_message_length  -- TODO get rid of this.
-                         string(rune(first_router_name)),
+                         first_router_name,
                          "100",      // throttle (msec)
                          "0",        // delay               -- and this
                          "0" )       // soak                -- and this
@@ -312,9 +132,10 @@ func run_linear_network ( test_name    string,
     receiver_name := fmt.Sprintf ( "receiver_%05d", i )
     network.Add_receiver ( receiver_name,
                            ".",
+                           "0.0.0.0",
                            100,
                            100,
-                           string(rune(last_router_name)),
+                           last_router_name,
                            "0",
                            "0" )
     
@@ -328,22 +149,18 @@ func run_linear_network ( test_name    string,
 
   fp ( os.Stdout, "network |%s| is running.\n", run_name )
 
-  go listen_for_messages_from_clients ( event_path, 
-                                        n_pairs,
-                                        client_events_channel ) 
-
-  for {
-    msg := <- client_events_channel
-
-    switch msg {
-      case "done receiving" :
-        fp ( os.Stdout, "test ran successfully.\n" )
+  // Send both signals right now.
+  network.Start_sending ( )
+  network.Dump_data ( )
 
-      default :
-        fp ( os.Stdout, "test failed.\n" )
-    }
+  if err := network.Wait_for_receivers ( 0 ); err != nil {
+    fp ( os.Stdout, "test failed: %s\n", err.Error() )
+  } else {
+    fp ( os.Stdout, "test ran successfully.\n" )
+  }
 
-    break
+  if err := network.Wait_for_dumps ( 60 * time.Second ); err != nil {
+    fp ( os.Stdout, "clients did not all dump their data: %s\n", err.Error() )
   }
   
   network.Halt ( );
@@ -358,8 +175,7 @@ func run_linear_network ( test_name    string,
 func run_horizontal_network ( run_name              string, 
                               mercury_root          string,
                               n_routers             int,
-                              n_pairs_per_router    int,
-                              client_events_channel chan string ) ( string )  {
+                              n_pairs_per_router    int ) ( string )  {
 
   log_path    := run_name + "/log"
   config_path := run_name + "/config"
@@ -381,16 +197,10 @@ func run_horizontal_network ( run_name              string,
                                    "/home/mick/latest/install/dispatch" )
 
   // N router linear network in which each connects to the previous.
-  for i := 0; i < n_routers; i ++ {
-    current_router   := 'A' + i
-    network.Add_router ( string(rune(current_router)),
-                         "latest",
-                         config_path,
-                         log_path )
-    if i > 0 {
-      network.Connect_router ( string(rune(current_router)), 
-                               string(rune(current_router - 1)) )
-    }
+  router_names, err := network.Add_linear ( n_routers, "latest", config_path, log_path )
+  if err != nil {
+    fp ( os.Stdout, "run_horizontal_network error: %s\n", err.Error() )
+    os.Exit ( 1 )
   }
 
   network.Init ( )
@@ -399,13 +209,14 @@ func run_horizontal_network ( run_name              string,
 
 
   for i := 0; i < n_routers; i ++ {
-    current_router := string(rune('A' + i))
+    current_router := router_names [ i ]
     for j := 0; j < n_pairs_per_router; j ++ {
 
       sender_name := fmt.Sprintf ( "sender_%d_%05d", i, j )
 
       network.Add_sender ( sender_name,
                            ".",        // config_path
+                           "0.0.0.0",  // host
                            1000000,    // n_messages
                            100,        // max_message_length  -- TODO get rid of this.
                            current_router,
@@ -416,6 +227,7 @@ func run_horizontal_network ( run_name              string,
       receiver_name := fmt.Sprintf ( "receiver_%d_%05d", i, j )
       network.Add_receiver ( receiver_name,
                              ".",
+                             "0.0.0.0",
                              1000000,
                              100,
                              current_router,
@@ -429,32 +241,26 @@ func run_horizontal_network ( run_name              string,
   }
                         
 
-  network.Run  ( )
+  if err := network.Run ( ); err != nil {
+    fp ( os.Stdout, "run_horizontal_network error: %s\n", err.Error() )
+    network.Halt ( )
+    os.Exit ( 1 )
+  }
 
   fp ( os.Stdout, "network |%s| is running.\n", run_name )
 
+  network.Start_sending ( )
   time.Sleep ( 5 * time.Second )
-  // TODO make a fn "send_signal" 
-  os.Create ( event_path + "/start_sending" )
-  time.Sleep ( 5 * time.Second )
-  os.Create ( event_path + "/dump_data" )
-
-  go listen_for_messages_from_clients ( event_path, 
-                                        n_pairs_per_router * n_routers,
-                                        client_events_channel ) 
-
-  for {
-    msg := <- client_events_channel
-
-    switch msg {
-      case "done receiving" :
-        fp ( os.Stdout, "test ran successfully.\n" )
+  network.Dump_data ( )
 
-      default :
-        fp ( os.Stdout, "test failed: |%s|.\n", msg )
-    }
+  if err := network.Wait_for_receivers ( 0 ); err != nil {
+    fp ( os.Stdout, "test failed: %s\n", err.Error() )
+  } else {
+    fp ( os.Stdout, "test ran successfully.\n" )
+  }
 
-    break
+  if err := network.Wait_for_dumps ( 60 * time.Second ); err != nil {
+    fp ( os.Stdout, "clients did not all dump their data: %s\n", err.Error() )
   }
   
   network.Halt ( );
@@ -467,9 +273,10 @@ func run_horizontal_network ( run_name              string,
 
 
 func main ( ) {
+  // Don't leave routers running if anything goes badly wrong.
+  defer rn.Halt_all_on_panic ( )
 
   mercury_root := os.Getenv ( "MERCURY_ROOT" )
-  client_events_channel := make ( chan string, 5 )
 
 
   n_routers := 4
@@ -478,11 +285,18 @@ func main ( ) {
   graphics_path := run_name + "/graphics"
   utils.Find_or_create_dir ( graphics_path )
 
-  run_horizontal_network ( run_name, 
-                           mercury_root, 
-                           n_routers, 
-                           n_pairs_per_router,
-                           client_events_channel )
+  results_dir := run_horizontal_network ( run_name, 
+                                          mercury_root, 
+                                          n_routers, 
+                                          n_pairs_per_router )
+
+  result := new_test_result ( time.Now(), n_routers, n_routers * n_pairs_per_router )
+  if err := result.read ( results_dir ); err != nil {
+    fp ( os.Stdout, "can't read results: %s\n", err.Error() )
+    os.Exit ( 1 )
+  }
+  result.process ( graphics_path )
+  fp ( os.Stdout, "mean latency %.3f msec, 99th percentile %.3f msec\n", result.mean_latency, result.latency_99 )
 
   fp ( os.Stdout, "main: done.\n" )
 
@@ -37,25 +37,13 @@ func run_test ( test_name string, mercury_root string, n_routers int ) ( string
                                    "/home/mick/latest/install/proton",
                                    "/home/mick/latest/install/dispatch" )
 
-  // Make the routers -------------------------------
-  for i := 0; i < n_routers; i ++ {
-    router_name := fmt.Sprintf ( "%X", i )
-    network.Add_router ( router_name,
-                         "latest",
-                         config_path,
-                         log_path )
-    fp ( os.Stdout, "Add router |%s|\n", router_name )
-  }
-
-  // Connect them  ----------------------------------
-  for i := 0; i < n_routers; i ++ {
-    current_router_name := fmt.Sprintf ( "%X", i )
-    for j := i + 1; j < n_routers; j ++ {
-      connect_to_router_name := fmt.Sprintf ( "%X", j )
-      network.Connect_router ( current_router_name, connect_to_router_name )
-      fp ( os.Stdout, "Connect |%s| to |%s|\n", current_router_name, connect_to_router_name )
-    }
+  // Make the routers, and connect each one to all the others.
+  router_names, err := network.Add_mesh ( n_routers, "latest", config_path, log_path )
+  if err != nil {
+    fp ( os.Stdout, "run_test error: %s\n", err.Error() )
+    os.Exit ( 1 )
   }
+  fp ( os.Stdout, "Added routers %v\n", router_names )
 
 
   network.Init ( )
@@ -77,6 +65,8 @@ func run_test ( test_name string, mercury_root string, n_routers int ) ( string
 
 
 func main ( ) {
+  // Don't leave routers running if anything goes badly wrong.
+  defer rn.Halt_all_on_panic ( )
 
   mercury_root := os.Getenv ( "MERCURY_ROOT" )
   test_name := "mesh" + "_" + time.Now().Format ( "2006_01_02_1504" )
@@ -0,0 +1,317 @@
+package chart
+
+import ( "errors"
+         "fmt"
+         "math"
+         "strconv"
+         "strings"
+       )
+
+
+var fp = fmt.Fprintf
+
+
+
+
+
+/*===================================================================
+
+  Simple charts of test results, drawn without any help from
+  outside programs. A chart is a set of series on one pair of
+  linear axes, and can be written as SVG or PNG.
+
+===================================================================*/
+
+type Style int
+
+const (
+  Points Style = iota   // a dot at each point
+  Lines                 // points joined by lines, with a dot at each
+  Bars                  // a bar from zero up to each point
+)
+
+
+
+
+
+type Point struct {
+  X, Y float64
+}
+
+
+
+
+
+type Series struct {
+  Name          string
+  Color         string     // "#rrggbb"
+  Style         Style
+  Points     [] Point
+
+  // For Bars : how wide each bar is, in X units.
+  Bar_width     float64
+}
+
+
+
+
+
+type Chart struct {
+  Title         string
+  X_label       string
+  Y_label       string
+  Width         int        // pixels
+  Height        int
+
+  Series     [] * Series
+}
+
+
+
+
+
+// Colors that series get if they don't ask for one.
+var palette = [] string { "#d62728", // red
+                          "#e6ac00", // gold
+                          "#1f77b4", // blue
+                          "#2ca02c", // green
+                          "#9467bd", // purple
+                          "#8c564b", // brown
+                        }
+
+// Space around the plot area, in pixels.
+const ( margin_left   = 80
+        margin_right  = 30
+        margin_top    = 50
+        margin_bottom = 60
+      )
+
+
+
+
+
+func New_chart ( title, x_label, y_label string ) ( * Chart ) {
+  return & Chart { Title   : title,
+                   X_label : x_label,
+                   Y_label : y_label,
+                   Width   : 1200,
+                   Height  : 500 }
+}
+
+
+
+
+
+/*
+  Add a series to the chart, and return it so the
+  caller can add points to it.
+*/
+func ( c * Chart ) Add_series ( name string, style Style ) ( * Series ) {
+  s := & Series { Name  : name,
+                  Style : style,
+                  Color : palette [ len(c.Series) % len(palette) ] }
+  c.Series = append ( c.Series, s )
+  return s
+}
+
+
+
+
+
+func ( s * Series ) Add ( x, y float64 ) {
+  s.Points = append ( s.Points, Point { x, y } )
+}
+
+
+
+
+
+/*
+  The ranges of the axes, and the tick marks on each.
+*/
+type axes struct {
+  x_min, x_max     float64
+  y_min, y_max     float64
+  x_ticks       [] float64
+  y_ticks       [] float64
+}
+
+
+
+
+
+func ( c * Chart ) axes ( ) ( * axes, error ) {
+  a := & axes { x_min : math.Inf(1), x_max : math.Inf(-1),
+                y_min : math.Inf(1), y_max : math.Inf(-1) }
+
+  for _, s := range c.Series {
+    for _, p := range s.Points {
+      a.x_min = math.Min ( a.x_min, p.X )
+      a.y_min = math.Min ( a.y_min, p.Y )
+      a.x_max = math.Max ( a.x_max, p.X )
+      a.y_max = math.Max ( a.y_max, p.Y )
+      if s.Style == Bars {
+        a.x_max = math.Max ( a.x_max, p.X + s.Bar_width )
+      }
+    }
+    // Bars always stand on zero.
+    if s.Style == Bars {
+      a.y_min = math.Min ( a.y_min, 0 )
+    }
+  }
+
+  if math.IsInf ( a.x_min, 1 ) {
+    return nil, errors.New ( "chart has no points" )
+  }
+
+  // Latencies and counts look wrong if the axis does not start at zero.
+  if a.y_min > 0 {
+    a.y_min = 0
+  }
+
+  a.x_min, a.x_max, a.x_ticks = nice_range ( a.x_min, a.x_max )
+  a.y_min, a.y_max, a.y_ticks = nice_range ( a.y_min, a.y_max )
+  return a, nil
+}
+
+
+
+
+
+/*
+  Widen [min, max] out to round numbers, and choose
+  about five evenly spaced ticks in between.
+*/
+func nice_range ( min, max float64 ) ( float64, float64, [] float64 ) {
+  if max <= min {
+    if min == 0 {
+      max = 1
+    } else {
+      spread := math.Abs ( min ) / 10
+      min, max = min - spread, max + spread
+    }
+  }
+
+  step := nice_step ( ( max - min ) / 5 )
+  min   = math.Floor ( min / step ) * step
+  max   = math.Ceil  ( max / step ) * step
+
+  var ticks [] float64
+  for t := min; t <= max + step / 2; t += step {
+    ticks = append ( ticks, t )
+  }
+  return min, max, ticks
+}
+
+
+
+
+
+// The nearest 1, 2, or 5 times a power of ten.
+func nice_step ( rough float64 ) ( float64 ) {
+  power    := math.Pow ( 10, math.Floor ( math.Log10 ( rough ) ) )
+  fraction := rough / power
+
+  switch {
+    case fraction < 1.5 : return 1 * power
+    case fraction < 3.5 : return 2 * power
+    case fraction < 7.5 : return 5 * power
+  }
+  return 10 * power
+}
+
+
+
+
+
+func tick_label ( t float64 ) ( string ) {
+  return strconv.FormatFloat ( t, 'g', 6, 64 )
+}
+
+
+
+
+
+/*
+  Where the plot area is, and how to get there from data.
+*/
+type frame struct {
+  a                * axes
+  left, top        float64
+  width, height    float64
+}
+
+
+
+
+
+func ( c * Chart ) frame ( ) ( * frame, error ) {
+  a, err := c.axes ( )
+  if err != nil {
+    return nil, err
+  }
+  if c.Width <= margin_left + margin_right || c.Height <= margin_top + margin_bottom {
+    return nil, fmt.Errorf ( "chart is too small: %d x %d", c.Width, c.Height )
+  }
+  return & frame { a      : a,
+                   left   : margin_left,
+                   top    : margin_top,
+                   width  : float64 ( c.Width  - margin_left - margin_right ),
+                   height : float64 ( c.Height - margin_top  - margin_bottom ) }, nil
+}
+
+
+
+
+
+func ( f * frame ) x ( x float64 ) ( float64 ) {
+  return f.left + ( x - f.a.x_min ) / ( f.a.x_max - f.a.x_min ) * f.width
+}
+
+
+
+
+
+func ( f * frame ) y ( y float64 ) ( float64 ) {
+  return f.top + f.height - ( y - f.a.y_min ) / ( f.a.y_max - f.a.y_min ) * f.height
+}
+
+
+
+
+
+/*
+  Whether Write_all() exports to gnuplot as well. It is off
+  unless asked for, because gnuplot may not be installed.
+*/
+var Export_gnuplot = false
+
+
+
+
+
+/*
+  Write the chart as dir/base_name.svg and dir/base_name.png, 
+  and export it to gnuplot as well if Export_gnuplot is set.
+  Every format is tried even if an earlier one fails.
+*/
+func ( c * Chart ) Write_all ( dir, base_name string ) ( error ) {
+  var problems [] string
+
+  if err := c.Write_svg ( dir + "/" + base_name + ".svg" ); err != nil {
+    problems = append ( problems, err.Error() )
+  }
+  if err := c.Write_png ( dir + "/" + base_name + ".png" ); err != nil {
+    problems = append ( problems, err.Error() )
+  }
+  if Export_gnuplot {
+    if err := c.Write_gnuplot ( dir, base_name ); err != nil {
+      problems = append ( problems, err.Error() )
+    }
+  }
+
+  if len ( problems ) > 0 {
+    return fmt.Errorf ( "chart %s : %s", base_name, strings.Join ( problems, " ; " ) )
+  }
+  return nil
+}
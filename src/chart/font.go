@@ -0,0 +1,192 @@
+package chart
+
+import ( "image"
+         "image/color"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A small bitmap font for the text in PNG charts, since the
+  standard library has none. Each glyph is 5 columns of 8
+  pixels, bit 0 at the top; bit 7 is only for descenders.
+  It covers printable ASCII, and anything else is drawn as '?'.
+
+===================================================================*/
+
+const ( glyph_width   = 5
+        glyph_height  = 8
+        glyph_advance = glyph_width + 1
+      )
+
+
+
+var glyphs = [ 95 ] [ glyph_width ] uint8 {
+  { 0x00, 0x00, 0x00, 0x00, 0x00 }, // ' '
+  { 0x00, 0x00, 0x5F, 0x00, 0x00 }, // '!'
+  { 0x00, 0x07, 0x00, 0x07, 0x00 }, // '"'
+  { 0x14, 0x7F, 0x14, 0x7F, 0x14 }, // '#'
+  { 0x24, 0x2A, 0x7F, 0x2A, 0x12 }, // '$'
+  { 0x23, 0x13, 0x08, 0x64, 0x62 }, // '%'
+  { 0x36, 0x49, 0x56, 0x20, 0x50 }, // '&'
+  { 0x00, 0x00, 0x07, 0x00, 0x00 }, // '''
+  { 0x00, 0x1C, 0x22, 0x41, 0x00 }, // '('
+  { 0x00, 0x41, 0x22, 0x1C, 0x00 }, // ')'
+  { 0x2A, 0x1C, 0x7F, 0x1C, 0x2A }, // '*'
+  { 0x08, 0x08, 0x3E, 0x08, 0x08 }, // '+'
+  { 0x00, 0x80, 0x70, 0x30, 0x00 }, // ','
+  { 0x08, 0x08, 0x08, 0x08, 0x08 }, // '-'
+  { 0x00, 0x00, 0x60, 0x60, 0x00 }, // '.'
+  { 0x20, 0x10, 0x08, 0x04, 0x02 }, // '/'
+  { 0x3E, 0x51, 0x49, 0x45, 0x3E }, // '0'
+  { 0x00, 0x42, 0x7F, 0x40, 0x00 }, // '1'
+  { 0x72, 0x49, 0x49, 0x49, 0x46 }, // '2'
+  { 0x21, 0x41, 0x49, 0x4D, 0x33 }, // '3'
+  { 0x18, 0x14, 0x12, 0x7F, 0x10 }, // '4'
+  { 0x27, 0x45, 0x45, 0x45, 0x39 }, // '5'
+  { 0x3C, 0x4A, 0x49, 0x49, 0x31 }, // '6'
+  { 0x41, 0x21, 0x11, 0x09, 0x07 }, // '7'
+  { 0x36, 0x49, 0x49, 0x49, 0x36 }, // '8'
+  { 0x46, 0x49, 0x49, 0x29, 0x1E }, // '9'
+  { 0x00, 0x00, 0x14, 0x00, 0x00 }, // ':'
+  { 0x00, 0x40, 0x34, 0x00, 0x00 }, // ';'
+  { 0x00, 0x08, 0x14, 0x22, 0x41 }, // '<'
+  { 0x14, 0x14, 0x14, 0x14, 0x14 }, // '='
+  { 0x00, 0x41, 0x22, 0x14, 0x08 }, // '>'
+  { 0x02, 0x01, 0x59, 0x09, 0x06 }, // '?'
+  { 0x3E, 0x41, 0x5D, 0x59, 0x4E }, // '@'
+  { 0x7C, 0x12, 0x11, 0x12, 0x7C }, // 'A'
+  { 0x7F, 0x49, 0x49, 0x49, 0x36 }, // 'B'
+  { 0x3E, 0x41, 0x41, 0x41, 0x22 }, // 'C'
+  { 0x7F, 0x41, 0x41, 0x41, 0x3E }, // 'D'
+  { 0x7F, 0x49, 0x49, 0x49, 0x41 }, // 'E'
+  { 0x7F, 0x09, 0x09, 0x09, 0x01 }, // 'F'
+  { 0x3E, 0x41, 0x41, 0x51, 0x73 }, // 'G'
+  { 0x7F, 0x08, 0x08, 0x08, 0x7F }, // 'H'
+  { 0x00, 0x41, 0x7F, 0x41, 0x00 }, // 'I'
+  { 0x20, 0x40, 0x41, 0x3F, 0x01 }, // 'J'
+  { 0x7F, 0x08, 0x14, 0x22, 0x41 }, // 'K'
+  { 0x7F, 0x40, 0x40, 0x40, 0x40 }, // 'L'
+  { 0x7F, 0x02, 0x1C, 0x02, 0x7F }, // 'M'
+  { 0x7F, 0x04, 0x08, 0x10, 0x7F }, // 'N'
+  { 0x3E, 0x41, 0x41, 0x41, 0x3E }, // 'O'
+  { 0x7F, 0x09, 0x09, 0x09, 0x06 }, // 'P'
+  { 0x3E, 0x41, 0x51, 0x21, 0x5E }, // 'Q'
+  { 0x7F, 0x09, 0x19, 0x29, 0x46 }, // 'R'
+  { 0x26, 0x49, 0x49, 0x49, 0x32 }, // 'S'
+  { 0x01, 0x01, 0x7F, 0x01, 0x01 }, // 'T'
+  { 0x3F, 0x40, 0x40, 0x40, 0x3F }, // 'U'
+  { 0x1F, 0x20, 0x40, 0x20, 0x1F }, // 'V'
+  { 0x3F, 0x40, 0x38, 0x40, 0x3F }, // 'W'
+  { 0x63, 0x14, 0x08, 0x14, 0x63 }, // 'X'
+  { 0x03, 0x04, 0x78, 0x04, 0x03 }, // 'Y'
+  { 0x61, 0x51, 0x49, 0x45, 0x43 }, // 'Z'
+  { 0x00, 0x7F, 0x41, 0x41, 0x00 }, // '['
+  { 0x02, 0x04, 0x08, 0x10, 0x20 }, // '\'
+  { 0x00, 0x41, 0x41, 0x7F, 0x00 }, // ']'
+  { 0x04, 0x02, 0x01, 0x02, 0x04 }, // '^'
+  { 0x40, 0x40, 0x40, 0x40, 0x40 }, // '_'
+  { 0x00, 0x01, 0x02, 0x04, 0x00 }, // '`'
+  { 0x20, 0x54, 0x54, 0x54, 0x78 }, // 'a'
+  { 0x7F, 0x48, 0x44, 0x44, 0x38 }, // 'b'
+  { 0x38, 0x44, 0x44, 0x44, 0x28 }, // 'c'
+  { 0x38, 0x44, 0x44, 0x48, 0x7F }, // 'd'
+  { 0x38, 0x54, 0x54, 0x54, 0x18 }, // 'e'
+  { 0x08, 0x7E, 0x09, 0x01, 0x02 }, // 'f'
+  { 0x18, 0xA4, 0xA4, 0xA4, 0x7C }, // 'g'
+  { 0x7F, 0x08, 0x04, 0x04, 0x78 }, // 'h'
+  { 0x00, 0x44, 0x7D, 0x40, 0x00 }, // 'i'
+  { 0x40, 0x80, 0x84, 0x7D, 0x00 }, // 'j'
+  { 0x7F, 0x10, 0x28, 0x44, 0x00 }, // 'k'
+  { 0x00, 0x41, 0x7F, 0x40, 0x00 }, // 'l'
+  { 0x7C, 0x04, 0x18, 0x04, 0x78 }, // 'm'
+  { 0x7C, 0x08, 0x04, 0x04, 0x78 }, // 'n'
+  { 0x38, 0x44, 0x44, 0x44, 0x38 }, // 'o'
+  { 0xFC, 0x24, 0x24, 0x24, 0x18 }, // 'p'
+  { 0x18, 0x24, 0x24, 0x24, 0xFC }, // 'q'
+  { 0x7C, 0x08, 0x04, 0x04, 0x08 }, // 'r'
+  { 0x48, 0x54, 0x54, 0x54, 0x24 }, // 's'
+  { 0x04, 0x3F, 0x44, 0x40, 0x20 }, // 't'
+  { 0x3C, 0x40, 0x40, 0x20, 0x7C }, // 'u'
+  { 0x1C, 0x20, 0x40, 0x20, 0x1C }, // 'v'
+  { 0x3C, 0x40, 0x30, 0x40, 0x3C }, // 'w'
+  { 0x44, 0x28, 0x10, 0x28, 0x44 }, // 'x'
+  { 0x1C, 0xA0, 0xA0, 0xA0, 0x7C }, // 'y'
+  { 0x44, 0x64, 0x54, 0x4C, 0x44 }, // 'z'
+  { 0x00, 0x08, 0x36, 0x41, 0x00 }, // '{'
+  { 0x00, 0x00, 0x7F, 0x00, 0x00 }, // '|'
+  { 0x00, 0x41, 0x36, 0x08, 0x00 }, // '}'
+  { 0x08, 0x04, 0x08, 0x10, 0x08 }, // '~'
+}
+
+
+
+type anchor int
+
+const (
+  anchor_start anchor = iota
+  anchor_middle
+  anchor_end
+)
+
+
+
+
+
+/*
+  How wide text is, in pixels, at this scale.
+*/
+func text_width ( text string, scale int ) ( int ) {
+  if len ( text ) == 0 {
+    return 0
+  }
+  return ( len ( text ) * glyph_advance - 1 ) * scale
+}
+
+
+
+
+
+/*
+  Draw text with each font pixel 'scale' pixels square. Across,
+  y is the top of the text and the anchor says where x is along
+  it. Up, the text reads from bottom to top, x is its left edge
+  and the anchor says where y is along it.
+*/
+func draw_text ( img * image.RGBA, x, y float64, text string, scale int, a anchor, up bool, col color.RGBA ) {
+  along := float64 ( text_width ( text, scale ) )
+  switch a {
+    case anchor_middle : along /= 2
+    case anchor_start  : along  = 0
+  }
+  if up {
+    y += along
+  } else {
+    x -= along
+  }
+
+  s := float64 ( scale )
+  for i := 0; i < len ( text ); i ++ {
+    c := text [ i ]
+    if c < ' ' || c > '~' {
+      c = '?'
+    }
+    glyph := glyphs [ c - ' ' ]
+    for column := 0; column < glyph_width; column ++ {
+      for row := 0; row < glyph_height; row ++ {
+        if glyph [ column ] & ( 1 << uint(row) ) == 0 {
+          continue
+        }
+        offset := float64 ( i * glyph_advance + column ) * s
+        px, py := x + offset, y + float64(row) * s
+        if up {
+          px, py = x + float64(row) * s, y - offset - s
+        }
+        fill_rect ( img, px, py, px + s - 1, py + s - 1, col )
+      }
+    }
+  }
+}
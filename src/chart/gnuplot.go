@@ -0,0 +1,109 @@
+package chart
+
+import ( "bufio"
+         "errors"
+         "fmt"
+         "os"
+         "os/exec"
+         "strings"
+       )
+
+
+
+
+
+/*
+  Is there a gnuplot on this machine to export charts to?
+*/
+func Gnuplot_available ( ) ( bool ) {
+  _, err := exec.LookPath ( "gnuplot" )
+  return err == nil
+}
+
+
+
+
+
+/*
+  Export the chart the old way: one data file per series and a
+  gnuplot script, all in 'dir', and then run gnuplot to make
+  dir/base_name.jpg. Unlike the SVG and PNG charts this needs
+  gnuplot installed, and says so if it is not.
+*/
+func ( c * Chart ) Write_gnuplot ( dir, base_name string ) ( error ) {
+  var plots [] string
+
+  for i, s := range c.Series {
+    data_file_name := fmt.Sprintf ( "%s_%d.data", base_name, i )
+    if err := write_gnuplot_data ( dir + "/" + data_file_name, s ); err != nil {
+      return err
+    }
+
+    var with string
+    switch s.Style {
+      case Points : with = "points"
+      case Lines  : with = "linespoints lw 3"
+      case Bars   : with = "boxes fill solid"
+    }
+    plots = append ( plots, fmt.Sprintf ( "\"%s\" with %s lt rgb \"%s\" title \"%s\"", data_file_name, with, s.Color, s.Name ) )
+  }
+
+  script := "set autoscale\n"
+  if len ( c.Series ) < 2 {
+    script += "unset key\n"
+  }
+  script += fmt.Sprintf ( "set ylabel \"%s\"\n", c.Y_label )
+  script += fmt.Sprintf ( "set xlabel \"%s\"\n", c.X_label )
+  script += fmt.Sprintf ( "set terminal jpeg size %d,%d\n", c.Width, c.Height )
+  script += fmt.Sprintf ( "set output \"%s.jpg\"\n", base_name )
+  script += fmt.Sprintf ( "set title \"%s\"\n", c.Title )
+  script += "plot " + strings.Join ( plots, ", " ) + "\n"
+
+  script_name := base_name + ".gplot"
+  f, err := os.Create ( dir + "/" + script_name )
+  if err != nil {
+    return err
+  }
+  fp ( f, "%s", script )
+  f.Close ( )
+
+  gnuplot, err := exec.LookPath ( "gnuplot" )
+  if err != nil {
+    return errors.New ( "gnuplot is not installed: wrote " + dir + "/" + script_name + " but could not run it" )
+  }
+
+  command := exec.Command ( gnuplot, script_name )
+  command.Dir = dir
+  output, err := command.CombinedOutput ( )
+  if err != nil {
+    return fmt.Errorf ( "gnuplot %s failed: %s : |%s|", script_name, err.Error(), strings.TrimSpace ( string(output) ) )
+  }
+  return nil
+}
+
+
+
+
+
+func write_gnuplot_data ( file_name string, s * Series ) ( error ) {
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+
+  w := bufio.NewWriter ( f )
+  for _, p := range s.Points {
+    x := p.X
+    if s.Style == Bars {
+      // gnuplot centers boxes on x.
+      x += s.Bar_width / 2
+    }
+    fp ( w, "%.6f %.6f\n", x, p.Y )
+  }
+
+  if err = w.Flush ( ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
@@ -0,0 +1,174 @@
+package chart
+
+import ( "fmt"
+         "image"
+         "image/color"
+         "image/png"
+         "math"
+         "os"
+         "strconv"
+       )
+
+
+
+
+
+/*
+  Write the chart to a PNG file. It is laid out like the
+  SVG, but its text is in a small bitmap font. It is for
+  places that can't show SVG.
+*/
+func ( c * Chart ) Write_png ( file_name string ) ( error ) {
+  img, err := c.Render_png ( )
+  if err != nil {
+    return err
+  }
+
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+
+  if err = png.Encode ( f, img ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
+
+
+
+
+
+func ( c * Chart ) Render_png ( ) ( * image.RGBA, error ) {
+  f, err := c.frame ( )
+  if err != nil {
+    return nil, err
+  }
+
+  img := image.NewRGBA ( image.Rect ( 0, 0, c.Width, c.Height ) )
+  fill_rect ( img, 0, 0, float64(c.Width), float64(c.Height), color.RGBA { 255, 255, 255, 255 } )
+
+  grid  := color.RGBA { 224, 224, 224, 255 }
+  black := color.RGBA {   0,   0,   0, 255 }
+
+  // Grid, ticks, and tick labels.
+  for _, t := range f.a.x_ticks {
+    x := f.x ( t )
+    draw_line ( img, x, f.top, x, f.top + f.height, 1, grid )
+    draw_line ( img, x, f.top + f.height, x, f.top + f.height + 5, 1, black )
+    draw_text ( img, x, f.top + f.height + 9, tick_label ( t ), 1, anchor_middle, false, black )
+  }
+  for _, t := range f.a.y_ticks {
+    y := f.y ( t )
+    draw_line ( img, f.left, y, f.left + f.width, y, 1, grid )
+    draw_line ( img, f.left - 5, y, f.left, y, 1, black )
+    draw_text ( img, f.left - 8, y - glyph_height / 2, tick_label ( t ), 1, anchor_end, false, black )
+  }
+
+  for _, s := range c.Series {
+    col, err := parse_color ( s.Color )
+    if err != nil {
+      return nil, err
+    }
+
+    switch s.Style {
+
+      case Bars :
+        for _, p := range s.Points {
+          x0, x1 := f.x ( p.X ), f.x ( p.X + s.Bar_width )
+          y0, y1 := f.y ( 0 ),   f.y ( p.Y )
+          fill_rect ( img, x0, y1, x1 - 1, y0, col )
+        }
+
+      case Lines :
+        for i := 1; i < len(s.Points); i ++ {
+          a, b := s.Points[i-1], s.Points[i]
+          draw_line ( img, f.x ( a.X ), f.y ( a.Y ), f.x ( b.X ), f.y ( b.Y ), 3, col )
+        }
+        for _, p := range s.Points {
+          fill_rect ( img, f.x ( p.X ) - 3, f.y ( p.Y ) - 3, f.x ( p.X ) + 3, f.y ( p.Y ) + 3, col )
+        }
+
+      case Points :
+        for _, p := range s.Points {
+          fill_rect ( img, f.x ( p.X ) - 1, f.y ( p.Y ) - 1, f.x ( p.X ) + 1, f.y ( p.Y ) + 1, col )
+        }
+    }
+  }
+
+  // The frame goes on top.
+  draw_line ( img, f.left,           f.top,            f.left + f.width, f.top,            1, black )
+  draw_line ( img, f.left,           f.top + f.height, f.left + f.width, f.top + f.height, 1, black )
+  draw_line ( img, f.left,           f.top,            f.left,           f.top + f.height, 1, black )
+  draw_line ( img, f.left + f.width, f.top,            f.left + f.width, f.top + f.height, 1, black )
+
+  // Title and labels.
+  draw_text ( img, f.left + f.width / 2, margin_top / 2 - glyph_height, c.Title, 2, anchor_middle, false, black )
+  draw_text ( img, f.left + f.width / 2, float64 ( c.Height - 15 - glyph_height * 2 ), c.X_label, 2, anchor_middle, false, black )
+  draw_text ( img, 12, f.top + f.height / 2, c.Y_label, 2, anchor_middle, true, black )
+
+  // A key, if there is more than one series to tell apart.
+  if len ( c.Series ) > 1 {
+    for i, s := range c.Series {
+      col, _ := parse_color ( s.Color )
+      y := f.top + 6 + float64(i) * 18
+      x := f.left + f.width - 150
+      fill_rect ( img, x, y, x + 11, y + 11, col )
+      draw_text ( img, x + 18, y + 2, s.Name, 1, anchor_start, false, black )
+    }
+  }
+
+  return img, nil
+}
+
+
+
+
+
+func parse_color ( s string ) ( color.RGBA, error ) {
+  if len ( s ) != 7 || s[0] != '#' {
+    return color.RGBA{}, fmt.Errorf ( "bad color |%s| : expected #rrggbb", s )
+  }
+  n, err := strconv.ParseUint ( s[1:], 16, 32 )
+  if err != nil {
+    return color.RGBA{}, fmt.Errorf ( "bad color |%s| : %s", s, err.Error() )
+  }
+  return color.RGBA { uint8(n >> 16), uint8(n >> 8), uint8(n), 255 }, nil
+}
+
+
+
+
+
+func fill_rect ( img * image.RGBA, x0, y0, x1, y1 float64, col color.RGBA ) {
+  r := image.Rect ( int(math.Round(x0)), int(math.Round(y0)), int(math.Round(x1)) + 1, int(math.Round(y1)) + 1 ).Intersect ( img.Bounds() )
+  for y := r.Min.Y; y < r.Max.Y; y ++ {
+    for x := r.Min.X; x < r.Max.X; x ++ {
+      img.SetRGBA ( x, y, col )
+    }
+  }
+}
+
+
+
+
+
+/*
+  Draw a line 'thickness' pixels wide by stepping along
+  it one pixel at a time and stamping a square.
+*/
+func draw_line ( img * image.RGBA, x0, y0, x1, y1 float64, thickness int, col color.RGBA ) {
+  steps := int ( math.Max ( math.Abs ( x1 - x0 ), math.Abs ( y1 - y0 ) ) )
+  if steps == 0 {
+    steps = 1
+  }
+  half := float64 ( thickness - 1 ) / 2
+
+  for i := 0; i <= steps; i ++ {
+    t := float64(i) / float64(steps)
+    x := x0 + t * ( x1 - x0 )
+    y := y0 + t * ( y1 - y0 )
+    fill_rect ( img, x - half, y - half, x + half, y + half, col )
+  }
+}
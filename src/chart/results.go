@@ -0,0 +1,146 @@
+package chart
+
+import ( "math"
+         "time"
+
+         "results"
+       )
+
+
+
+
+
+/*
+  Every message's latency against its arrival time.
+*/
+func Timeline ( r * results.Results, title string ) ( * Chart ) {
+  c := New_chart ( title, "time (sec)", "latency (msec)" )
+  s := c.Add_series ( "latency", Points )
+  for _, m := range r.Messages {
+    s.Add ( m.Arrival_time, m.Latency )
+  }
+  return c
+}
+
+
+
+
+
+/*
+  How many messages had each latency, in n_bins bins
+  from the least latency to the greatest.
+*/
+func Histogram ( r * results.Results, n_bins int, title string ) ( * Chart ) {
+  c := New_chart ( title, "latency (msec)", "messages" )
+  s := c.Add_series ( "messages", Bars )
+
+  latencies := r.Sorted_latencies ( )
+  if len ( latencies ) == 0 || n_bins < 1 {
+    return c
+  }
+
+  min, max := latencies[0], latencies[len(latencies)-1]
+  width    := ( max - min ) / float64(n_bins)
+  if width == 0 {
+    width = 1
+  }
+
+  counts := make ( [] int, n_bins )
+  for _, latency := range latencies {
+    bin := int ( math.Floor ( ( latency - min ) / width ) )
+    if bin >= n_bins {
+      // The greatest latency goes in the last bin.
+      bin = n_bins - 1
+    }
+    counts [ bin ] ++
+  }
+
+  s.Bar_width = width
+  for i, count := range counts {
+    s.Add ( min + float64(i) * width, float64(count) )
+  }
+  return c
+}
+
+
+
+
+
+/*
+  The mean and some percentiles of latency, one point per run,
+  against whatever was changed from run to run.
+  xs[i] goes with stats[i].
+*/
+func Latency_by ( xs [] float64, stats [] results.Stats, x_label, title string ) ( * Chart ) {
+  c    := New_chart ( title, x_label, "latency (msec)" )
+  mean := c.Add_series ( "mean", Lines )
+
+  var percentiles [] * Series
+  if len ( stats ) > 0 {
+    for _, p := range stats[0].Percentile_points {
+      percentiles = append ( percentiles, c.Add_series ( results.Percentile_name ( p ), Lines ) )
+    }
+  }
+
+  for i, s := range stats {
+    if s.Count == 0 {
+      continue
+    }
+    mean.Add ( xs[i], s.Mean )
+    for j, value := range s.Percentiles {
+      if j < len(percentiles) {
+        percentiles[j].Add ( xs[i], value )
+      }
+    }
+  }
+  return c
+}
+
+
+
+
+
+/*
+  Achieved throughput over a sliding window, against time.
+  If offered is more than zero, it is drawn as a flat line
+  for comparison.
+*/
+func Throughput ( r * results.Results, window time.Duration, offered float64, title string ) ( * Chart ) {
+  c        := New_chart ( title, "time (sec)", "throughput (msg/sec)" )
+  achieved := c.Add_series ( "achieved", Lines )
+
+  samples := r.Throughput ( window, window / 10 )
+  for _, s := range samples {
+    achieved.Add ( s.Time, s.Rate )
+  }
+
+  if offered > 0 && len ( samples ) > 0 {
+    o := c.Add_series ( "offered", Lines )
+    o.Add ( samples[0].Time, offered )
+    o.Add ( samples[len(samples)-1].Time, offered )
+  }
+  return c
+}
+
+
+
+
+
+/*
+  Achieved throughput, one point per run, against whatever
+  was changed from run to run, with the offered load where
+  it is known. xs[i] goes with achieved[i] and offered[i];
+  an offered load of zero or less is not known.
+*/
+func Throughput_by ( xs, achieved, offered [] float64, x_label, title string ) ( * Chart ) {
+  c := New_chart ( title, x_label, "throughput (msg/sec)" )
+  a := c.Add_series ( "achieved", Lines )
+  o := c.Add_series ( "offered",  Lines )
+  for i, x := range xs {
+    a.Add ( x, achieved[i] )
+    if offered[i] > 0 {
+      o.Add ( x, offered[i] )
+    }
+  }
+  return c
+}
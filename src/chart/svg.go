@@ -0,0 +1,111 @@
+package chart
+
+import ( "bufio"
+         "html"
+         "io"
+         "os"
+       )
+
+
+
+
+
+/*
+  Write the chart to an SVG file.
+*/
+func ( c * Chart ) Write_svg ( file_name string ) ( error ) {
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+
+  if err = c.Render_svg ( f ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
+
+
+
+
+
+/*
+  Draw the chart as SVG onto w.
+*/
+func ( c * Chart ) Render_svg ( writer io.Writer ) ( error ) {
+  f, err := c.frame ( )
+  if err != nil {
+    return err
+  }
+
+  w := bufio.NewWriter ( writer )
+
+  fp ( w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\" font-family=\"sans-serif\" font-size=\"12\">\n",
+       c.Width, c.Height, c.Width, c.Height )
+  fp ( w, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n" )
+
+  // Grid and tick labels.
+  for _, t := range f.a.x_ticks {
+    x := f.x ( t )
+    fp ( w, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#e0e0e0\"/>\n", x, f.top, x, f.top + f.height )
+    fp ( w, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"middle\">%s</text>\n", x, f.top + f.height + 16, tick_label ( t ) )
+  }
+  for _, t := range f.a.y_ticks {
+    y := f.y ( t )
+    fp ( w, "<line x1=\"%.1f\" y1=\"%.1f\" x2=\"%.1f\" y2=\"%.1f\" stroke=\"#e0e0e0\"/>\n", f.left, y, f.left + f.width, y )
+    fp ( w, "<text x=\"%.1f\" y=\"%.1f\" text-anchor=\"end\">%s</text>\n", f.left - 6, y + 4, tick_label ( t ) )
+  }
+
+  // The series.
+  for _, s := range c.Series {
+    switch s.Style {
+
+      case Bars :
+        for _, p := range s.Points {
+          x0, x1 := f.x ( p.X ), f.x ( p.X + s.Bar_width )
+          y0, y1 := f.y ( 0 ),   f.y ( p.Y )
+          fp ( w, "<rect x=\"%.2f\" y=\"%.2f\" width=\"%.2f\" height=\"%.2f\" fill=\"%s\" stroke=\"white\" stroke-width=\"0.5\"/>\n",
+               x0, y1, x1 - x0, y0 - y1, s.Color )
+        }
+
+      case Lines :
+        fp ( w, "<polyline fill=\"none\" stroke=\"%s\" stroke-width=\"3\" points=\"", s.Color )
+        for _, p := range s.Points {
+          fp ( w, "%.2f,%.2f ", f.x ( p.X ), f.y ( p.Y ) )
+        }
+        fp ( w, "\"/>\n" )
+        fallthrough
+
+      case Points :
+        fp ( w, "<g fill=\"%s\">\n", s.Color )
+        radius := 1.5
+        if s.Style == Lines {
+          radius = 4
+        }
+        for _, p := range s.Points {
+          fp ( w, "<circle cx=\"%.2f\" cy=\"%.2f\" r=\"%.1f\"/>\n", f.x ( p.X ), f.y ( p.Y ), radius )
+        }
+        fp ( w, "</g>\n" )
+    }
+  }
+
+  // Frame, title, and labels.
+  fp ( w, "<rect x=\"%.1f\" y=\"%.1f\" width=\"%.1f\" height=\"%.1f\" fill=\"none\" stroke=\"black\"/>\n", f.left, f.top, f.width, f.height )
+  fp ( w, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\" font-size=\"16\">%s</text>\n", f.left + f.width / 2, margin_top / 2 + 6, html.EscapeString ( c.Title ) )
+  fp ( w, "<text x=\"%.1f\" y=\"%d\" text-anchor=\"middle\">%s</text>\n", f.left + f.width / 2, c.Height - 15, html.EscapeString ( c.X_label ) )
+  fp ( w, "<text transform=\"translate(20,%.1f) rotate(-90)\" text-anchor=\"middle\">%s</text>\n", f.top + f.height / 2, html.EscapeString ( c.Y_label ) )
+
+  // A key, if there is more than one series to tell apart.
+  if len ( c.Series ) > 1 {
+    for i, s := range c.Series {
+      y := f.top + 16 + float64(i) * 18
+      x := f.left + f.width - 150
+      fp ( w, "<rect x=\"%.1f\" y=\"%.1f\" width=\"12\" height=\"12\" fill=\"%s\"/>\n", x, y - 10, s.Color )
+      fp ( w, "<text x=\"%.1f\" y=\"%.1f\">%s</text>\n", x + 18, y, html.EscapeString ( s.Name ) )
+    }
+  }
+
+  fp ( w, "</svg>\n" )
+  return w.Flush ( )
+}
@@ -7,6 +7,7 @@ import ( "fmt"
          "strings"
          "time"
          "strconv"
+         "syscall"
 
          "utils"
        )
@@ -40,19 +41,27 @@ type Client struct {
   config_path          string
   host                 string
   results_path         string
-  events_path          string
+  control_socket       string
   Operation            string
   Port                 string
 
   Path                 string
-  ld_library_path      string
-  pythonpath           string
+  // What the client process is started with, on top
+  // of mercury's own environment.
+  env                  utils.Environment
   log_file             string
 
   N_messages           int
 
   cmd                * exec.Cmd
+  supervisor         * utils.Supervisor
   State                Client_state
+
+  // Called when the client process exits, expectedly or not.
+  On_exit              func ( utils.Process_exit )
+  // Called with the process id each time the client process starts.
+  On_start             func ( pid int )
+
   message_length       int
   addrs             [] string
 
@@ -66,6 +75,15 @@ type Client struct {
 
   status_file_name     string
 
+  // If set, the client connects with TLS, and trusts
+  // the certificates that this CA has issued.
+  tls_ca_file          string
+
+  // If set, the client logs in with this SASL mechanism.
+  sasl_mechanism       string
+  sasl_user            string
+  sasl_password        string
+
 
   // This gets set by the network, when it is 
   // checking on this client's status file.
@@ -86,7 +104,7 @@ func New_client ( name                  string,
                   config_path           string,
                   host                  string,
                   results_path          string,
-                  events_path           string,
+                  control_socket        string,
                   operation             string,
                   port                  string,
                   path                  string,
@@ -108,12 +126,10 @@ func New_client ( name                  string,
                  config_path           : full_config_path,
                  host                  : host,
                  results_path          : results_path,
-                 events_path           : events_path,
+                 control_socket        : control_socket,
                  Operation             : operation,
                  Port                  : port,
                  Path                  : path,
-                 ld_library_path       : ld_library_path,
-                 pythonpath            : pythonpath,
                  log_file              : log_file,
                  State                 : initialized,
                  N_messages            : n_messages,
@@ -121,7 +137,9 @@ func New_client ( name                  string,
                  throttle              : throttle,
                  verbose               : verbose,
                  delay                 : delay,
-                 soak                  : soak }
+                 soak                  : soak,
+                 env                   : utils.Environment { "LD_LIBRARY_PATH" : ld_library_path,
+                                                             "PYTHONPATH"      : pythonpath } }
 
   if ! utils.Path_exists ( path ) {
     ume ( "client: executable path |%s| isn't there.", c.Path )
@@ -145,6 +163,49 @@ func ( c * Client ) Add_Address ( addr string ) {
 
 
 
+/*
+  Start the client process with this environment variable set,
+  in addition to LD_LIBRARY_PATH and PYTHONPATH. Setting one of
+  those replaces the client's value. Only takes effect the next
+  time the client is run.
+*/
+func ( c * Client ) Setenv ( name, value string ) {
+  c.env [ name ] = value
+}
+
+
+
+
+
+/*
+  Connect with TLS, checking that the router's certificate is
+  from the CA in ca_file and names the host the client connects
+  to. An empty ca_file turns TLS off again. Only takes effect
+  the next time the client is run.
+*/
+func ( c * Client ) Use_tls ( ca_file string ) {
+  c.tls_ca_file = ca_file
+}
+
+
+
+
+
+/*
+  Log in to the router with the SASL mechanism, e.g. "SCRAM-SHA-1",
+  rather than ANONYMOUS. An empty mechanism goes back to ANONYMOUS.
+  Only takes effect the next time the client is run.
+*/
+func ( c * Client ) Use_sasl ( mechanism, user, password string ) {
+  c.sasl_mechanism = mechanism
+  c.sasl_user      = user
+  c.sasl_password  = password
+}
+
+
+
+
+
 func ( c * Client ) Run ( ) {
 
   // Don't warn in this case. It's normal behavior
@@ -154,10 +215,6 @@ func ( c * Client ) Run ( ) {
     return
   }
 
-  // Set up the environment for the router process.
-  os.Setenv ( "LD_LIBRARY_PATH", c.ld_library_path )
-  os.Setenv ( "PYTHONPATH"     , c.pythonpath )
-
   // Name should always be first, because it may be used 
   // in the course of other argv processing.
   if c.results_path == "" {
@@ -168,7 +225,7 @@ func ( c * Client ) Run ( ) {
 
   args := " --name " + c.Name + 
           " --flight_times_file_name " + c.results_path + 
-          " --events_path " + c.events_path +
+          " --control_socket " + c.control_socket +
           " --operation " + c.Operation + 
           " --host " + c.host + 
           " --port " + c.Port + 
@@ -182,11 +239,25 @@ func ( c * Client ) Run ( ) {
     args += " --soak"
   }
 
+  if c.tls_ca_file != "" {
+    args += " --ssl_ca " + c.tls_ca_file + " --ssl_verify peer_name"
+  }
+
+  // The password is made for the test alone, so it does
+  // no harm in the command line.
+  if c.sasl_mechanism != "" {
+    args += " --sasl_mechanism " + c.sasl_mechanism +
+            " --sasl_user "      + c.sasl_user +
+            " --sasl_password "  + c.sasl_password
+  }
+
   for _, addr := range c.addrs {
     args += " --address " + addr
   }
   args_list := strings.Fields ( args )
   c.cmd = exec.Command ( c.Path,  args_list... )
+  c.cmd.Env = c.env.List ( )
+  c.cmd.SysProcAttr = & syscall.SysProcAttr { Setpgid : true }
 
   // Write the command line. -------------------------------
   command_file_name := c.config_path + "/" + "command_line"
@@ -198,24 +269,30 @@ func ( c * Client ) Run ( ) {
 
   // Write the environment variables. ----------------------
   environment_file_name := c.config_path + "/" + "environment_variables"
-  environment_file, err := os.Create ( environment_file_name )
-  utils.Check ( err )
-  defer environment_file.Close ( )
-  environment_string := "export LD_LIBRARY_PATH=" + c.ld_library_path + "\n" +
-                        "export PYTHONPATH="      + c.pythonpath + "\n"
-  environment_file.WriteString ( environment_string )
+  utils.Check ( c.env.Write_file ( environment_file_name ) )
 
 
+  // Assertion failures and option errors go to these files.
+  if err = utils.Capture_output ( c.cmd, c.log_file, "client " + c.Name, c.verbose ); err != nil {
+    ume ( "client |%s| can't capture output: |%s|", c.Name, err.Error() )
+    return
+  }
+
   // Start the client command. After the call to Start(),
   // the client is running detached.
   //fp ( os.Stderr, "running client |%s|\n", c.Name )
   err = c.cmd.Start()
   if err != nil {
+    utils.Close_output ( c.cmd )
     ume ( "client |%s| start-up error: |%s|", c.Name, err.Error() )
     return
   }
 
-  c.State = running
+  c.State      = running
+  c.supervisor = utils.Supervise ( c.cmd, "client", c.Name, c.log_file, c.log_file + ".stderr", c.On_exit )
+  if c.On_start != nil {
+    c.On_start ( c.cmd.Process.Pid )
+  }
   umi ( c.verbose, "client |%s| is running with pid %d.", c.Name, c.cmd.Process.Pid )
 }
 
@@ -231,55 +308,44 @@ func ( c * Client ) Is_running ( ) ( bool ) {
 
 
 
+/*
+  The client's process id, or 0 if it is not running.
+*/
+func ( c * Client ) Pid ( ) ( int ) {
+  if c.State != running || c.cmd == nil || c.cmd.Process == nil {
+    return 0
+  }
+  return c.cmd.Process.Pid
+}
+
+
+
+
+
+/*
+  Halt the client. A client that has already exited on its own
+  is fine if it succeeded, since clients finish by themselves;
+  otherwise how it ended is returned as an error.
+*/
 func ( c * Client ) Halt ( ) error {
 
   // Let's not treat this as an error. Just as the user 
   // can freely "run" the network even if parts of it are
   // already running -- let's allow them to halt it even 
   // if parts are already halted.
-  if c.State == halted {
+  if c.State == halted || c.supervisor == nil {
+    c.State = halted
     return nil
   }
+  c.State = halted
 
-  // Set up a channel that will return a
-  // message immediately if the process has
-  // already terminated. Then set up a half-second
-  // timer. If the timer expires before the Wait
-  // returns a 'done' message, we judge that the
-  // process was still running when we came along
-  // and killed it. Which is good.
-  done := make ( chan error, 1 )
-  go func ( ) {
-      done <- c.cmd.Wait ( )
-  } ( )
-
-  select {
-    /*
-      This is the expected case.
-      Our timer times out while the above Wait() is still waiting.
-      This means that the process is still running normally when we kill it.
-    */
-    case <-time.After ( 250 * time.Millisecond ) :
-      c.State = halted
-      if err := c.cmd.Process.Kill(); err != nil {
-        return errors.New ( "failed to kill process: " + err.Error() )
-      }
-      return nil
-
-    case err := <-done:
-      c.State = halted
-      if err != nil {
-        return errors.New ( "process terminated early with error: " + err.Error() )
-      }
-
-      // Even though there was no error reported -- the process
-      // mevertheless stopped early, which is an error in the
-      // context of this test.
-      return errors.New ( "process self-terminated." )
+  exit, err := c.supervisor.Stop ( os.Kill )
+  if err != nil {
+    return err
+  }
+  if exit != nil && ( exit.Code != 0 || exit.Signal != "" ) {
+    return errors.New ( "process terminated early: " + exit.String() )
   }
-
-  // I think this is unreachable.
-  c.State = halted
   return nil
 }
 
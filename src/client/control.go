@@ -0,0 +1,504 @@
+package client
+
+import ( "bufio"
+         "errors"
+         "fmt"
+         "net"
+         "os"
+         "sort"
+         "strconv"
+         "strings"
+         "sync"
+         "time"
+
+         "utils"
+       )
+
+
+
+
+
+/*===================================================================
+
+  The control channel between Mercury and its clients.
+
+  Mercury listens on a Unix-domain socket, and each client
+  connects to it when it starts. Everything is a line of text.
+
+  Client to Mercury:
+    hello <name> <pid> <operation>
+    connected <msec>
+             -- once, when the client's connection has opened,
+                with the time since it began connecting
+    progress <sent> <received> <accepted> <rejected> <released> <modified>
+             -- every 1000 messages, and once a second
+                while the counts are changing
+    done_sending <sent> <accepted> <rejected> <released> <modified>
+    done_receiving <received>
+    dumped <n_flight_times>
+
+  Mercury to client:
+    start    -- begin sending
+    dump     -- write out flight times, then exit
+    stop     -- stop now, without writing anything
+
+  A client that connects after a command has been broadcast
+  is sent that command as soon as it says hello, so clients
+  that start late do not miss anything.
+
+===================================================================*/
+
+/*
+  What Mercury knows about one client, from its reports.
+*/
+type Client_status struct {
+  Name                 string
+  Pid                  int
+  Operation            string
+  Connected            bool
+  // How long the client's connection took to open. Zero
+  // if it has not said.
+  Connect_msec         float64
+
+  Sent                 int
+  Received             int
+  Accepted             int
+  Rejected             int
+  Released             int
+  Modified             int
+
+  Done                 bool
+  Dumped               bool
+  Flight_times         int
+
+  Last_report          time.Time
+}
+
+
+
+
+
+type Control_server struct {
+  socket_path          string
+  listener             net.Listener
+  log_file           * os.File
+  verbose              bool
+
+  lock                 sync.Mutex
+  conns                map [ string ] net.Conn
+  statuses             map [ string ] * Client_status
+  broadcast         [] string
+  changed              chan struct{}
+  closed               bool
+  // Why waiting was given up, if it was.
+  aborted              string
+}
+
+
+
+
+
+/*
+  Start listening for clients on a Unix-domain socket at socket_path.
+  Every line that goes either way is also written to log_file_name,
+  if that is not empty.
+*/
+func New_control_server ( socket_path, log_file_name string, verbose bool ) ( * Control_server, error ) {
+  // A socket left over from an earlier run would stop us from listening.
+  os.Remove ( socket_path )
+
+  listener, err := net.Listen ( "unix", socket_path )
+  if err != nil {
+    return nil, err
+  }
+
+  cs := & Control_server { socket_path : socket_path,
+                           listener    : listener,
+                           verbose     : verbose,
+                           conns       : make ( map [ string ] net.Conn ),
+                           statuses    : make ( map [ string ] * Client_status ),
+                           changed     : make ( chan struct{}, 1 ) }
+
+  if log_file_name != "" {
+    cs.log_file, err = os.Create ( log_file_name )
+    if err != nil {
+      listener.Close ( )
+      return nil, err
+    }
+  }
+
+  go cs.accept ( )
+  umi ( verbose, "control server listening on |%s|", socket_path )
+  return cs, nil
+}
+
+
+
+
+
+func ( cs * Control_server ) Socket_path ( ) ( string ) {
+  return cs.socket_path
+}
+
+
+
+
+
+// Call with the lock held.
+func ( cs * Control_server ) log ( format string, args ...interface{} ) {
+  if cs.log_file != nil {
+    fp ( cs.log_file, "%.6f %s\n", utils.Timestamp(), fmt.Sprintf ( format, args ... ) )
+  }
+}
+
+
+
+
+
+// Wake up anyone who is waiting for a status to change.
+func ( cs * Control_server ) notify ( ) {
+  select {
+    case cs.changed <- struct{}{} :
+    default :
+  }
+}
+
+
+
+
+
+func ( cs * Control_server ) accept ( ) {
+  for {
+    conn, err := cs.listener.Accept ( )
+    if err != nil {
+      // The listener has been closed.
+      return
+    }
+    go cs.serve ( conn )
+  }
+}
+
+
+
+
+
+/*
+  Read reports from one client until it goes away.
+*/
+func ( cs * Control_server ) serve ( conn net.Conn ) {
+  var status * Client_status
+  scanner := bufio.NewScanner ( conn )
+
+  for scanner.Scan ( ) {
+    fields := strings.Fields ( scanner.Text() )
+    if len ( fields ) == 0 {
+      continue
+    }
+
+    cs.lock.Lock ( )
+
+    if status == nil {
+      if fields[0] != "hello" || len ( fields ) < 4 {
+        cs.log ( "unexpected first line |%s|", scanner.Text() )
+        cs.lock.Unlock ( )
+        conn.Close ( )
+        return
+      }
+      status = cs.hello ( conn, fields )
+    } else {
+      cs.log ( "%s : %s", status.Name, scanner.Text() )
+      cs.report ( status, fields )
+    }
+
+    cs.lock.Unlock ( )
+    cs.notify ( )
+  }
+
+  cs.lock.Lock ( )
+  if status != nil {
+    status.Connected = false
+    delete ( cs.conns, status.Name )
+    cs.log ( "%s : disconnected", status.Name )
+  }
+  cs.lock.Unlock ( )
+  conn.Close ( )
+  cs.notify ( )
+}
+
+
+
+
+
+// Call with the lock held.
+func ( cs * Control_server ) hello ( conn net.Conn, fields [] string ) ( * Client_status ) {
+  name   := fields[1]
+  pid, _ := strconv.Atoi ( fields[2] )
+
+  // A client that was killed and restarted says hello again.
+  // Keep its counts, but forget that it was done.
+  status, present := cs.statuses [ name ]
+  if ! present {
+    status = & Client_status { Name : name }
+    cs.statuses [ name ] = status
+  }
+  status.Pid         = pid
+  status.Operation   = fields[3]
+  status.Connected   = true
+  status.Done        = false
+  status.Dumped      = false
+  status.Last_report = time.Now ( )
+
+  cs.conns [ name ] = conn
+  cs.log ( "%s : hello pid %d %s", name, pid, status.Operation )
+
+  // Catch the client up on anything it missed.
+  for _, command := range cs.broadcast {
+    fp ( conn, "%s\n", command )
+    cs.log ( "%s <- %s", name, command )
+  }
+
+  return status
+}
+
+
+
+
+
+// Call with the lock held.
+func ( cs * Control_server ) report ( status * Client_status, fields [] string ) {
+  numbers := make ( [] int, len(fields) - 1 )
+  for i, field := range fields[1:] {
+    numbers [ i ], _ = strconv.Atoi ( field )
+  }
+  number := func ( i int ) ( int ) {
+    if i < len(numbers) {
+      return numbers [ i ]
+    }
+    return 0
+  }
+
+  status.Last_report = time.Now ( )
+
+  switch fields[0] {
+
+    case "connected" :
+      if len(fields) > 1 {
+        status.Connect_msec, _ = strconv.ParseFloat ( fields[1], 64 )
+      }
+
+    case "progress" :
+      status.Sent     = number ( 0 )
+      status.Received = number ( 1 )
+      status.Accepted = number ( 2 )
+      status.Rejected = number ( 3 )
+      status.Released = number ( 4 )
+      status.Modified = number ( 5 )
+
+    case "done_sending" :
+      status.Sent     = number ( 0 )
+      status.Accepted = number ( 1 )
+      status.Rejected = number ( 2 )
+      status.Released = number ( 3 )
+      status.Modified = number ( 4 )
+      status.Done     = true
+
+    case "done_receiving" :
+      status.Received = number ( 0 )
+      status.Done     = true
+
+    case "dumped" :
+      status.Flight_times = number ( 0 )
+      status.Dumped       = true
+
+    default :
+      cs.log ( "%s : unknown report |%s|", status.Name, fields[0] )
+  }
+}
+
+
+
+
+
+/*
+  Send a command to every client, now and as they connect.
+*/
+func ( cs * Control_server ) Broadcast ( command string ) {
+  cs.lock.Lock ( )
+  defer cs.lock.Unlock ( )
+
+  cs.broadcast = append ( cs.broadcast, command )
+
+  for name, conn := range cs.conns {
+    if _, err := fp ( conn, "%s\n", command ); err != nil {
+      cs.log ( "%s <- %s : error %s", name, command, err.Error() )
+      continue
+    }
+    cs.log ( "%s <- %s", name, command )
+  }
+
+  umi ( cs.verbose, "control server: broadcast |%s| to %d clients.", command, len(cs.conns) )
+}
+
+
+
+
+
+/*
+  Get a copy of the status of every client that has said hello,
+  sorted by name.
+*/
+func ( cs * Control_server ) Statuses ( ) ( [] Client_status ) {
+  cs.lock.Lock ( )
+  defer cs.lock.Unlock ( )
+
+  var statuses [] Client_status
+  for _, status := range cs.statuses {
+    statuses = append ( statuses, * status )
+  }
+  sort.Slice ( statuses, func ( i, j int ) bool { return statuses[i].Name < statuses[j].Name } )
+  return statuses
+}
+
+
+
+
+
+func ( cs * Control_server ) Status ( name string ) ( Client_status, bool ) {
+  cs.lock.Lock ( )
+  defer cs.lock.Unlock ( )
+
+  status, present := cs.statuses [ name ]
+  if ! present {
+    return Client_status { Name : name }, false
+  }
+  return * status, true
+}
+
+
+
+
+
+/*
+  Wait until every named client satisfies 'finished'.
+
+  This fails at once if one of those clients disconnects before
+  it is finished, since that means it died. It also fails if
+  none of them has reported anything for 'stall' (the network
+  has stopped moving), or if 'timeout' passes. Zero for either
+  means no limit.
+
+  If 'progress' is not nil, it is called with the number of
+  finished clients every time that number changes.
+*/
+func ( cs * Control_server ) Wait_for ( names    [] string,
+                                        finished func ( * Client_status ) bool,
+                                        timeout  time.Duration,
+                                        stall    time.Duration,
+                                        progress func ( n_finished, n_total int ) ) ( error ) {
+  start         := time.Now ( )
+  last_finished := -1
+
+  for {
+    cs.lock.Lock ( )
+
+    if cs.aborted != "" {
+      cs.lock.Unlock ( )
+      return errors.New ( "aborted: " + cs.aborted )
+    }
+
+    n_finished  := 0
+    last_report := start
+    var dead [] string
+    for _, name := range names {
+      status, present := cs.statuses [ name ]
+      if ! present {
+        continue
+      }
+      if finished ( status ) {
+        n_finished ++
+        continue
+      }
+      if ! status.Connected {
+        dead = append ( dead, name )
+      }
+      if status.Last_report.After ( last_report ) {
+        last_report = status.Last_report
+      }
+    }
+
+    cs.lock.Unlock ( )
+
+    if n_finished != last_finished {
+      last_finished = n_finished
+      if progress != nil {
+        progress ( n_finished, len(names) )
+      }
+    }
+
+    if n_finished >= len(names) {
+      return nil
+    }
+
+    if len ( dead ) > 0 {
+      return fmt.Errorf ( "clients went away before finishing: %v", dead )
+    }
+
+    if stall > 0 && time.Since ( last_report ) > stall {
+      return fmt.Errorf ( "no client has reported for %v : %d of %d finished", stall, n_finished, len(names) )
+    }
+
+    if timeout > 0 && time.Since ( start ) > timeout {
+      return fmt.Errorf ( "timed out after %v : %d of %d finished", timeout, n_finished, len(names) )
+    }
+
+    select {
+      case <-cs.changed :
+      case <-time.After ( time.Second ) :
+    }
+  }
+}
+
+
+
+
+
+/*
+  Make every Wait_for, now or to come, give up and return
+  an error that gives this reason.
+*/
+func ( cs * Control_server ) Abort ( reason string ) {
+  cs.lock.Lock ( )
+  if cs.aborted == "" {
+    cs.aborted = reason
+  }
+  cs.lock.Unlock ( )
+  cs.notify ( )
+}
+
+
+
+
+
+/*
+  Stop listening, hang up on all clients, and remove the socket.
+*/
+func ( cs * Control_server ) Close ( ) ( error ) {
+  cs.lock.Lock ( )
+  defer cs.lock.Unlock ( )
+
+  if cs.closed {
+    return errors.New ( "control server already closed" )
+  }
+  cs.closed = true
+
+  err := cs.listener.Close ( )
+  for _, conn := range cs.conns {
+    conn.Close ( )
+  }
+  if cs.log_file != nil {
+    cs.log_file.Close ( )
+  }
+  os.Remove ( cs.socket_path )
+
+  return err
+}
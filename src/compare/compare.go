@@ -0,0 +1,429 @@
+package compare
+
+import ( "fmt"
+         "io"
+         "math"
+         "path/filepath"
+         "sort"
+         "strings"
+         "time"
+
+         "report"
+         "results"
+         "summary"
+       )
+
+
+var fp = fmt.Fprintf
+
+
+
+
+
+/*===================================================================
+
+  Compare the runs of a candidate test against those of a
+  baseline test -- usually the same scenario or sweep, run
+  against two Dispatch builds -- and decide whether the
+  candidate has regressed.
+
+  Runs are matched by their parameters, from their summary.json,
+  leaving out the router version since that is usually what
+  differs. Runs that share their parameters with others on the
+  same side, such as repeats of one scenario, are matched by
+  their parameters and their name. Runs without a summary are
+  matched by name. If each side is a single run, those two are
+  compared whatever they are.
+
+  Latency is compared over every trimmed message of the two runs:
+  the mean with the Mann-Whitney test, and each percentile with
+  a bootstrap confidence interval for the difference. A change
+  is a regression only if it is both significant and worse than
+  the threshold. Connection setup is compared the same way as
+  mean latency, over the clients' setup times, when both runs
+  have them. Throughput is one number per run, so it has no
+  significance test, only the threshold.
+
+  A run that failed, or has no messages, can't be compared, and
+  neither can a run with nothing to match on the other side.
+  These are failures too: a candidate that crashes must not pass.
+
+===================================================================*/
+
+type Options struct {
+  Threshold          float64          // percent
+  Alpha              float64          // significance level
+  Percentiles     [] float64
+  N_resamples        int              // for the bootstrap
+  Max_sample         int              // messages per run in the bootstrap
+  Warm_up            time.Duration
+  Cool_down          time.Duration
+}
+
+
+
+type Metric struct {
+  Name               string
+  Baseline           float64
+  Candidate          float64
+  Change             float64          // percent, candidate against baseline
+  Low, High          float64          // confidence interval of the difference, or NaN
+  P_value            float64          // NaN if there was no test
+  Significant        bool
+  Regression         bool
+}
+
+
+
+type Run_comparison struct {
+  Key                string
+  Baseline           string
+  Candidate          string
+  Problem            string           // why there are no metrics, if there are not
+  Metrics         [] Metric
+}
+
+
+
+type Comparison struct {
+  Baseline_dir       string
+  Candidate_dir      string
+  Runs            [] Run_comparison
+  Unmatched       [] string           // runs on only one side
+}
+
+
+
+
+
+type run_data struct {
+  path               string
+  key                string
+  throughput         float64
+  latencies       [] float64           // sorted
+  connect_msec    [] float64           // sorted, one per client
+  problem            string
+}
+
+
+
+
+
+/*
+  The parameters that say which runs are comparable.
+*/
+func run_key ( path string, s * summary.Summary ) ( string ) {
+  if s == nil || len ( s.Parameters ) == 0 {
+    return filepath.Base ( path )
+  }
+  var names [] string
+  for name := range s.Parameters {
+    if name != "version" {
+      names = append ( names, name )
+    }
+  }
+  sort.Strings ( names )
+  var pairs [] string
+  for _, name := range names {
+    pairs = append ( pairs, name + "=" + s.Parameters[name] )
+  }
+  return strings.Join ( pairs, " " )
+}
+
+
+
+
+
+func load_runs ( dir string, o * Options ) ( [] * run_data, error ) {
+  paths := report.Find_runs ( dir )
+  if len ( paths ) == 0 {
+    return nil, fmt.Errorf ( "no runs in |%s|", dir )
+  }
+
+  var runs [] * run_data
+  for _, path := range paths {
+    r := & run_data { path : path }
+    s, err := summary.Read_json ( path + "/summary.json" )
+    if err != nil {
+      s = nil
+    }
+    r.key = run_key ( path, s )
+
+    loaded, err := results.Load ( path + "/result" )
+    if err != nil {
+      r.problem = err.Error()
+    } else {
+      r.latencies = loaded.Trim ( o.Warm_up, o.Cool_down ).Sorted_latencies ( )
+      r.connect_msec, _ = results.Load_connection_setup ( path + "/result" )
+      if len ( r.latencies ) == 0 {
+        r.problem = "no messages"
+      }
+      if s != nil && ! s.Passed {
+        r.problem = "failed: " + s.Error
+      }
+      if s != nil {
+        r.throughput = s.Throughput
+      } else if duration := loaded.Duration(); duration > 0 {
+        r.throughput = float64 ( len ( loaded.Messages ) ) / duration
+      }
+    }
+    runs = append ( runs, r )
+  }
+
+  count := make ( map [ string ] int )
+  for _, r := range runs {
+    count [ r.key ] ++
+  }
+  for _, r := range runs {
+    if count [ r.key ] > 1 {
+      r.key += " run=" + filepath.Base ( r.path )
+    }
+  }
+  return runs, nil
+}
+
+
+
+
+
+func percent_change ( baseline, candidate float64 ) ( float64 ) {
+  if baseline == 0 {
+    return math.NaN()
+  }
+  return ( candidate - baseline ) / baseline * 100
+}
+
+
+
+
+
+func ( o * Options ) compare_runs ( baseline, candidate * run_data ) ( Run_comparison ) {
+  rc := Run_comparison { Key       : candidate.key,
+                         Baseline  : baseline.path,
+                         Candidate : candidate.path }
+
+  switch {
+    case baseline.problem != "" :
+      rc.Problem = "baseline: " + baseline.problem
+      return rc
+    case candidate.problem != "" :
+      rc.Problem = "candidate: " + candidate.problem
+      return rc
+  }
+
+  a, b       := baseline.latencies, candidate.latencies
+  confidence := 1 - o.Alpha
+
+  // Mean latency.
+  _, _, p   := results.Mann_whitney ( a, b )
+  low, high := results.Bootstrap_difference ( a, b, results.Mean, o.N_resamples, confidence, o.Max_sample, 1 )
+  mean := Metric { Name      : "latency mean",
+                   Baseline  : results.Mean ( a ),
+                   Candidate : results.Mean ( b ),
+                   Low       : low,
+                   High      : high,
+                   P_value   : p }
+  mean.Significant = p < o.Alpha
+  rc.Metrics = append ( rc.Metrics, mean )
+
+  // Latency percentiles.
+  for _, point := range o.Percentiles {
+    point := point
+    statistic := func ( sorted [] float64 ) float64 { return results.Percentile ( sorted, point ) }
+    low, high := results.Bootstrap_difference ( a, b, statistic, o.N_resamples, confidence, o.Max_sample, 1 )
+    m := Metric { Name      : "latency " + results.Percentile_name ( point ),
+                  Baseline  : results.Percentile ( a, point ),
+                  Candidate : results.Percentile ( b, point ),
+                  Low       : low,
+                  High      : high,
+                  P_value   : math.NaN() }
+    // Significant if the interval leaves out zero.
+    m.Significant = low > 0 || high < 0
+    rc.Metrics = append ( rc.Metrics, m )
+  }
+
+  // Connection setup, over the clients.
+  if len ( baseline.connect_msec ) > 0 && len ( candidate.connect_msec ) > 0 {
+    a, b := baseline.connect_msec, candidate.connect_msec
+    _, _, p   := results.Mann_whitney ( a, b )
+    low, high := results.Bootstrap_difference ( a, b, results.Mean, o.N_resamples, confidence, o.Max_sample, 1 )
+    setup := Metric { Name      : "connection setup",
+                      Baseline  : results.Mean ( a ),
+                      Candidate : results.Mean ( b ),
+                      Low       : low,
+                      High      : high,
+                      P_value   : p }
+    setup.Significant = p < o.Alpha
+    rc.Metrics = append ( rc.Metrics, setup )
+  }
+
+  for i := range rc.Metrics {
+    m := & rc.Metrics[i]
+    m.Change     = percent_change ( m.Baseline, m.Candidate )
+    m.Regression = m.Significant && m.Change > o.Threshold
+  }
+
+  // Throughput: lower is worse.
+  throughput := Metric { Name      : "throughput",
+                         Baseline  : baseline.throughput,
+                         Candidate : candidate.throughput,
+                         Change    : percent_change ( baseline.throughput, candidate.throughput ),
+                         Low       : math.NaN(),
+                         High      : math.NaN(),
+                         P_value   : math.NaN() }
+  throughput.Regression = throughput.Change < - o.Threshold
+  rc.Metrics = append ( rc.Metrics, throughput )
+
+  return rc
+}
+
+
+
+
+
+/*
+  Compare every candidate run against the baseline run
+  with the same parameters.
+*/
+func Compare ( baseline_dir, candidate_dir string, o Options ) ( * Comparison, error ) {
+  baseline, err := load_runs ( baseline_dir, & o )
+  if err != nil {
+    return nil, err
+  }
+  candidate, err := load_runs ( candidate_dir, & o )
+  if err != nil {
+    return nil, err
+  }
+
+  c := & Comparison { Baseline_dir  : baseline_dir,
+                      Candidate_dir : candidate_dir }
+
+  if len ( baseline ) == 1 && len ( candidate ) == 1 {
+    c.Runs = append ( c.Runs, o.compare_runs ( baseline[0], candidate[0] ) )
+    return c, nil
+  }
+
+  by_key := make ( map [ string ] * run_data )
+  for _, r := range baseline {
+    by_key [ r.key ] = r
+  }
+  matched := make ( map [ string ] bool )
+  for _, r := range candidate {
+    b, present := by_key [ r.key ]
+    if ! present {
+      c.Unmatched = append ( c.Unmatched, "candidate " + r.path )
+      continue
+    }
+    matched [ r.key ] = true
+    c.Runs = append ( c.Runs, o.compare_runs ( b, r ) )
+  }
+  for _, r := range baseline {
+    if ! matched [ r.key ] {
+      c.Unmatched = append ( c.Unmatched, "baseline  " + r.path )
+    }
+  }
+
+  if len ( c.Runs ) == 0 {
+    return c, fmt.Errorf ( "no runs in |%s| match runs in |%s|", candidate_dir, baseline_dir )
+  }
+  return c, nil
+}
+
+
+
+
+
+/*
+  How many runs could not be compared, either because
+  one side has a problem or because they are unmatched.
+*/
+func ( c * Comparison ) Failures ( ) ( int ) {
+  n := len ( c.Unmatched )
+  for _, r := range c.Runs {
+    if r.Problem != "" {
+      n ++
+    }
+  }
+  return n
+}
+
+
+
+
+
+/*
+  How many metrics, over all the runs, have regressed.
+*/
+func ( c * Comparison ) Regressions ( ) ( int ) {
+  n := 0
+  for _, r := range c.Runs {
+    for _, m := range r.Metrics {
+      if m.Regression {
+        n ++
+      }
+    }
+  }
+  return n
+}
+
+
+
+
+
+func format ( x float64, format string ) ( string ) {
+  if math.IsNaN ( x ) {
+    return "-"
+  }
+  return fmt.Sprintf ( format, x )
+}
+
+
+
+
+
+func ( c * Comparison ) Print ( w io.Writer ) {
+  fp ( w, "baseline  : %s\n", c.Baseline_dir )
+  fp ( w, "candidate : %s\n", c.Candidate_dir )
+
+  for _, r := range c.Runs {
+    fp ( w, "\n%s\n", r.Key )
+    if filepath.Base ( r.Baseline ) != filepath.Base ( r.Candidate ) {
+      fp ( w, "  ( %s  vs  %s )\n", filepath.Base ( r.Baseline ), filepath.Base ( r.Candidate ) )
+    }
+    if r.Problem != "" {
+      fp ( w, "  not compared : %s\n", r.Problem )
+      continue
+    }
+
+    fp ( w, "  %-16s %12s %12s %9s %25s %10s\n", "metric", "baseline", "candidate", "change", "difference interval", "p" )
+    for _, m := range r.Metrics {
+      interval := "-"
+      if ! math.IsNaN ( m.Low ) {
+        interval = fmt.Sprintf ( "[ %.3f, %.3f ]", m.Low, m.High )
+      }
+      verdict := ""
+      switch {
+        case m.Regression  : verdict = "REGRESSION"
+        case m.Significant : verdict = "significant"
+      }
+      line := fmt.Sprintf ( "  %-16s %12.3f %12.3f %8s%% %25s %10s  %s",
+                            m.Name,
+                            m.Baseline,
+                            m.Candidate,
+                            format ( m.Change, "%+.1f" ),
+                            interval,
+                            format ( m.P_value, "%.2g" ),
+                            verdict )
+      fp ( w, "%s\n", strings.TrimRight ( line, " " ) )
+    }
+  }
+
+  if len ( c.Unmatched ) > 0 {
+    fp ( w, "\nunmatched runs:\n" )
+    for _, u := range c.Unmatched {
+      fp ( w, "  %s\n", u )
+    }
+  }
+
+  fp ( w, "\n%d regressions, %d runs not compared.\n", c.Regressions(), c.Failures() )
+}
@@ -0,0 +1,282 @@
+package manifest
+
+import ( "bufio"
+         "encoding/json"
+         "io/ioutil"
+         "os"
+         "os/exec"
+         "runtime"
+         "strconv"
+         "strings"
+         "time"
+
+         rn "router_network"
+         "utils"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A record of the machine that a run ran on, and of everything
+  it ran, so that a surprising result can be explained -- or
+  reproduced -- long after the machine has been changed.
+
+  Anything that can't be found out is left empty, since a missing
+  fact about the host is no reason to stop a test.
+
+===================================================================*/
+
+type Cpu struct {
+  Model                string             `json:"model"`
+  Count                int                `json:"count"`
+  // CPUs per governor, e.g. { "performance" : 8 }
+  Governors            map [ string ] int `json:"governors"`
+  Min_mhz              float64            `json:"current_min_mhz"`
+  Max_mhz              float64            `json:"current_max_mhz"`
+  // From cpupower, if sudo allows it without a password.
+  Available_ghz     [] string             `json:"available_ghz"`
+}
+
+
+
+type Memory struct {
+  Total_kb             int                `json:"total_kb"`
+  Available_kb         int                `json:"available_kb"`
+}
+
+
+
+/*
+  One of the topology's versions, and what is installed there.
+*/
+type Version struct {
+  Name                 string             `json:"name"`
+  Dispatch_root        string             `json:"dispatch_root"`
+  Dispatch_version     string             `json:"dispatch_version"`
+  Dispatch_revision    string             `json:"dispatch_revision"`
+  Proton_root          string             `json:"proton_root"`
+  Proton_version       string             `json:"proton_version"`
+  Proton_revision      string             `json:"proton_revision"`
+}
+
+
+
+type Manifest struct {
+  Time                 string             `json:"time"`
+  Host                 string             `json:"host"`
+  Os                   string             `json:"os"`
+  Kernel               string             `json:"kernel"`
+  Cpu                  Cpu                `json:"cpu"`
+  Memory               Memory             `json:"memory"`
+  Load_average      [] float64            `json:"load_average"`
+
+  Go_version           string             `json:"go_version"`
+  Mercury_root         string             `json:"mercury_root"`
+  Mercury_revision     string             `json:"mercury_revision"`
+  Command_line      [] string             `json:"command_line"`
+  // The mercury command's flags, set or not.
+  Options              map [ string ] string `json:"options"`
+  // See router_network's Topology.Parameters .
+  Parameters           map [ string ] string `json:"parameters"`
+  // The routers' log levels, by module. A module that is
+  // not here logged at the router's own default.
+  Router_logging       map [ string ] string `json:"router_logging"`
+  Versions          [] Version            `json:"versions"`
+}
+
+
+
+
+
+/*
+  Take stock of the host now, for a run of t.
+*/
+func Make ( mercury_root string, t * rn.Topology, options map [ string ] string ) ( * Manifest ) {
+  m := & Manifest { Time         : time.Now().Format ( time.RFC3339 ),
+                    Go_version   : runtime.Version(),
+                    Mercury_root : mercury_root,
+                    Command_line : os.Args,
+                    Options      : options }
+
+  m.Host, _ = os.Hostname ( )
+  m.Os      = os_name ( )
+  m.Kernel  = read_line ( "/proc/sys/kernel/osrelease" )
+
+  m.Cpu = Cpu { Model         : cpu_model ( ),
+                Count         : runtime.NumCPU(),
+                Governors     : utils.Get_CPU_governors ( ),
+                Available_ghz : utils.Get_CPU_freqs ( ) }
+  m.Cpu.Min_mhz, m.Cpu.Max_mhz = utils.Get_CPU_current_freqs ( )
+
+  meminfo := read_fields ( "/proc/meminfo", ":" )
+  m.Memory.Total_kb     = kb ( meminfo [ "MemTotal" ] )
+  m.Memory.Available_kb = kb ( meminfo [ "MemAvailable" ] )
+
+  for _, field := range strings.Fields ( read_line ( "/proc/loadavg" ) ) {
+    if len ( m.Load_average ) == 3 {
+      break
+    }
+    if load, err := strconv.ParseFloat ( field, 64 ); err == nil {
+      m.Load_average = append ( m.Load_average, load )
+    }
+  }
+
+  m.Mercury_revision = git_revision ( mercury_root )
+
+  if t != nil {
+    m.Parameters     = t.Parameters ( )
+    m.Router_logging = t.Logging
+    for _, v := range t.Versions {
+      m.Versions = append ( m.Versions,
+                            Version { Name              : v.Name,
+                                      Dispatch_root     : v.Dispatch_root,
+                                      Dispatch_version  : dispatch_version ( v.Dispatch_root, v.Proton_root ),
+                                      Dispatch_revision : git_revision ( v.Dispatch_root ),
+                                      Proton_root       : v.Proton_root,
+                                      Proton_version    : proton_version ( v.Proton_root ),
+                                      Proton_revision   : git_revision ( v.Proton_root ) } )
+    }
+  }
+
+  return m
+}
+
+
+
+
+
+func ( m * Manifest ) Write_json ( file_name string ) ( error ) {
+  content, err := json.MarshalIndent ( m, "", "  " )
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile ( file_name, append ( content, '\n' ), 0644 )
+}
+
+
+
+
+
+func read_line ( file_name string ) ( string ) {
+  content, err := ioutil.ReadFile ( file_name )
+  if err != nil {
+    return ""
+  }
+  return strings.TrimSpace ( strings.SplitN ( string(content), "\n", 2 ) [ 0 ] )
+}
+
+
+
+
+
+/*
+  Lines of "name <separator> value" .
+*/
+func read_fields ( file_name, separator string ) ( map [ string ] string ) {
+  values := make ( map [ string ] string )
+  f, err := os.Open ( file_name )
+  if err != nil {
+    return values
+  }
+  defer f.Close ( )
+
+  scanner := bufio.NewScanner ( f )
+  for scanner.Scan ( ) {
+    parts := strings.SplitN ( scanner.Text(), separator, 2 )
+    if len ( parts ) == 2 {
+      name := strings.TrimSpace ( parts[0] )
+      if _, present := values [ name ]; ! present {
+        values [ name ] = strings.Trim ( strings.TrimSpace ( parts[1] ), "\"" )
+      }
+    }
+  }
+  return values
+}
+
+
+
+
+
+// "16318180 kB" becomes 16318180 .
+func kb ( value string ) ( int ) {
+  fields := strings.Fields ( value )
+  if len ( fields ) == 0 {
+    return 0
+  }
+  n, _ := strconv.Atoi ( fields[0] )
+  return n
+}
+
+
+
+
+
+func os_name ( ) ( string ) {
+  return read_fields ( "/etc/os-release", "=" ) [ "PRETTY_NAME" ]
+}
+
+
+
+
+
+func cpu_model ( ) ( string ) {
+  return read_fields ( "/proc/cpuinfo", ":" ) [ "model name" ]
+}
+
+
+
+
+
+/*
+  The commit that a directory's source is at, with "-dirty" if
+  it has changes. Install roots are often inside the source tree
+  that they were built from; if not, this is empty.
+*/
+func git_revision ( dir string ) ( string ) {
+  if dir == "" {
+    return ""
+  }
+  out, err := exec.Command ( "git", "-C", dir, "describe", "--always", "--dirty", "--abbrev=12" ).Output()
+  if err != nil {
+    return ""
+  }
+  return strings.TrimSpace ( string(out) )
+}
+
+
+
+
+
+/*
+  Ask the router itself. It needs the Proton libraries to start.
+*/
+func dispatch_version ( dispatch_root, proton_root string ) ( string ) {
+  cmd := exec.Command ( dispatch_root + "/sbin/qdrouterd", "--version" )
+  cmd.Env = append ( os.Environ(),
+                     "LD_LIBRARY_PATH=" + rn.Ld_library_path ( dispatch_root, proton_root ) )
+  out, err := cmd.Output ( )
+  if err != nil {
+    return ""
+  }
+  return strings.TrimSpace ( string(out) )
+}
+
+
+
+
+
+/*
+  Proton installs a pkg-config file that has its version.
+*/
+func proton_version ( proton_root string ) ( string ) {
+  for _, lib := range [] string { "lib64", "lib" } {
+    pc := read_fields ( proton_root + "/" + lib + "/pkgconfig/libqpid-proton.pc", ":" )
+    if version, present := pc [ "Version" ]; present {
+      return version
+    }
+  }
+  return ""
+}
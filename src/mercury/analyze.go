@@ -0,0 +1,187 @@
+package main
+
+import ( "errors"
+         "flag"
+         "os"
+         "path/filepath"
+         "strconv"
+         "strings"
+         "time"
+
+         "chart"
+         "report"
+         "results"
+         rn "router_network"
+         "summary"
+         "utils"
+       )
+
+
+
+
+
+/*
+  Parse a comma-separated list of percentiles, such as  50,90,99,99.9
+*/
+func parse_percentiles ( spec string ) ( [] float64, error ) {
+  var percentiles [] float64
+  for _, field := range strings.Split ( spec, "," ) {
+    if field = strings.TrimSpace ( field ); field == "" {
+      continue
+    }
+    p, err := strconv.ParseFloat ( field, 64 )
+    if err != nil || p < 0 || p > 100 {
+      return nil, errors.New ( "bad percentile: " + field )
+    }
+    percentiles = append ( percentiles, p )
+  }
+  return percentiles, nil
+}
+
+
+
+
+
+/*
+  Chart one run: its trimmed timeline, a latency histogram,
+  and its throughput, in run/graphics.
+*/
+func chart_run ( run string, trimmed * results.Results, throughput summary.Throughput ) ( error ) {
+  graphics_path := run + "/graphics"
+  utils.Find_or_create_dir ( graphics_path )
+  name := filepath.Base ( run )
+
+  if err := chart.Timeline ( trimmed, "Timeline -- " + name ).Write_all ( graphics_path, "timeline" ); err != nil {
+    return err
+  }
+  window := time.Duration ( throughput.Window * float64(time.Second) )
+  if err := chart.Throughput ( trimmed, window, throughput.Offered, "Throughput -- " + name ).Write_all ( graphics_path, "throughput" ); err != nil {
+    return err
+  }
+  return chart.Histogram ( trimmed, 50, "Latency Histogram -- " + name ).Write_all ( graphics_path, "histogram" )
+}
+
+
+
+
+
+func analyze_command ( args [] string ) ( int ) {
+  var warm_up, cool_down time.Duration
+  var percentiles_spec   string
+  var charts, gnuplot    bool
+  var window             time.Duration
+
+  flags := flag.NewFlagSet ( "analyze", flag.ExitOnError )
+  flags.DurationVar ( & warm_up,          "warm_up",     time.Second,   "ignore messages that arrive in the first part of a run" )
+  flags.DurationVar ( & cool_down,        "cool_down",   time.Second,   "ignore messages that arrive in the last part of a run" )
+  flags.StringVar   ( & percentiles_spec, "percentiles", "50,90,99,99.9", "latency percentiles to report" )
+  flags.BoolVar     ( & charts,           "charts",      false,         "draw charts of each run, and of the whole test" )
+  flags.BoolVar     ( & gnuplot,          "gnuplot",     false,         "with -charts, export them to gnuplot as well, and run it" )
+  flags.DurationVar ( & window,           "window",      results.Default_throughput_window, "width of the sliding window that throughput is counted over" )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury analyze [flags] test_or_run_dir ...\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+  chart.Export_gnuplot = gnuplot
+
+  if flags.NArg() < 1 {
+    flags.Usage ( )
+    return 2
+  }
+
+  percentiles, err := parse_percentiles ( percentiles_spec )
+  if err != nil {
+    ume ( "mercury analyze: -percentiles |%s| : %s", percentiles_spec, err.Error() )
+    return 2
+  }
+
+  status := 0
+  for _, dir := range flags.Args() {
+    runs := report.Find_runs ( dir )
+    if len ( runs ) == 0 {
+      ume ( "mercury analyze: no runs in |%s|", dir )
+      status = 1
+      continue
+    }
+
+    fp ( os.Stdout, "%-40s %10s %10s %10s %10s %10s", "run", "messages", "min", "mean", "stddev", "max" )
+    for _, p := range percentiles {
+      fp ( os.Stdout, " %10s", results.Percentile_name ( p ) )
+    }
+    fp ( os.Stdout, " %10s %10s\n", "msg/sec", "offered" )
+
+    var xs        [] float64
+    var all_stats [] results.Stats
+    var achieved, offered [] float64
+
+    for i, run := range runs {
+      r, err := results.Load ( run + "/result" )
+      if err != nil {
+        ume ( "mercury analyze: %s", err.Error() )
+        status = 1
+        continue
+      }
+
+      // Without its topology, a run's offered load is not known.
+      t, err := rn.Read_topology_file ( run + "/topology.json" )
+      if err != nil {
+        t = nil
+      }
+
+      trimmed    := r.Trim ( warm_up, cool_down )
+      stats      := trimmed.Stats ( percentiles ... )
+      throughput := summary.Measure_throughput ( trimmed, t, window )
+      xs        = append ( xs,        report.Run_x ( run, i ) )
+      all_stats = append ( all_stats, stats )
+      achieved  = append ( achieved,  throughput.Median )
+      offered   = append ( offered,   throughput.Offered )
+
+      if charts && stats.Count > 0 {
+        if err = chart_run ( run, trimmed, throughput ); err != nil {
+          ume ( "mercury analyze: %s", err.Error() )
+        }
+      }
+
+      if stats.Count == 0 {
+        fp ( os.Stdout, "%-40s %10d\n", filepath.Base ( run ), 0 )
+        continue
+      }
+
+      fp ( os.Stdout, 
+           "%-40s %10d %10.3f %10.3f %10.3f %10.3f", 
+           filepath.Base ( run ), 
+           stats.Count,
+           stats.Min,
+           stats.Mean,
+           stats.Stddev,
+           stats.Max )
+      for _, value := range stats.Percentiles {
+        fp ( os.Stdout, " %10.3f", value )
+      }
+      fp ( os.Stdout, " %10.1f", throughput.Median )
+      if throughput.Offered > 0 {
+        fp ( os.Stdout, " %10.1f", throughput.Offered )
+      } else {
+        fp ( os.Stdout, " %10s", "-" )
+      }
+      if throughput.Saturated ( ) {
+        fp ( os.Stdout, "  saturated" )
+      }
+      fp ( os.Stdout, "\n" )
+    }
+
+    if charts && len ( runs ) > 1 {
+      c := chart.Latency_by ( xs, all_stats, "run", "Latency -- " + filepath.Base ( dir ) )
+      if err = c.Write_all ( dir, "latency" ); err != nil {
+        ume ( "mercury analyze: %s", err.Error() )
+      }
+      c = chart.Throughput_by ( xs, achieved, offered, "run", "Throughput -- " + filepath.Base ( dir ) )
+      if err = c.Write_all ( dir, "throughput" ); err != nil {
+        ume ( "mercury analyze: %s", err.Error() )
+      }
+    }
+  }
+
+  return status
+}
@@ -0,0 +1,64 @@
+package main
+
+import ( "flag"
+         "os"
+         "time"
+
+         "report"
+       )
+
+
+
+
+
+/*
+  Remove the output of finished tests. To avoid removing
+  anything else by mistake, a directory is only removed if
+  it holds at least one run directory. Anything the tests left
+  running is killed first, since its PID registry is about to go.
+*/
+func clean_command ( args [] string ) ( int ) {
+  var dry_run bool
+
+  flags := flag.NewFlagSet ( "clean", flag.ExitOnError )
+  flags.BoolVar ( & dry_run, "n", false, "only print what would be removed" )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury clean [flags] test_dir ...\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+
+  if flags.NArg() < 1 {
+    flags.Usage ( )
+    return 2
+  }
+
+  status := 0
+  for _, dir := range flags.Args() {
+    if len ( report.Find_runs ( dir ) ) == 0 {
+      ume ( "mercury clean: |%s| does not look like mercury test output. Not removing it.", dir )
+      status = 1
+      continue
+    }
+
+    if _, failed := kill_leftovers ( dir, 5 * time.Second, dry_run ); failed {
+      ume ( "mercury clean: |%s| still has processes running. Not removing it.", dir )
+      status = 1
+      continue
+    }
+
+    if dry_run {
+      fp ( os.Stdout, "would remove %s\n", dir )
+      continue
+    }
+
+    if err := os.RemoveAll ( dir ); err != nil {
+      ume ( "mercury clean: %s", err.Error() )
+      status = 1
+      continue
+    }
+    fp ( os.Stdout, "removed %s\n", dir )
+  }
+
+  return status
+}
@@ -0,0 +1,100 @@
+package main
+
+import ( "flag"
+         "os"
+         "path/filepath"
+         "time"
+
+         "utils"
+       )
+
+
+
+
+
+/*
+  Kill the routers and clients that tests under dir started and
+  left running, as recorded in the tests' PID registries.
+  Returns how many were found, and whether any of them could
+  not be killed.
+*/
+func kill_leftovers ( dir string, grace time.Duration, dry_run bool ) ( found int, failed bool ) {
+  filepath.Walk ( dir,
+                  func ( path string, info os.FileInfo, err error ) error {
+                    if err != nil || info.IsDir() || info.Name() != "pids" {
+                      return nil
+                    }
+                    processes, err := utils.Read_process_registry ( path )
+                    if err != nil {
+                      ume ( "mercury: can't read |%s| : %s", path, err.Error() )
+                      failed = true
+                      return nil
+                    }
+
+                    for _, p := range processes {
+                      if ! p.Is_running ( ) {
+                        continue
+                      }
+                      found ++
+                      if dry_run {
+                        fp ( os.Stdout, "would kill %s %s (pid %d) %s\n", p.Kind, p.Name, p.Pid, p.Executable )
+                        continue
+                      }
+                      forced, err := p.Kill ( grace )
+                      if err != nil {
+                        ume ( "mercury: can't kill %s |%s| (pid %d) : %s", p.Kind, p.Name, p.Pid, err.Error() )
+                        failed = true
+                        continue
+                      }
+                      how := "stopped"
+                      if forced {
+                        how = "killed"
+                      }
+                      fp ( os.Stdout, "%s %s %s (pid %d)\n", how, p.Kind, p.Name, p.Pid )
+                    }
+                    return nil
+                  } )
+  return found, failed
+}
+
+
+
+
+
+/*
+  Find the routers and clients that interrupted tests left
+  running, and kill them.
+*/
+func cleanup_command ( args [] string ) ( int ) {
+  var dry_run bool
+  var grace   time.Duration
+
+  flags := flag.NewFlagSet ( "cleanup", flag.ExitOnError )
+  flags.BoolVar     ( & dry_run, "n",     false,           "only print what would be killed" )
+  flags.DurationVar ( & grace,   "grace", 5 * time.Second, "time each process gets to exit before it is killed" )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury cleanup [flags] test_dir ...\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+
+  if flags.NArg() < 1 {
+    flags.Usage ( )
+    return 2
+  }
+
+  status := 0
+  total  := 0
+  for _, dir := range flags.Args() {
+    found, failed := kill_leftovers ( dir, grace, dry_run )
+    total += found
+    if failed {
+      status = 1
+    }
+  }
+
+  if total == 0 {
+    fp ( os.Stdout, "nothing left running\n" )
+  }
+  return status
+}
@@ -0,0 +1,57 @@
+package main
+
+import ( "flag"
+         "os"
+         "time"
+
+         "compare"
+       )
+
+
+
+
+
+func compare_command ( args [] string ) ( int ) {
+  var o compare.Options
+  var percentiles string
+
+  flags := flag.NewFlagSet ( "compare", flag.ExitOnError )
+  flags.Float64Var  ( & o.Threshold,   "threshold",   5,           "percent change that counts as a regression, if it is significant" )
+  flags.Float64Var  ( & o.Alpha,       "alpha",       0.05,        "significance level" )
+  flags.StringVar   ( & percentiles,   "percentiles", "50,99",     "latency percentiles to compare" )
+  flags.IntVar      ( & o.N_resamples, "resamples",   1000,        "bootstrap resamples" )
+  flags.IntVar      ( & o.Max_sample,  "max_sample",  10000,       "messages per run to bootstrap from" )
+  flags.DurationVar ( & o.Warm_up,     "warm_up",     time.Second, "leave the first part of each run out" )
+  flags.DurationVar ( & o.Cool_down,   "cool_down",   time.Second, "leave the last part of each run out" )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury compare [flags] baseline_dir candidate_dir\n" )
+    fp ( os.Stderr, "  Each dir is a test or a single run. Exits with 1 if the candidate has regressed,\n" )
+    fp ( os.Stderr, "  or if any run failed, has no messages, or has no match on the other side.\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+
+  if flags.NArg() != 2 {
+    flags.Usage ( )
+    return 2
+  }
+
+  var err error
+  if o.Percentiles, err = parse_percentiles ( percentiles ); err != nil {
+    ume ( "mercury compare: %s", err.Error() )
+    return 2
+  }
+
+  c, err := compare.Compare ( flags.Arg(0), flags.Arg(1), o )
+  if err != nil {
+    ume ( "mercury compare: %s", err.Error() )
+    return 2
+  }
+
+  c.Print ( os.Stdout )
+
+  if c.Regressions() > 0 || c.Failures() > 0 {
+    return 1
+  }
+  return 0
+}
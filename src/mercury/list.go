@@ -0,0 +1,61 @@
+package main
+
+import ( "flag"
+         "os"
+         "path/filepath"
+
+         rn "router_network"
+       )
+
+
+
+
+
+/*
+  List the scenario files in a directory, with a one-line
+  description of each.
+*/
+func list_command ( args [] string ) ( int ) {
+  flags := flag.NewFlagSet ( "list", flag.ExitOnError )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury list [scenario_dir]\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+
+  dir := "."
+  if flags.NArg() > 0 {
+    dir = flags.Arg(0)
+  }
+
+  file_names, err := filepath.Glob ( dir + "/*.json" )
+  if err != nil {
+    ume ( "mercury list: %s", err.Error() )
+    return 1
+  }
+
+  status := 0
+  for _, file_name := range file_names {
+    t, err := rn.Read_topology_file ( file_name )
+    if err != nil {
+      fp ( os.Stdout, "%-30s  error: %s\n", filepath.Base ( file_name ), err.Error() )
+      status = 1
+      continue
+    }
+
+    n_pairs := 0
+    for _, p := range t.Client_pairs {
+      n_pairs += p.Count
+    }
+
+    fp ( os.Stdout, "%-30s  %-20s  shapes: %d  routers: %d  clients: %d  client pairs: %d\n",
+         filepath.Base ( file_name ),
+         t.Name,
+         len ( t.Shapes ),
+         len ( t.Routers ),
+         len ( t.Clients ),
+         n_pairs )
+  }
+
+  return status
+}
@@ -0,0 +1,99 @@
+/*
+  mercury is the single command-line runner for Mercury scenarios.
+
+  A scenario is a topology file (see router_network/topology.go).
+  Build and install it with
+
+    GOPATH=${MERCURY_ROOT} go install mercury
+
+  and then, for example,
+
+    mercury run   -v topologies/basic.json
+    mercury sweep -param message_length=5100:10000:100 topologies/message_size.json
+    mercury sweep -sweep sweeps/latency.json
+    mercury analyze results/basic_2020_01_01_1200
+    mercury report  results/basic_2020_01_01_1200
+    mercury compare results/basic_2020_01_01_1200 results/basic_2020_01_02_0900
+    mercury list  topologies
+    mercury cleanup results
+    mercury clean results/basic_2020_01_01_1200
+*/
+package main
+
+import ( "fmt"
+         "os"
+         "sort"
+
+         rn "router_network"
+         "utils"
+       )
+
+
+var fp  = fmt.Fprintf
+var ume = utils.M_error
+var umi = utils.M_info
+
+
+
+
+
+type command struct {
+  run         func ( args [] string ) ( int )
+  summary     string
+}
+
+
+
+var commands = map [ string ] command {
+  "run"     : { run_command,     "run a scenario once" },
+  "sweep"   : { sweep_command,   "run a scenario once for each value of a parameter" },
+  "analyze" : { analyze_command, "print latency statistics for finished runs" },
+  "report"  : { report_command,  "write an HTML report of finished runs" },
+  "compare" : { compare_command, "compare finished runs against a baseline, and find regressions" },
+  "list"    : { list_command,    "list the scenario files in a directory" },
+  "cleanup" : { cleanup_command, "kill routers and clients that interrupted tests left running" },
+  "clean"   : { clean_command,   "remove the output of finished tests" },
+}
+
+
+
+
+
+func usage ( ) {
+  fp ( os.Stderr, "usage: mercury <command> [flags] [arguments]\n\n" )
+  fp ( os.Stderr, "commands:\n" )
+
+  var names [] string
+  for name := range commands {
+    names = append ( names, name )
+  }
+  sort.Strings ( names )
+  for _, name := range names {
+    fp ( os.Stderr, "  %-10s %s\n", name, commands[name].summary )
+  }
+
+  fp ( os.Stderr, "\nUse \"mercury <command> -h\" for the flags of a command.\n" )
+}
+
+
+
+
+
+func main ( ) {
+  // Don't leave routers running if anything goes badly wrong.
+  defer rn.Halt_all_on_panic ( )
+
+  if len ( os.Args ) < 2 {
+    usage ( )
+    os.Exit ( 2 )
+  }
+
+  cmd, present := commands [ os.Args[1] ]
+  if ! present {
+    fp ( os.Stderr, "mercury: unknown command |%s|\n\n", os.Args[1] )
+    usage ( )
+    os.Exit ( 2 )
+  }
+
+  os.Exit ( cmd.run ( os.Args[2:] ) )
+}
@@ -0,0 +1,43 @@
+package main
+
+import ( "flag"
+         "os"
+         "time"
+
+         "report"
+       )
+
+
+
+
+
+func report_command ( args [] string ) ( int ) {
+  var warm_up, cool_down time.Duration
+
+  flags := flag.NewFlagSet ( "report", flag.ExitOnError )
+  flags.DurationVar ( & warm_up,   "warm_up",   time.Second, "leave the first part of each run out of the report" )
+  flags.DurationVar ( & cool_down, "cool_down", time.Second, "leave the last part of each run out of the report" )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury report [flags] test_or_run_dir ...\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+
+  if flags.NArg() < 1 {
+    flags.Usage ( )
+    return 2
+  }
+
+  status := 0
+  for _, dir := range flags.Args() {
+    file_name, err := report.Write ( dir, warm_up, cool_down )
+    if err != nil {
+      ume ( "mercury report: %s", err.Error() )
+      status = 1
+      continue
+    }
+    fp ( os.Stdout, "%s\n", file_name )
+  }
+
+  return status
+}
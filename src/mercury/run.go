@@ -0,0 +1,429 @@
+package main
+
+import ( "errors"
+         "flag"
+         "fmt"
+         "os"
+         "path/filepath"
+         "strconv"
+         "strings"
+         "time"
+
+         "manifest"
+         "report"
+         "router"
+         rn "router_network"
+         "summary"
+         "utils"
+       )
+
+
+
+
+
+/*
+  A repeatable -version flag:  -version name=proton_root,dispatch_root
+  Each one replaces (or adds) a version in the scenario's topology,
+  so the same scenario file can be run against different builds.
+*/
+type version_flags [] rn.Topology_version
+
+func ( v * version_flags ) String ( ) ( string ) {
+  var strs [] string
+  for _, version := range * v {
+    strs = append ( strs, version.Name + "=" + version.Proton_root + "," + version.Dispatch_root )
+  }
+  return strings.Join ( strs, " " )
+}
+
+func ( v * version_flags ) Set ( value string ) ( error ) {
+  equals := strings.Index ( value, "=" )
+  if equals < 1 {
+    return errors.New ( "expected name=proton_root,dispatch_root" )
+  }
+  roots := strings.Split ( value[equals+1:], "," )
+  if len ( roots ) != 2 {
+    return errors.New ( "expected name=proton_root,dispatch_root" )
+  }
+  * v = append ( * v, rn.Topology_version { Name          : value[:equals],
+                                            Proton_root   : roots[0],
+                                            Dispatch_root : roots[1] } )
+  return nil
+}
+
+
+
+
+
+/*
+  A repeatable -env flag:  -env NAME=VALUE
+  Each one is set in the environment of every router and client.
+*/
+type env_flags [] string
+
+func ( e * env_flags ) String ( ) ( string ) {
+  return strings.Join ( * e, " " )
+}
+
+func ( e * env_flags ) Set ( value string ) ( error ) {
+  if strings.Index ( value, "=" ) < 1 {
+    return errors.New ( "expected NAME=VALUE" )
+  }
+  * e = append ( * e, value )
+  return nil
+}
+
+
+
+
+
+/*
+  A repeatable -log flag:  -log [MODULE=]LEVEL
+  Without a module, the level is for DEFAULT, e.g. -log none
+*/
+type log_flags [] string
+
+func ( l * log_flags ) String ( ) ( string ) {
+  return strings.Join ( * l, " " )
+}
+
+func ( l * log_flags ) Set ( value string ) ( error ) {
+  level := value
+  if equals := strings.Index ( value, "=" ); equals >= 0 {
+    level = value[equals+1:]
+  }
+  if err := router.Check_log_level ( level ); err != nil {
+    return err
+  }
+  * l = append ( * l, value )
+  return nil
+}
+
+
+
+
+
+/*
+  Everything a run needs to know that is not part of the topology.
+*/
+type run_options struct {
+  mercury_root         string
+  output_dir           string
+  versions             version_flags
+  env                  env_flags
+  log_levels           log_flags
+  verbose              bool
+  ready_timeout        time.Duration
+  stall_timeout        time.Duration
+  settle_time          time.Duration
+  receive_timeout      time.Duration
+  dump_timeout         time.Duration
+  warm_up              time.Duration
+  cool_down            time.Duration
+  sample_interval      time.Duration
+  abort_on_exit        bool
+  halt_grace           time.Duration
+  worker_threads       int
+  tls                  string
+  sasl                 string
+
+  // All the command's flags, for the manifest.
+  flags              * flag.FlagSet
+}
+
+
+
+
+
+func ( o * run_options ) add_flags ( flags * flag.FlagSet ) {
+  o.flags = flags
+  flags.StringVar   ( & o.mercury_root,    "root",            os.Getenv ( "MERCURY_ROOT" ), "mercury install root" )
+  flags.StringVar   ( & o.output_dir,      "o",               ".",                          "directory in which to put test output" )
+  flags.Var         ( & o.versions,        "version",                                       "name=proton_root,dispatch_root  (repeatable)" )
+  flags.Var         ( & o.env,             "env",                                           "NAME=VALUE for every router and client  (repeatable)" )
+  flags.Var         ( & o.log_levels,      "log",                                           "[MODULE=]LEVEL for the routers' logs, e.g. none, trace+, ROUTER_CORE=debug+  (repeatable)" )
+  flags.BoolVar     ( & o.verbose,         "v",               false,                        "verbose" )
+  flags.DurationVar ( & o.ready_timeout,   "ready_timeout",   60 * time.Second,             "fail if the routers are not all ready after this long" )
+  flags.DurationVar ( & o.stall_timeout,   "stall_timeout",   rn.Default_stall_timeout,     "fail if the receivers make no progress for this long, or for 5 throttles of the slowest sender (0 == never)" )
+  flags.DurationVar ( & o.settle_time,     "settle",          0,                            "extra wait after the network is ready, before sending" )
+  flags.DurationVar ( & o.receive_timeout, "receive_timeout", 0,                            "give up on receivers after this long (0 == never)" )
+  flags.DurationVar ( & o.dump_timeout,    "dump_timeout",    60 * time.Second,             "time allowed for clients to write their data" )
+  flags.DurationVar ( & o.warm_up,         "warm_up",         time.Second,                  "leave the first part of each run out of the report" )
+  flags.DurationVar ( & o.cool_down,       "cool_down",       time.Second,                  "leave the last part of each run out of the report" )
+  flags.DurationVar ( & o.sample_interval, "sample_interval", 5 * time.Second,              "how often to sample the routers and clients from /proc (0 == never)" )
+  flags.BoolVar     ( & o.abort_on_exit,   "abort_on_exit",   true,                         "end a run as soon as a router or client exits unexpectedly" )
+  flags.DurationVar ( & o.halt_grace,      "halt_grace",      rn.Default_halt_grace,        "time each router gets to shut down before it is killed" )
+  flags.IntVar      ( & o.worker_threads,  "worker_threads",  0,                            "worker threads for every router (0 == as the scenario says)" )
+  flags.StringVar   ( & o.tls,             "tls",             "",                           "listeners that use TLS, e.g. client,inter-router,edge, or none (default: as the scenario says)" )
+  flags.StringVar   ( & o.sasl,            "sasl",            "",                           "MECHANISM[:LISTENER,...] to authenticate with, e.g. SCRAM-SHA-1:client, or none (default: as the scenario says)" )
+}
+
+
+
+
+
+/*
+  Read the scenario file and apply the -version, -env, -log,
+  -worker_threads, -tls and -sasl overrides. A -sasl with no
+  listeners is for all of them.
+*/
+func ( o * run_options ) read_topology ( file_name string ) ( * rn.Topology, error ) {
+  if o.mercury_root == "" {
+    return nil, errors.New ( "no mercury root: set MERCURY_ROOT or use -root" )
+  }
+
+  t, err := rn.Read_topology_file ( file_name )
+  if err != nil {
+    return nil, err
+  }
+
+  for _, v := range o.versions {
+    t.Set_version ( v.Name, v.Proton_root, v.Dispatch_root )
+  }
+
+  for _, name_value := range o.env {
+    if t.Environment == nil {
+      t.Environment = make ( map [ string ] string )
+    }
+    equals := strings.Index ( name_value, "=" )
+    t.Environment [ name_value[:equals] ] = name_value[equals+1:]
+  }
+
+  for _, module_level := range o.log_levels {
+    if t.Logging == nil {
+      t.Logging = make ( map [ string ] string )
+    }
+    module, level := "DEFAULT", module_level
+    if equals := strings.Index ( module_level, "=" ); equals >= 0 {
+      module, level = module_level[:equals], module_level[equals+1:]
+    }
+    t.Logging [ module ] = level
+  }
+
+  if o.worker_threads != 0 {
+    if err = t.Set_parameter ( "worker_threads", strconv.Itoa ( o.worker_threads ) ); err != nil {
+      return nil, err
+    }
+  }
+
+  if o.tls != "" {
+    t.Set_parameter ( "tls", o.tls )
+  }
+  if o.sasl != "" {
+    t.Set_parameter ( "sasl", o.sasl )
+  }
+
+  if t.Name == "" {
+    t.Name = strings.TrimSuffix ( filepath.Base ( file_name ), filepath.Ext ( file_name ) )
+  }
+
+  return t, t.Validate ( )
+}
+
+
+
+
+
+/*
+  The value of every flag of the command, whether it was given
+  or not, as it is after the command line has been parsed.
+*/
+func ( o * run_options ) options ( ) ( map [ string ] string ) {
+  options := make ( map [ string ] string )
+  if o.flags != nil {
+    o.flags.VisitAll ( func ( f * flag.Flag ) { options [ f.Name ] = f.Value.String() } )
+  }
+  return options
+}
+
+
+
+
+
+/*
+  The directory that holds all the runs of one test.
+*/
+func ( o * run_options ) test_path ( t * rn.Topology ) ( string ) {
+  return o.output_dir + "/" + t.Name + "_" + time.Now().Format ( "2006_01_02_1504" )
+}
+
+
+
+
+
+/*
+  Write the HTML report for everything run so far in test_path.
+  A report that can't be written does not fail the test.
+*/
+func ( o * run_options ) write_report ( test_path string ) {
+  file_name, err := report.Write ( test_path, o.warm_up, o.cool_down )
+  if err != nil {
+    ume ( "mercury: can't write report: %s", err.Error() )
+    return
+  }
+  fp ( os.Stdout, "Report: %s\n", file_name )
+}
+
+
+
+
+
+/*
+  Write the run's summary.json, and add it to the test's summary.csv .
+  A summary that can't be written does not fail the run.
+*/
+func write_summary ( t            * rn.Topology,
+                     o            * run_options,
+                     network      * rn.Router_network,
+                     test_path      string,
+                     run_name       string,
+                     test_error     string ) {
+  run_path := test_path + "/" + run_name
+  s, err := summary.Make ( filepath.Base ( test_path ),
+                           run_name,
+                           run_path + "/result",
+                           t,
+                           network.Client_statuses ( ),
+                           test_error,
+                           o.warm_up,
+                           o.cool_down )
+  if err == nil {
+    err = s.Write_json ( run_path + "/summary.json" )
+  }
+  if err == nil {
+    err = s.Append_csv ( test_path + "/summary.csv" )
+  }
+  if err != nil {
+    ume ( "mercury: can't write summary of |%s| : %s", run_name, err.Error() )
+  }
+}
+
+
+
+
+
+/*
+  Build the network described by the topology, run it until the
+  receivers are done, collect the results, and halt it.
+  The run's output goes in test_path/run_name.
+*/
+func run_topology ( t * rn.Topology, o * run_options, test_path, run_name string ) ( error ) {
+  run_path := test_path + "/" + run_name
+  utils.Find_or_create_dir ( run_path )
+
+  if err := t.Write_file ( run_path + "/topology.json" ); err != nil {
+    return err
+  }
+
+  // What the host was like just before the run. A manifest that
+  // can't be written does not fail the run.
+  m := manifest.Make ( o.mercury_root, t, o.options() )
+  if err := m.Write_json ( run_path + "/manifest.json" ); err != nil {
+    ume ( "mercury: can't write manifest of |%s| : %s", run_name, err.Error() )
+  }
+
+  network, err := t.Build_network ( o.mercury_root, run_path )
+  if err != nil {
+    return err
+  }
+  network.Verbose ( o.verbose )
+  network.Set_ready_timeout ( o.ready_timeout )
+  network.Set_stall_timeout ( o.stall_timeout )
+  network.Set_sample_interval ( o.sample_interval )
+  network.Abort_on_exit ( o.abort_on_exit )
+  network.Set_halt_grace ( o.halt_grace )
+
+  fp ( os.Stdout, "Running: %s at %v\n", run_name, time.Now() )
+  if err = network.Init ( ); err == nil {
+    err = network.Run ( )
+  }
+  if err != nil {
+    network.Halt ( )
+    utils.Write_result_file ( run_path + "/result", err.Error() )
+    write_summary ( t, o, network, test_path, run_name, err.Error() )
+    return err
+  }
+  umi ( o.verbose, "network |%s| is running.", run_name )
+
+  time.Sleep ( o.settle_time )
+
+  var test_error string
+  if err = network.Start_sending ( ); err != nil {
+    test_error = err.Error()
+  } else if err = network.Wait_for_receivers ( o.receive_timeout ); err != nil {
+    test_error = err.Error()
+  }
+
+  // Only wait for dumps if they were asked for.
+  err = network.Dump_data ( )
+  if err == nil {
+    err = network.Wait_for_dumps ( o.dump_timeout )
+  }
+  if err != nil && test_error == "" {
+    test_error = err.Error()
+  }
+
+  network.Halt ( )
+
+  // A run in which anything crashed has failed, whatever else
+  // happened, or its latencies would be taken at face value.
+  if exits := network.Unexpected_exits ( ); len ( exits ) > 0 {
+    crash := exits[0].String() + " unexpectedly"
+    if len ( exits ) > 1 {
+      crash += fmt.Sprintf ( ", and %d more", len(exits) - 1 )
+    }
+    if test_error == "" {
+      test_error = crash
+    } else if ! strings.Contains ( test_error, exits[0].String() ) {
+      test_error = crash + " : " + test_error
+    }
+  }
+
+  if err = utils.Write_result_file ( run_path + "/result", test_error ); err != nil {
+    return err
+  }
+  write_summary ( t, o, network, test_path, run_name, test_error )
+
+  if test_error != "" {
+    return errors.New ( test_error )
+  }
+  return nil
+}
+
+
+
+
+
+func run_command ( args [] string ) ( int ) {
+  var o run_options
+
+  flags := flag.NewFlagSet ( "run", flag.ExitOnError )
+  o.add_flags ( flags )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury run [flags] scenario_file\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+
+  if flags.NArg() != 1 {
+    flags.Usage ( )
+    return 2
+  }
+
+  t, err := o.read_topology ( flags.Arg(0) )
+  if err != nil {
+    ume ( "mercury run: %s", err.Error() )
+    return 1
+  }
+
+  test_path := o.test_path ( t )
+  err = run_topology ( t, & o, test_path, t.Name )
+  o.write_report ( test_path )
+  if err != nil {
+    fp ( os.Stdout, "test %s failed: %s\n", t.Name, err.Error() )
+    return 1
+  }
+
+  fp ( os.Stdout, "Test %s done at %s\n", test_path, time.Now().Format ( "2006_01_02_1504" ) )
+  return 0
+}
@@ -0,0 +1,177 @@
+package main
+
+import ( "encoding/json"
+         "flag"
+         "io/ioutil"
+         "os"
+         "path/filepath"
+         "strings"
+         "time"
+
+         rn "router_network"
+         "sweep"
+       )
+
+
+
+
+
+/*
+  A repeatable -param flag:  -param name=values
+*/
+type param_flags [] string
+
+func ( p * param_flags ) String ( ) ( string ) {
+  return strings.Join ( * p, " " )
+}
+
+func ( p * param_flags ) Set ( value string ) ( error ) {
+  * p = append ( * p, value )
+  return nil
+}
+
+
+
+
+
+/*
+  Make the sweep from the command line: either a sweep file,
+  or one cartesian stage from the -param flags. The old form,
+  -param name -values spec , still works.
+*/
+func make_sweep ( sweep_file string, params param_flags, values_spec string ) ( * sweep.Sweep, error ) {
+  if sweep_file != "" {
+    return sweep.Read_sweep_file ( sweep_file )
+  }
+
+  var stage sweep.Stage
+  if values_spec != "" && len ( params ) == 1 && ! strings.Contains ( params[0], "=" ) {
+    params[0] += "=" + values_spec
+  }
+  for _, param := range params {
+    p, err := sweep.Parse_parameter ( param )
+    if err != nil {
+      return nil, err
+    }
+    stage.Parameters = append ( stage.Parameters, p )
+  }
+  return & sweep.Sweep { Stages : [] sweep.Stage { stage } }, nil
+}
+
+
+
+
+
+func sweep_command ( args [] string ) ( int ) {
+  var o run_options
+  var params      param_flags
+  var values_spec string
+  var sweep_file  string
+  var pause       time.Duration
+
+  flags := flag.NewFlagSet ( "sweep", flag.ExitOnError )
+  o.add_flags ( flags )
+  flags.Var         ( & params,      "param",                    "name=values : a parameter to vary, with values a,b,c or start:stop:step  (repeatable)" )
+  flags.StringVar   ( & values_spec, "values", "",               "values for a single -param name" )
+  flags.StringVar   ( & sweep_file,  "sweep",  "",               "sweep file, instead of -param" )
+  flags.DurationVar ( & pause,       "pause",  10 * time.Second, "pause between runs, if the sweep file does not say" )
+  flags.Usage = func ( ) {
+    fp ( os.Stderr, "usage: mercury sweep -param name=values ... [flags] scenario_file\n" )
+    fp ( os.Stderr, "       mercury sweep -sweep sweep_file [flags] [scenario_file]\n" )
+    flags.PrintDefaults ( )
+  }
+  flags.Parse ( args )
+
+  if ( sweep_file == "" ) == ( len ( params ) == 0 ) || flags.NArg() > 1 {
+    flags.Usage ( )
+    return 2
+  }
+
+  s, err := make_sweep ( sweep_file, params, values_spec )
+  if err != nil {
+    ume ( "mercury sweep: %s", err.Error() )
+    return 2
+  }
+
+  // The scenario on the command line wins over the one in the sweep file,
+  // which is relative to the sweep file.
+  scenario := s.Scenario
+  if flags.NArg() == 1 {
+    scenario = flags.Arg(0)
+  } else if scenario != "" && ! filepath.IsAbs ( scenario ) {
+    scenario = filepath.Join ( filepath.Dir ( sweep_file ), scenario )
+  }
+  if scenario == "" {
+    ume ( "mercury sweep: no scenario file" )
+    return 2
+  }
+
+  if pause, err = s.Pause_duration ( pause ); err != nil {
+    ume ( "mercury sweep: pause |%s| : %s", s.Pause, err.Error() )
+    return 2
+  }
+
+  t, err := o.read_topology ( scenario )
+  if err != nil {
+    ume ( "mercury sweep: %s", err.Error() )
+    return 1
+  }
+
+  if err = s.Check ( t ); err != nil {
+    ume ( "mercury sweep: %s", err.Error() )
+    return 1
+  }
+  runs, _ := s.Runs ( )
+  fp ( os.Stdout, "Sweep of %d runs.\n", len(runs) )
+
+  test_path := o.test_path ( t )
+  if s.Name != "" {
+    test_path = o.output_dir + "/" + s.Name + "_" + time.Now().Format ( "2006_01_02_1504" )
+  }
+
+  // Keep the sweep with its results.
+  os.MkdirAll ( test_path, 0755 )
+  if content, err := json.MarshalIndent ( s, "", "  " ); err == nil {
+    ioutil.WriteFile ( test_path + "/sweep.json", append ( content, '\n' ), 0644 )
+  }
+
+  sweep_results, err := s.Execute ( t,
+                                    test_path,
+                                    func ( t * rn.Topology, run_name string ) ( error ) {
+                                      err := run_topology ( t, & o, test_path, run_name )
+                                      if err != nil {
+                                        fp ( os.Stdout, "run %s failed: %s\n", run_name, err.Error() )
+                                      }
+                                      return err
+                                    },
+                                    pause,
+                                    o.warm_up,
+                                    o.cool_down )
+  if err != nil {
+    ume ( "mercury sweep: %s", err.Error() )
+  }
+
+  failures := 0
+  for _, result := range sweep_results {
+    if result.Error != "" {
+      failures ++
+    }
+  }
+
+  fp ( os.Stdout, "\n" )
+  s.Print_table ( os.Stdout, sweep_results )
+  fp ( os.Stdout, "\n" )
+
+  o.write_report ( test_path )
+
+  fp ( os.Stdout, "Test %s done at %s : %d of %d runs failed.\n",
+       test_path,
+       time.Now().Format ( "2006_01_02_1504" ),
+       failures,
+       len(runs) )
+
+  if failures > 0 || err != nil {
+    return 1
+  }
+  return 0
+}
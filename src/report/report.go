@@ -0,0 +1,454 @@
+package report
+
+import ( "bufio"
+         "bytes"
+         "fmt"
+         "html"
+         "os"
+         "path/filepath"
+         "sort"
+         "strings"
+         "time"
+
+         "chart"
+         "results"
+         rn "router_network"
+         "summary"
+       )
+
+
+var fp = fmt.Fprintf
+
+
+
+
+
+/*===================================================================
+
+  A single HTML page that describes every run of a test:
+  what was run, how it went, its latency statistics and charts,
+  and links to the files that explain it. The charts are drawn
+  into the page, so the page can be mailed around by itself,
+  though the links only work next to the test directory.
+
+===================================================================*/
+
+// A timeline with more points than this is thinned out,
+// so that the page stays a reasonable size.
+const max_timeline_points = 5000
+
+// Beyond this many routers, addresses, or clients,
+// the tables only sum them up.
+const max_rate_rows = 50
+
+const style = `
+body   { font-family: sans-serif; margin: 2em; color: #222; }
+h1, h2 { border-bottom: 1px solid #ccc; padding-bottom: 0.2em; }
+table  { border-collapse: collapse; margin: 0.5em 0 1.5em 0; }
+th, td { border: 1px solid #ccc; padding: 0.2em 0.6em; text-align: left; }
+td.num { text-align: right; font-family: monospace; }
+.success { color: #2ca02c; }
+.failure { color: #d62728; }
+.chart svg { max-width: 100%; height: auto; }
+`
+
+
+
+
+
+/*
+  Write test_path/report.html, describing every run under test_path.
+  Messages that arrive in the first warm_up or last cool_down of
+  each run are left out of its statistics and charts.
+  Returns the name of the report file.
+*/
+func Write ( test_path string, warm_up, cool_down time.Duration ) ( string, error ) {
+  var runs [] * run
+  for _, path := range Find_runs ( test_path ) {
+    runs = append ( runs, load_run ( path, warm_up, cool_down ) )
+  }
+  if len ( runs ) == 0 {
+    return "", fmt.Errorf ( "no runs in |%s|", test_path )
+  }
+
+  // A single run directory gets its report inside itself.
+  report_dir := test_path
+  if Is_run_dir ( test_path ) {
+    report_dir = runs[0].path
+  }
+
+  file_name := report_dir + "/report.html"
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return "", err
+  }
+
+  w := bufio.NewWriter ( f )
+  write_report ( w, filepath.Base ( test_path ), report_dir, runs, warm_up, cool_down )
+
+  if err = w.Flush ( ); err != nil {
+    f.Close ( )
+    return "", err
+  }
+  return file_name, f.Close ( )
+}
+
+
+
+
+
+func esc ( s string ) ( string ) {
+  return html.EscapeString ( s )
+}
+
+
+
+
+
+func write_report ( w           * bufio.Writer,
+                    test_name     string,
+                    report_dir    string,
+                    runs       [] * run,
+                    warm_up       time.Duration,
+                    cool_down     time.Duration ) {
+  fp ( w, "<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n" )
+  fp ( w, "<title>Mercury : %s</title>\n", esc ( test_name ) )
+  fp ( w, "<style>%s</style>\n</head>\n<body>\n", style )
+
+  n_failed := 0
+  for _, r := range runs {
+    if ! strings.HasPrefix ( r.outcome, "success" ) {
+      n_failed ++
+    }
+  }
+
+  fp ( w, "<h1>%s</h1>\n", esc ( test_name ) )
+  fp ( w, "<p>Report made %s. %d runs, %d failed. ", time.Now().Format ( "2006-01-02 15:04:05" ), len(runs), n_failed )
+  fp ( w, "Statistics leave out the first %v and the last %v of each run.</p>\n", warm_up, cool_down )
+
+  write_summary_table ( w, runs )
+
+  if len ( runs ) > 1 {
+    var xs    [] float64
+    var stats [] results.Stats
+    for i, r := range runs {
+      xs    = append ( xs,    Run_x ( r.name, i ) )
+      stats = append ( stats, r.stats )
+    }
+    write_chart ( w, chart.Latency_by ( xs, stats, "run", "Latency -- " + test_name ) )
+
+    var achieved, offered [] float64
+    for _, r := range runs {
+      achieved = append ( achieved, r.throughput.Median )
+      offered  = append ( offered,  r.throughput.Offered )
+    }
+    write_chart ( w, chart.Throughput_by ( xs, achieved, offered, "run", "Throughput -- " + test_name ) )
+  }
+
+  for _, r := range runs {
+    write_run ( w, r, report_dir )
+  }
+
+  fp ( w, "</body>\n</html>\n" )
+}
+
+
+
+
+
+func outcome_class ( outcome string ) ( string ) {
+  if strings.HasPrefix ( outcome, "success" ) {
+    return "success"
+  }
+  return "failure"
+}
+
+
+
+
+
+func write_stats_header ( w * bufio.Writer ) {
+  fp ( w, "<th>messages</th><th>min</th><th>mean</th><th>stddev</th><th>max</th>" )
+  for _, p := range results.Standard_percentiles {
+    fp ( w, "<th>%s</th>", results.Percentile_name ( p ) )
+  }
+}
+
+
+
+
+
+func write_stats_cells ( w * bufio.Writer, r * run ) {
+  if r.problem != "" {
+    fp ( w, "<td colspan=\"%d\">%s</td>", 5 + len(results.Standard_percentiles), esc ( r.problem ) )
+    return
+  }
+  s := r.stats
+  fp ( w, "<td class=\"num\">%d</td>", s.Count )
+  for _, value := range append ( [] float64 { s.Min, s.Mean, s.Stddev, s.Max }, s.Percentiles ... ) {
+    fp ( w, "<td class=\"num\">%.3f</td>", value )
+  }
+}
+
+
+
+
+
+func write_summary_table ( w * bufio.Writer, runs [] * run ) {
+  fp ( w, "<h2>Summary</h2>\n" )
+  fp ( w, "<p>Latencies are in milliseconds, throughput in messages per second.</p>\n" )
+  fp ( w, "<table>\n<tr><th>run</th><th>result</th>" )
+  write_stats_header ( w )
+  fp ( w, "<th>throughput</th><th>offered</th></tr>\n" )
+
+  for _, r := range runs {
+    fp ( w, "<tr><td><a href=\"#%s\">%s</a></td>", esc ( r.name ), esc ( r.name ) )
+    fp ( w, "<td class=\"%s\">%s</td>", outcome_class ( r.outcome ), esc ( r.outcome ) )
+    write_stats_cells ( w, r )
+    if r.problem == "" {
+      fp ( w, "<td class=\"num\">%.1f</td><td class=\"num\">%s</td>", r.throughput.Median, offered ( r.throughput ) )
+    } else {
+      fp ( w, "<td></td><td></td>" )
+    }
+    fp ( w, "</tr>\n" )
+  }
+  fp ( w, "</table>\n" )
+}
+
+
+
+
+
+func write_chart ( w * bufio.Writer, c * chart.Chart ) {
+  var svg bytes.Buffer
+  if err := c.Render_svg ( & svg ); err != nil {
+    fp ( w, "<p>No chart: %s</p>\n", esc ( err.Error() ) )
+    return
+  }
+  fp ( w, "<div class=\"chart\">\n%s</div>\n", svg.String() )
+}
+
+
+
+
+
+/*
+  Keep every nth message, so that no more than max_points remain.
+*/
+func thin ( r * results.Results, max_points int ) ( * results.Results ) {
+  if len ( r.Messages ) <= max_points {
+    return r
+  }
+  step    := ( len(r.Messages) + max_points - 1 ) / max_points
+  thinned := & results.Results { Start_time : r.Start_time,
+                                 Receivers  : r.Receivers }
+  for i := 0; i < len(r.Messages); i += step {
+    thinned.Messages = append ( thinned.Messages, r.Messages[i] )
+  }
+  return thinned
+}
+
+
+
+
+
+func offered ( th summary.Throughput ) ( string ) {
+  if th.Offered <= 0 {
+    return "-"
+  }
+  s := fmt.Sprintf ( "%.1f", th.Offered )
+  if th.Saturated ( ) {
+    s += " (saturated)"
+  }
+  return s
+}
+
+
+
+
+
+func write_rates ( w * bufio.Writer, label string, rates map [ string ] float64 ) {
+  if len ( rates ) < 2 {
+    return
+  }
+  var names [] string
+  for name := range rates {
+    names = append ( names, name )
+  }
+  sort.Strings ( names )
+
+  // Thousands of client pairs would make a table no one reads.
+  if len ( names ) > max_rate_rows {
+    var values [] float64
+    for _, rate := range rates {
+      values = append ( values, rate )
+    }
+    sort.Float64s ( values )
+    fp ( w, "<p>By %s, over %d of them : least %.1f, median %.1f, greatest %.1f msg/sec.</p>\n",
+         esc ( label ), len(values), values[0], results.Percentile ( values, 50 ), values[len(values)-1] )
+    return
+  }
+
+  fp ( w, "<table>\n<tr><th>%s</th><th>msg/sec</th></tr>\n", esc ( label ) )
+  for _, name := range names {
+    fp ( w, "<tr><td>%s</td><td class=\"num\">%.1f</td></tr>\n", esc ( name ), rates [ name ] )
+  }
+  fp ( w, "</table>\n" )
+}
+
+
+
+
+
+/*
+  Throughput over the sliding window, and how it was shared out
+  among the routers and addresses. The receivers are left out,
+  since with client pairs they are the same as the addresses.
+*/
+func write_throughput ( w * bufio.Writer, r * run ) {
+  th := r.throughput
+  fp ( w, "<h3>Throughput (msg/sec, over %gs windows)</h3>\n<table>\n", th.Window )
+  fp ( w, "<tr><th>overall</th><th>median</th><th>min</th><th>max</th><th>offered</th></tr>\n" )
+  fp ( w, "<tr><td class=\"num\">%.1f</td><td class=\"num\">%.1f</td><td class=\"num\">%.1f</td><td class=\"num\">%.1f</td><td class=\"num\">%s</td></tr>\n",
+       th.Overall, th.Median, th.Min, th.Max, offered ( th ) )
+  fp ( w, "</table>\n" )
+
+  window := time.Duration ( th.Window * float64(time.Second) )
+  write_chart ( w, chart.Throughput ( r.results, window, th.Offered, "Throughput -- " + r.name ) )
+
+  write_rates ( w, "receiving router", th.By_router )
+  write_rates ( w, "address",          th.By_address )
+}
+
+
+
+
+
+/*
+  Clients are left out if there are too many of them to read.
+*/
+func write_processes ( w * bufio.Writer, processes [] process_peaks ) {
+  if len ( processes ) == 0 {
+    return
+  }
+  fp ( w, "<h3>Processes (greatest of all samples)</h3>\n<table>\n" )
+  fp ( w, "<tr><th>name</th><th>kind</th><th>RSS (KB)</th><th>CPU (%%)</th><th>threads</th><th>open fds</th><th>context switches</th></tr>\n" )
+  n_clients := 0
+  for _, p := range processes {
+    if p.kind == "client" {
+      n_clients ++
+      if n_clients > max_rate_rows {
+        continue
+      }
+    }
+    fp ( w, "<tr><td>%s</td><td>%s</td><td class=\"num\">%d</td><td class=\"num\">%.1f</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\">%d</td></tr>\n",
+         esc ( p.name ), esc ( p.kind ), p.rss_kb, p.cpu_percent, p.threads, p.fds, p.switches )
+  }
+  fp ( w, "</table>\n" )
+  if n_clients > max_rate_rows {
+    fp ( w, "<p>%d of %d clients shown.</p>\n", max_rate_rows, n_clients )
+  }
+}
+
+
+
+
+
+func write_run ( w * bufio.Writer, r * run, report_dir string ) {
+  fp ( w, "<h2 id=\"%s\">%s</h2>\n", esc ( r.name ), esc ( r.name ) )
+  fp ( w, "<p>Result: <span class=\"%s\">%s</span></p>\n", outcome_class ( r.outcome ), esc ( r.outcome ) )
+
+  if r.topology != nil {
+    write_topology ( w, r.topology )
+  } else {
+    fp ( w, "<p>No topology.json in this run.</p>\n" )
+  }
+
+  fp ( w, "<h3>Latency (msec)</h3>\n<table>\n<tr>" )
+  write_stats_header ( w )
+  fp ( w, "</tr>\n<tr>" )
+  write_stats_cells ( w, r )
+  fp ( w, "</tr>\n</table>\n" )
+
+  if r.problem == "" {
+    title := "Timeline -- " + r.name
+    thinned := thin ( r.results, max_timeline_points )
+    if len ( thinned.Messages ) < len ( r.results.Messages ) {
+      title += fmt.Sprintf ( " (%d of %d messages shown)", len(thinned.Messages), len(r.results.Messages) )
+    }
+    write_chart ( w, chart.Timeline  ( thinned,       title ) )
+    write_chart ( w, chart.Histogram ( r.results, 50, "Latency Histogram -- " + r.name ) )
+    write_throughput ( w, r )
+  }
+
+  if r.memory != nil {
+    write_chart ( w, r.memory )
+  }
+  if r.cpu != nil {
+    write_chart ( w, r.cpu )
+  }
+  write_processes ( w, r.processes )
+
+  files := r.files ( )
+  if len ( files ) > 0 {
+    fp ( w, "<h3>Files</h3>\n<ul>\n" )
+    for _, file := range files {
+      link, err := filepath.Rel ( report_dir, file )
+      if err != nil {
+        link = file
+      }
+      fp ( w, "<li><a href=\"%s\">%s</a></li>\n", esc ( link ), esc ( link ) )
+    }
+    fp ( w, "</ul>\n" )
+  }
+}
+
+
+
+
+
+func write_topology ( w * bufio.Writer, t * rn.Topology ) {
+  fp ( w, "<h3>Versions</h3>\n<table>\n<tr><th>name</th><th>proton</th><th>dispatch</th></tr>\n" )
+  for _, v := range t.Versions {
+    fp ( w, "<tr><td>%s</td><td>%s</td><td>%s</td></tr>\n", esc ( v.Name ), esc ( v.Proton_root ), esc ( v.Dispatch_root ) )
+  }
+  fp ( w, "</table>\n" )
+
+  routers, connectors, err := t.All_routers ( )
+  if err != nil {
+    fp ( w, "<p>Can't expand the topology: %s</p>\n", esc ( err.Error() ) )
+  } else {
+    fp ( w, "<h3>Routers</h3>\n<table>\n<tr><th>name</th><th>type</th><th>version</th><th>connects to</th></tr>\n" )
+    for _, r := range routers {
+      var to [] string
+      for _, c := range connectors {
+        if c.From == r.Name {
+          to = append ( to, c.To )
+        }
+      }
+      fp ( w, "<tr><td>%s</td><td>%s</td><td>%s</td><td>%s</td></tr>\n",
+           esc ( r.Name ), esc ( r.Type ), esc ( r.Version ), esc ( strings.Join ( to, " " ) ) )
+    }
+    fp ( w, "</table>\n" )
+  }
+
+  if len ( t.Client_pairs ) > 0 {
+    fp ( w, "<h3>Client pairs</h3>\n<table>\n" )
+    fp ( w, "<tr><th>pairs</th><th>senders on</th><th>receivers on</th><th>messages</th><th>length</th><th>throttle (msec)</th><th>delay</th><th>soak</th></tr>\n" )
+    for _, p := range t.Client_pairs {
+      fp ( w, "<tr><td class=\"num\">%d</td><td>%s</td><td>%s</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td>%t</td></tr>\n",
+           p.Count, esc ( p.Sender_router ), esc ( p.Receiver_router ), p.N_messages, p.Message_length, p.Throttle, p.Delay, p.Soak )
+    }
+    fp ( w, "</table>\n" )
+  }
+
+  if len ( t.Clients ) > 0 {
+    fp ( w, "<h3>Clients</h3>\n<table>\n" )
+    fp ( w, "<tr><th>name</th><th>operation</th><th>router</th><th>messages</th><th>length</th><th>throttle (msec)</th><th>addresses</th></tr>\n" )
+    for _, c := range t.Clients {
+      fp ( w, "<tr><td>%s</td><td>%s</td><td>%s</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td class=\"num\">%d</td><td>%s</td></tr>\n",
+           esc ( c.Name ), esc ( c.Operation ), esc ( c.Router ), c.N_messages, c.Message_length, c.Throttle, esc ( strings.Join ( c.Addresses, " " ) ) )
+    }
+    fp ( w, "</table>\n" )
+  }
+}
@@ -0,0 +1,337 @@
+package report
+
+import ( "io/ioutil"
+         "os"
+         "path/filepath"
+         "sort"
+         "strconv"
+         "strings"
+         "time"
+
+         "chart"
+         "results"
+         rn "router_network"
+         "summary"
+       )
+
+
+
+
+
+/*
+  Find the run directories under a test directory,
+  or accept a single run directory. They are in the
+  order of the numbers in their names -- see run_less()
+  -- so that a sweep's runs are in the order it ran them.
+*/
+func Find_runs ( dir string ) ( [] string ) {
+  if Is_run_dir ( dir ) {
+    return [] string { dir }
+  }
+
+  var runs [] string
+  entries, _ := filepath.Glob ( dir + "/*" )
+  for _, entry := range entries {
+    if Is_run_dir ( entry ) {
+      runs = append ( runs, entry )
+    }
+  }
+  sort.SliceStable ( runs, func ( i, j int ) bool { return run_less ( runs[i], runs[j] ) } )
+  return runs
+}
+
+
+
+
+
+/*
+  Compare run names piece by piece, where a piece is a number
+  or the text between numbers, and numbers compare as numbers:
+  n_routers_2__n_client_pairs_500 comes before n_routers_10__...
+*/
+func run_less ( a, b string ) ( bool ) {
+  a_pieces, b_pieces := name_pieces ( a ), name_pieces ( b )
+  for i := 0; i < len ( a_pieces ) && i < len ( b_pieces ); i ++ {
+    x, y := a_pieces[i], b_pieces[i]
+    if x == y {
+      continue
+    }
+    if is_digit ( x[0] ) && is_digit ( y[0] ) {
+      // Without leading zeros, the longer number is the bigger one.
+      x_trimmed, y_trimmed := strings.TrimLeft ( x, "0" ), strings.TrimLeft ( y, "0" )
+      if len ( x_trimmed ) != len ( y_trimmed ) {
+        return len ( x_trimmed ) < len ( y_trimmed )
+      }
+      if x_trimmed != y_trimmed {
+        return x_trimmed < y_trimmed
+      }
+    }
+    return x < y
+  }
+  return len ( a_pieces ) < len ( b_pieces )
+}
+
+
+
+
+
+func is_digit ( c byte ) ( bool ) {
+  return c >= '0' && c <= '9'
+}
+
+
+
+
+
+func name_pieces ( name string ) ( [] string ) {
+  var pieces [] string
+  start := 0
+  for i := 1; i <= len ( name ); i ++ {
+    if i == len ( name ) || is_digit ( name[i] ) != is_digit ( name[i-1] ) {
+      pieces = append ( pieces, name [ start : i ] )
+      start = i
+    }
+  }
+  return pieces
+}
+
+
+
+
+
+// A run directory is one that has a result subdirectory.
+func Is_run_dir ( dir string ) ( bool ) {
+  info, err := os.Stat ( dir + "/result" )
+  return err == nil && info.IsDir()
+}
+
+
+
+
+
+/*
+  What to plot a run against in a test-wide chart: the number
+  at the end of the run's name, as in "n_client_pairs_50",
+  or failing that the run's position in the test.
+*/
+func Run_x ( run string, index int ) ( float64 ) {
+  name := filepath.Base ( run )
+  if underscore := strings.LastIndex ( name, "_" ); underscore >= 0 {
+    if x, err := strconv.ParseFloat ( name[underscore+1:], 64 ); err == nil {
+      return x
+    }
+  }
+  return float64 ( index )
+}
+
+
+
+
+
+/*
+  Everything the report knows about one run.
+*/
+type run struct {
+  name             string
+  path             string
+
+  topology       * rn.Topology
+  outcome          string      // what the result file says
+
+  results        * results.Results
+  stats            results.Stats
+  throughput       summary.Throughput
+  problem          string      // why there are no stats, if there are not
+
+  memory         * chart.Chart
+  cpu            * chart.Chart
+  processes     [] process_peaks
+}
+
+
+
+
+
+func load_run ( path string, warm_up, cool_down time.Duration ) ( * run ) {
+  r := & run { name : filepath.Base ( path ),
+               path : path }
+
+  // The topology is saved in the run directory before the run starts.
+  if t, err := rn.Read_topology_file ( path + "/topology.json" ); err == nil {
+    r.topology = t
+  }
+
+  if content, err := ioutil.ReadFile ( path + "/result/result" ); err == nil {
+    r.outcome = strings.TrimSpace ( string(content) )
+  } else {
+    r.outcome = "unknown : no result file"
+  }
+
+  loaded, err := results.Load ( path + "/result" )
+  if err != nil {
+    r.problem = err.Error()
+  } else {
+    r.results = loaded.Trim ( warm_up, cool_down )
+    r.stats      = r.results.Stats ( )
+    r.throughput = summary.Measure_throughput ( r.results, r.topology, results.Default_throughput_window )
+    if r.stats.Count == 0 {
+      r.problem = "no messages"
+    }
+  }
+
+  r.memory = read_router_memory ( path + "/result", r.name )
+  r.read_process_metrics ( path + "/result" )
+  return r
+}
+
+
+
+
+
+/*
+  Make a chart of router memory from the samples that
+  the network took while it ran, if there are any.
+*/
+func read_router_memory ( results_path, run_name string ) ( * chart.Chart ) {
+  samples, err := results.Load_router_memory ( results_path )
+  if err != nil || len ( samples ) == 0 {
+    return nil
+  }
+
+  c := chart.New_chart ( "Router Memory -- " + run_name, "time (sec)", "RSS (MB)" )
+  series := make ( map [ string ] * chart.Series )
+
+  for _, sample := range samples {
+    s, present := series [ sample.Router ]
+    if ! present {
+      s = c.Add_series ( sample.Router, chart.Lines )
+      series [ sample.Router ] = s
+    }
+    s.Add ( sample.Seconds, float64(sample.Rss_kb) / 1024 )
+  }
+  return c
+}
+
+
+
+
+
+/*
+  The most that each process used of each resource,
+  over all its samples.
+*/
+type process_peaks struct {
+  kind                   string
+  name                   string
+  rss_kb                 int
+  cpu_percent            float64
+  threads                int
+  fds                    int
+  switches               int   // voluntary and involuntary, in the last sample
+}
+
+
+
+
+
+/*
+  Chart the routers' CPU use, and find the peaks of every
+  process, from the samples that the network took.
+*/
+func ( r * run ) read_process_metrics ( results_path string ) {
+  samples, err := results.Load_process_metrics ( results_path )
+  if err != nil || len ( samples ) == 0 {
+    return
+  }
+
+  r.cpu = chart.New_chart ( "Router CPU -- " + r.name, "time (sec)", "CPU (% of one core)" )
+  series := make ( map [ string ] * chart.Series )
+  peaks  := make ( map [ string ] * process_peaks )
+  var names [] string
+
+  for _, s := range samples {
+    if s.Kind == "router" && s.Cpu_percent >= 0 {
+      line, present := series [ s.Name ]
+      if ! present {
+        line = r.cpu.Add_series ( s.Name, chart.Lines )
+        series [ s.Name ] = line
+      }
+      line.Add ( s.Seconds, s.Cpu_percent )
+    }
+
+    p, present := peaks [ s.Name ]
+    if ! present {
+      p = & process_peaks { kind : s.Kind, name : s.Name }
+      peaks [ s.Name ] = p
+      names = append ( names, s.Name )
+    }
+    if s.Rss_kb > p.rss_kb {
+      p.rss_kb = s.Rss_kb
+    }
+    if s.Cpu_percent > p.cpu_percent {
+      p.cpu_percent = s.Cpu_percent
+    }
+    if s.Threads > p.threads {
+      p.threads = s.Threads
+    }
+    if s.Fds > p.fds {
+      p.fds = s.Fds
+    }
+    p.switches = s.Voluntary_switches + s.Involuntary_switches
+  }
+
+  if len ( series ) == 0 {
+    r.cpu = nil
+  }
+
+  // Routers first, each kind in name order.
+  sort.Slice ( names, func ( i, j int ) bool {
+                        a, b := peaks [ names[i] ], peaks [ names[j] ]
+                        if a.kind != b.kind {
+                          return a.kind > b.kind
+                        }
+                        return a.name < b.name
+                      } )
+  for _, name := range names {
+    r.processes = append ( r.processes, * peaks [ name ] )
+  }
+}
+
+
+
+
+
+/*
+  The files in the run directory that someone looking
+  into a problem will want: logs, and the saved command
+  lines and environments that reproduce each process.
+*/
+func ( r * run ) files ( ) ( [] string ) {
+  var files [] string
+  filepath.Walk ( r.path,
+                  func ( path string, info os.FileInfo, err error ) error {
+                    if err != nil || info.IsDir() {
+                      return nil
+                    }
+                    name := info.Name()
+                    if strings.HasSuffix ( name, ".log" )                   ||
+                       strings.HasSuffix ( name, "command_line" )           ||
+                       strings.HasSuffix ( name, "environment_variables" )  ||
+                       strings.HasSuffix ( name, ".conf" )                  ||
+                       strings.HasSuffix ( name, ".stdout" )                ||
+                       strings.HasSuffix ( name, ".stderr" )                ||
+                       name == "topology.json"                              ||
+                       name == "summary.json"                               ||
+                       name == "manifest.json"                              ||
+                       name == "process_metrics"                            ||
+                       name == "process_exits"                              ||
+                       name == "router_halts"                               ||
+                       name == "pids"                                       ||
+                       name == "result" {
+                      files = append ( files, path )
+                    }
+                    return nil
+                  } )
+  sort.Strings ( files )
+  return files
+}
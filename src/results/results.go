@@ -0,0 +1,609 @@
+package results
+
+import ( "bufio"
+         "fmt"
+         "math"
+         "os"
+         "path/filepath"
+         "sort"
+         "strconv"
+         "strings"
+         "time"
+       )
+
+
+var fp = fmt.Fprintf
+
+
+
+
+
+/*===================================================================
+
+  The results of one run: every message that any receiver got,
+  read from the receivers' flight times files.
+
+  Each line of a flight times file is
+    <arrival_time> <latency>
+  where arrival_time is a timestamp in seconds and latency
+  is in milliseconds.
+
+===================================================================*/
+
+type Message struct {
+  Arrival_time   float64   // seconds since the first arrival in the run
+  Latency        float64   // msec
+  Receiver       int       // index into Results.Receivers
+}
+
+
+
+
+
+type Results struct {
+  // Sorted by arrival time.
+  Messages     [] Message
+
+  // The timestamp of the first arrival, before the arrival
+  // times were made relative to it.
+  Start_time      float64
+
+  // The names of the receivers, from their flight times files.
+  Receivers    [] string
+}
+
+
+
+
+
+/*
+  Summary statistics for a set of latencies.
+  Percentiles holds one value for each percentile asked for,
+  in the same order as Percentile_points.
+*/
+type Stats struct {
+  Count                 int
+  Min                   float64
+  Max                   float64
+  Mean                  float64
+  Stddev                float64
+  Percentile_points  [] float64
+  Percentiles        [] float64
+}
+
+
+
+
+
+/*
+  The percentiles that drivers report unless they ask for others.
+*/
+var Standard_percentiles = [] float64 { 50, 90, 99, 99.9 }
+
+
+
+
+
+/*
+  Find all the flight times files under results_path.
+*/
+func Flight_times_files ( results_path string ) ( [] string, error ) {
+  var file_names [] string
+  err := filepath.Walk ( results_path,
+                         func ( path string, info os.FileInfo, err error ) error {
+                           if err != nil {
+                             return err
+                           }
+                           if ! info.IsDir() && strings.HasSuffix ( path, "_flight_times" ) {
+                             file_names = append ( file_names, path )
+                           }
+                           return nil
+                         } )
+  sort.Strings ( file_names )
+  return file_names, err
+}
+
+
+
+
+
+/*
+  Load every flight times file under results_path.
+*/
+func Load ( results_path string ) ( * Results, error ) {
+  file_names, err := Flight_times_files ( results_path )
+  if err != nil {
+    return nil, err
+  }
+  return Load_files ( file_names )
+}
+
+
+
+
+
+/*
+  Load the given flight times files into one set of results,
+  sorted by arrival time, with arrival times made relative
+  to the first arrival.
+*/
+func Load_files ( file_names [] string ) ( * Results, error ) {
+  r := & Results { }
+
+  for _, file_name := range file_names {
+    if err := r.read_file ( file_name ); err != nil {
+      return nil, err
+    }
+  }
+
+  sort.Slice ( r.Messages, func ( i, j int ) bool { return r.Messages[i].Arrival_time < r.Messages[j].Arrival_time } )
+
+  if len ( r.Messages ) > 0 {
+    r.Start_time = r.Messages[0].Arrival_time
+    for i := range r.Messages {
+      r.Messages[i].Arrival_time -= r.Start_time
+    }
+  }
+
+  return r, nil
+}
+
+
+
+
+
+func ( r * Results ) read_file ( file_name string ) ( error ) {
+  f, err := os.Open ( file_name )
+  if err != nil {
+    return err
+  }
+  defer f.Close ( )
+
+  // The client names its file <name>_flight_times .
+  receiver := len ( r.Receivers )
+  r.Receivers = append ( r.Receivers, strings.TrimSuffix ( filepath.Base ( file_name ), "_flight_times" ) )
+
+  line_number := 0
+  scanner     := bufio.NewScanner ( f )
+  for scanner.Scan ( ) {
+    line_number ++
+    fields := strings.Fields ( scanner.Text() )
+    if len ( fields ) == 0 {
+      continue
+    }
+    if len ( fields ) != 2 {
+      return fmt.Errorf ( "%s:%d : expected 2 numbers, got |%s|", file_name, line_number, scanner.Text() )
+    }
+
+    m := Message { Receiver : receiver }
+    if m.Arrival_time, err = strconv.ParseFloat ( fields[0], 64 ); err != nil {
+      return fmt.Errorf ( "%s:%d : %s", file_name, line_number, err.Error() )
+    }
+    if m.Latency, err = strconv.ParseFloat ( fields[1], 64 ); err != nil {
+      return fmt.Errorf ( "%s:%d : %s", file_name, line_number, err.Error() )
+    }
+    r.Messages = append ( r.Messages, m )
+  }
+
+  return scanner.Err ( )
+}
+
+
+
+
+
+/*
+  The arrival time of the last message. For results as they
+  were loaded, that is how long the run lasted from first
+  arrival to last; for trimmed or grouped results, which keep
+  the whole run's times, it is not.
+*/
+func ( r * Results ) Duration ( ) ( float64 ) {
+  if len ( r.Messages ) == 0 {
+    return 0
+  }
+  return r.Messages[len(r.Messages)-1].Arrival_time
+}
+
+
+
+
+
+/*
+  Return a copy of these results without the messages that
+  arrived during the first warm_up or the last cool_down of
+  the run. Those are the times when clients are still attaching
+  or have started to leave, so their latencies are not typical.
+  Arrival times in the copy are still relative to the start
+  of the whole run.
+  If the run is too short for anything to be left, it is not
+  trimmed at all, and that is said on stderr.
+*/
+func ( r * Results ) Trim ( warm_up, cool_down time.Duration ) ( * Results ) {
+  trimmed := & Results { Start_time : r.Start_time,
+                         Receivers  : r.Receivers }
+
+  end   := r.Duration ( ) - cool_down.Seconds()
+  start := warm_up.Seconds()
+
+  if len ( r.Messages ) > 0 && ( warm_up > 0 || cool_down > 0 ) && start >= end {
+    fp ( os.Stderr, "results: warm-up %v and cool-down %v cover the whole %.3f second run : not trimming it.\n",
+         warm_up, cool_down, r.Duration() )
+    trimmed.Messages = append ( trimmed.Messages, r.Messages ... )
+    return trimmed
+  }
+
+  for _, m := range r.Messages {
+    if m.Arrival_time >= start && m.Arrival_time <= end {
+      trimmed.Messages = append ( trimmed.Messages, m )
+    }
+  }
+
+  return trimmed
+}
+
+
+
+
+
+/*
+  Split these results into groups of receivers. group gives the
+  name of the group that a receiver belongs to, for instance its
+  address or its router. Receivers that it gives "" for are left
+  out. Each group keeps all the receiver names, and the start time.
+*/
+func ( r * Results ) Group ( group func ( receiver string ) string ) ( map [ string ] * Results ) {
+  names  := make ( [] string, len(r.Receivers) )
+  for i, receiver := range r.Receivers {
+    names [ i ] = group ( receiver )
+  }
+
+  groups := make ( map [ string ] * Results )
+  for _, m := range r.Messages {
+    name := names [ m.Receiver ]
+    if name == "" {
+      continue
+    }
+    g, present := groups [ name ]
+    if ! present {
+      g = & Results { Start_time : r.Start_time,
+                      Receivers  : r.Receivers }
+      groups [ name ] = g
+    }
+    g.Messages = append ( g.Messages, m )
+  }
+  return groups
+}
+
+
+
+
+
+/*
+  All the latencies, sorted from least to greatest.
+*/
+func ( r * Results ) Sorted_latencies ( ) ( [] float64 ) {
+  latencies := make ( [] float64, len(r.Messages) )
+  for i, m := range r.Messages {
+    latencies [ i ] = m.Latency
+  }
+  sort.Float64s ( latencies )
+  return latencies
+}
+
+
+
+
+
+/*
+  Compute the statistics for these results. If no percentiles
+  are given, the Standard_percentiles are used.
+*/
+func ( r * Results ) Stats ( percentiles ... float64 ) ( Stats ) {
+  return Compute_stats ( r.Sorted_latencies(), percentiles ... )
+}
+
+
+
+
+
+/*
+  Compute statistics over latencies that are already sorted.
+  Stddev is the sample standard deviation.
+*/
+func Compute_stats ( sorted [] float64, percentiles ... float64 ) ( Stats ) {
+  if len ( percentiles ) == 0 {
+    percentiles = Standard_percentiles
+  }
+
+  s := Stats { Count             : len(sorted),
+               Percentile_points : percentiles,
+               Percentiles       : make ( [] float64, len(percentiles) ) }
+
+  if s.Count == 0 {
+    return s
+  }
+
+  s.Min = sorted [ 0 ]
+  s.Max = sorted [ s.Count - 1 ]
+
+  sum := 0.0
+  for _, x := range sorted {
+    sum += x
+  }
+  s.Mean = sum / float64(s.Count)
+
+  if s.Count > 1 {
+    sum_of_squares := 0.0
+    for _, x := range sorted {
+      sum_of_squares += ( x - s.Mean ) * ( x - s.Mean )
+    }
+    s.Stddev = math.Sqrt ( sum_of_squares / float64(s.Count - 1) )
+  }
+
+  for i, p := range percentiles {
+    s.Percentiles [ i ] = Percentile ( sorted, p )
+  }
+
+  return s
+}
+
+
+
+
+
+/*
+  The nearest-rank percentile of an already-sorted slice:
+  the smallest value that is greater than or equal to
+  p percent of the values. p is from 0 to 100.
+*/
+func Percentile ( sorted [] float64, p float64 ) ( float64 ) {
+  n := len ( sorted )
+  if n == 0 {
+    return math.NaN()
+  }
+
+  rank := int ( math.Ceil ( p / 100 * float64(n) ) )
+  if rank < 1 {
+    rank = 1
+  }
+  if rank > n {
+    rank = n
+  }
+  return sorted [ rank - 1 ]
+}
+
+
+
+
+
+/*
+  Get the value of one percentile from these stats.
+  The second return value is false if it was not computed.
+*/
+func ( s * Stats ) Percentile ( p float64 ) ( float64, bool ) {
+  for i, point := range s.Percentile_points {
+    if point == p {
+      return s.Percentiles [ i ], true
+    }
+  }
+  return 0, false
+}
+
+
+
+
+
+/*
+  A name for a percentile that works as a column header
+  or a file name: 99.9 becomes "p99.9".
+*/
+func Percentile_name ( p float64 ) ( string ) {
+  return "p" + strconv.FormatFloat ( p, 'f', -1, 64 )
+}
+
+
+
+
+
+/*
+  Write the messages as "<arrival_time> <latency>" lines,
+  suitable for plotting.
+*/
+func ( r * Results ) Write_timeline ( file_name string ) ( error ) {
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+
+  w := bufio.NewWriter ( f )
+  for _, m := range r.Messages {
+    fp ( w, "%.6f %.6f\n", m.Arrival_time, m.Latency )
+  }
+
+  if err = w.Flush ( ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
+
+
+
+
+
+/*
+  One sample of one process, as the network records them in
+  results_path/process_metrics . See utils.Sample_processes .
+*/
+type Process_sample struct {
+  Seconds                float64   // since the network started
+  Kind                   string    // "router" or "client"
+  Name                   string
+  Pid                    int
+  Rss_kb                 int
+  Vsz_kb                 int
+  User_sec               float64
+  Sys_sec                float64
+  Cpu_percent            float64   // -1 for a process's first sample
+  Threads                int
+  Fds                    int
+  Voluntary_switches     int
+  Involuntary_switches   int
+  Read_bytes             int64     // -1 if not readable
+  Write_bytes            int64
+}
+
+
+
+
+
+/*
+  Load the process samples from results_path.
+  A run without any gives no samples and no error.
+*/
+func Load_process_metrics ( results_path string ) ( [] Process_sample, error ) {
+  f, err := os.Open ( results_path + "/process_metrics" )
+  if err != nil {
+    if os.IsNotExist ( err ) {
+      return nil, nil
+    }
+    return nil, err
+  }
+  defer f.Close ( )
+
+  var samples [] Process_sample
+  scanner := bufio.NewScanner ( f )
+  for scanner.Scan ( ) {
+    line := scanner.Text ( )
+    if strings.HasPrefix ( line, "#" ) {
+      continue
+    }
+    var s Process_sample
+    _, err := fmt.Sscanf ( line, "%f %s %s %d %d %d %f %f %f %d %d %d %d %d %d",
+                           & s.Seconds, & s.Kind, & s.Name, & s.Pid,
+                           & s.Rss_kb, & s.Vsz_kb,
+                           & s.User_sec, & s.Sys_sec, & s.Cpu_percent,
+                           & s.Threads, & s.Fds,
+                           & s.Voluntary_switches, & s.Involuntary_switches,
+                           & s.Read_bytes, & s.Write_bytes )
+    if err != nil {
+      continue
+    }
+    samples = append ( samples, s )
+  }
+  return samples, scanner.Err ( )
+}
+
+
+
+
+
+/*
+  One sample of a router's memory use.
+*/
+type Memory_sample struct {
+  Seconds        float64   // since the network started
+  Router         string
+  Rss_kb         int
+}
+
+
+
+
+
+/*
+  Load the router memory samples from results_path: from the
+  process metrics, or from the router_memory file that older
+  runs have instead. A run without any gives no samples and
+  no error.
+*/
+func Load_router_memory ( results_path string ) ( [] Memory_sample, error ) {
+  processes, err := Load_process_metrics ( results_path )
+  if err != nil {
+    return nil, err
+  }
+  if processes != nil {
+    var samples [] Memory_sample
+    for _, p := range processes {
+      if p.Kind == "router" {
+        samples = append ( samples, Memory_sample { p.Seconds, p.Name, p.Rss_kb } )
+      }
+    }
+    return samples, nil
+  }
+
+  // Each line of router_memory is
+  //   <seconds> <router> <rss_kb>
+  f, err := os.Open ( results_path + "/router_memory" )
+  if err != nil {
+    if os.IsNotExist ( err ) {
+      return nil, nil
+    }
+    return nil, err
+  }
+  defer f.Close ( )
+
+  var samples [] Memory_sample
+  scanner := bufio.NewScanner ( f )
+  for scanner.Scan ( ) {
+    var sample Memory_sample
+    if _, err := fmt.Sscanf ( scanner.Text(), "%f %s %d", & sample.Seconds, & sample.Router, & sample.Rss_kb ); err != nil {
+      continue
+    }
+    samples = append ( samples, sample )
+  }
+  return samples, scanner.Err ( )
+}
+
+
+
+
+
+/*
+  The greatest memory use of each router.
+*/
+func Peak_memory ( samples [] Memory_sample ) ( map [ string ] int ) {
+  peaks := make ( map [ string ] int )
+  for _, sample := range samples {
+    if sample.Rss_kb > peaks [ sample.Router ] {
+      peaks [ sample.Router ] = sample.Rss_kb
+    }
+  }
+  return peaks
+}
+
+
+
+
+
+/*
+  Load how long each client's connection took to open, in msec,
+  from results_path/connection_setup , sorted. A run without
+  that file gives no times and no error.
+*/
+func Load_connection_setup ( results_path string ) ( [] float64, error ) {
+  // Each line is
+  //   <client> <msec>
+  f, err := os.Open ( results_path + "/connection_setup" )
+  if err != nil {
+    if os.IsNotExist ( err ) {
+      return nil, nil
+    }
+    return nil, err
+  }
+  defer f.Close ( )
+
+  var times [] float64
+  scanner := bufio.NewScanner ( f )
+  for scanner.Scan ( ) {
+    var name string
+    var msec float64
+    if _, err := fmt.Sscanf ( scanner.Text(), "%s %f", & name, & msec ); err != nil {
+      continue
+    }
+    times = append ( times, msec )
+  }
+  sort.Float64s ( times )
+  return times, scanner.Err ( )
+}
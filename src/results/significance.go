@@ -0,0 +1,159 @@
+package results
+
+import ( "math"
+         "math/rand"
+         "sort"
+       )
+
+
+
+
+
+/*
+  The Mann-Whitney U test: do the values in b tend to be
+  larger, or smaller, than those in a? This makes no
+  assumption about the shape of the distributions, which
+  matters for latencies, since they have long tails.
+
+  Uses the normal approximation, with a correction for ties,
+  which is good for the sample sizes that runs produce.
+  z is positive if b tends to be larger than a.
+  p is the two-sided p-value.
+*/
+func Mann_whitney ( a, b [] float64 ) ( u, z, p float64 ) {
+  n1, n2 := float64(len(a)), float64(len(b))
+  if n1 == 0 || n2 == 0 {
+    return 0, 0, 1
+  }
+
+  type value struct {
+    x       float64
+    from_b  bool
+  }
+  all := make ( [] value, 0, len(a) + len(b) )
+  for _, x := range a {
+    all = append ( all, value { x, false } )
+  }
+  for _, x := range b {
+    all = append ( all, value { x, true } )
+  }
+  sort.Slice ( all, func ( i, j int ) bool { return all[i].x < all[j].x } )
+
+  // Tied values all get the average of their ranks.
+  n           := n1 + n2
+  rank_sum_b  := 0.0
+  tie_term    := 0.0
+  for i := 0; i < len(all); {
+    j := i
+    for j < len(all) && all[j].x == all[i].x {
+      j ++
+    }
+    rank := float64 ( i + j + 1 ) / 2
+    for k := i; k < j; k ++ {
+      if all[k].from_b {
+        rank_sum_b += rank
+      }
+    }
+    t := float64 ( j - i )
+    tie_term += t * t * t - t
+    i = j
+  }
+
+  u = rank_sum_b - n2 * ( n2 + 1 ) / 2
+  mean  := n1 * n2 / 2
+  sigma := math.Sqrt ( n1 * n2 / 12 * ( ( n + 1 ) - tie_term / ( n * ( n - 1 ) ) ) )
+  if sigma == 0 {
+    return u, 0, 1
+  }
+
+  // Continuity correction, toward the mean.
+  diff := u - mean
+  switch {
+    case diff > 0 : diff -= 0.5
+    case diff < 0 : diff += 0.5
+  }
+  z = diff / sigma
+  p = math.Erfc ( math.Abs ( z ) / math.Sqrt2 )
+  return u, z, p
+}
+
+
+
+
+
+/*
+  A bootstrap confidence interval for the difference
+  statistic(b) - statistic(a). The statistic is given
+  the resampled values sorted.
+
+  Each sample is first cut down to at most max_sample values,
+  chosen at random, so that runs of millions of messages
+  don't take forever. The seed makes the result repeatable.
+*/
+func Bootstrap_difference ( a, b        [] float64,
+                            statistic   func ( sorted [] float64 ) float64,
+                            n_resamples   int,
+                            confidence    float64,
+                            max_sample    int,
+                            seed          int64 ) ( low, high float64 ) {
+  if len ( a ) == 0 || len ( b ) == 0 || n_resamples < 1 {
+    return math.NaN(), math.NaN()
+  }
+
+  rng := rand.New ( rand.NewSource ( seed ) )
+  a = subsample ( a, max_sample, rng )
+  b = subsample ( b, max_sample, rng )
+
+  resample := func ( from, into [] float64 ) {
+    for i := range into {
+      into [ i ] = from [ rng.Intn ( len(from) ) ]
+    }
+    sort.Float64s ( into )
+  }
+
+  a_resampled := make ( [] float64, len(a) )
+  b_resampled := make ( [] float64, len(b) )
+  differences := make ( [] float64, n_resamples )
+  for i := range differences {
+    resample ( a, a_resampled )
+    resample ( b, b_resampled )
+    differences [ i ] = statistic ( b_resampled ) - statistic ( a_resampled )
+  }
+  sort.Float64s ( differences )
+
+  tail := ( 1 - confidence ) / 2 * 100
+  return Percentile ( differences, tail ), Percentile ( differences, 100 - tail )
+}
+
+
+
+
+
+func subsample ( values [] float64, max int, rng * rand.Rand ) ( [] float64 ) {
+  if max <= 0 || len ( values ) <= max {
+    return values
+  }
+  chosen := make ( [] float64, max )
+  for i, j := range rng.Perm ( len(values) ) [ : max ] {
+    chosen [ i ] = values [ j ]
+  }
+  return chosen
+}
+
+
+
+
+
+/*
+  The mean of a slice, for use as a bootstrap statistic.
+*/
+func Mean ( values [] float64 ) ( float64 ) {
+  if len ( values ) == 0 {
+    return math.NaN()
+  }
+  sum := 0.0
+  for _, x := range values {
+    sum += x
+  }
+  return sum / float64(len(values))
+}
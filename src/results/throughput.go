@@ -0,0 +1,125 @@
+package results
+
+import ( "sort"
+         "time"
+       )
+
+
+
+
+
+/*===================================================================
+
+  Achieved throughput: how many messages per second the receivers
+  actually got, counted over a window that slides along the run.
+  One window's count says what the network delivered at that time,
+  while a rate over the whole run hides stalls and bursts.
+
+===================================================================*/
+
+/*
+  The window that drivers use unless they ask for another.
+*/
+var Default_throughput_window = time.Second
+
+
+
+type Rate_sample struct {
+  Time           float64   // end of the window, in seconds since the first arrival
+  Rate           float64   // msg/sec
+}
+
+
+
+/*
+  Throughput over the windows of a run. Median is the one to
+  quote: it is what the run settled down to, and neither the
+  slow start of one window nor a stall of another moves it far.
+  Overall is simply the message count over the whole time.
+*/
+type Throughput_stats struct {
+  Window         time.Duration
+  Windows        int
+  Overall        float64
+  Min            float64
+  Max            float64
+  Median         float64
+}
+
+
+
+
+
+/*
+  Count arrivals in a window that moves along the run by 'step'
+  at a time. The first window ends 'window' after the first
+  message; the last ends at or before the last message, so that
+  every window is full.
+*/
+func ( r * Results ) Throughput ( window, step time.Duration ) ( [] Rate_sample ) {
+  var samples [] Rate_sample
+  if len ( r.Messages ) == 0 || window <= 0 || step <= 0 {
+    return samples
+  }
+
+  width := window.Seconds()
+  first := r.Messages[0].Arrival_time
+  last  := r.Messages[len(r.Messages)-1].Arrival_time
+
+  // Messages from 'low' up to, not including, 'high'
+  // are in the window that ends at 'end'.
+  low, high := 0, 0
+  for i := 1; ; i ++ {
+    end := first + width + float64(i - 1) * step.Seconds()
+    if end > last + 1e-9 {
+      break
+    }
+    for high < len(r.Messages) && r.Messages[high].Arrival_time <= end {
+      high ++
+    }
+    for low < high && r.Messages[low].Arrival_time <= end - width {
+      low ++
+    }
+    samples = append ( samples, Rate_sample { Time : end,
+                                              Rate : float64 ( high - low ) / width } )
+  }
+  return samples
+}
+
+
+
+
+
+/*
+  Sum up the throughput of these results, counted in windows
+  of the given width that move along by a tenth of that.
+  A run shorter than one window has only its overall rate.
+*/
+func ( r * Results ) Throughput_stats ( window time.Duration ) ( Throughput_stats ) {
+  ts := Throughput_stats { Window : window }
+  if len ( r.Messages ) == 0 {
+    return ts
+  }
+
+  duration := r.Messages[len(r.Messages)-1].Arrival_time - r.Messages[0].Arrival_time
+  if duration > 0 {
+    ts.Overall = float64 ( len ( r.Messages ) ) / duration
+  }
+
+  samples := r.Throughput ( window, window / 10 )
+  ts.Windows = len ( samples )
+  if ts.Windows == 0 {
+    ts.Min, ts.Max, ts.Median = ts.Overall, ts.Overall, ts.Overall
+    return ts
+  }
+
+  rates := make ( [] float64, len(samples) )
+  for i, s := range samples {
+    rates [ i ] = s.Rate
+  }
+  sort.Float64s ( rates )
+  ts.Min    = rates [ 0 ]
+  ts.Max    = rates [ len(rates) - 1 ]
+  ts.Median = Percentile ( rates, 50 )
+  return ts
+}
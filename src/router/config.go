@@ -0,0 +1,749 @@
+package router
+
+import ( "errors"
+         "fmt"
+         "io"
+         "os"
+         "sort"
+         "strconv"
+         "strings"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A model of the qdrouterd configuration file: one struct for each
+  kind of entity that mercury uses. A Router builds its Config from
+  its ports and connections, then applies any edits that callers
+  have made -- per router, with Edit_config(), or for every router
+  in a network -- and checks the result before writing it out.
+
+  Attributes that the model doesn't have a field for can still be
+  given with Set(), and are written as they are. An empty field
+  is left out, so that the router uses its own default.
+
+===================================================================*/
+
+/*
+  Attributes with no field of their own, e.g.
+    config.Router.Set ( "actionListCapacity", "131072" )
+*/
+type Extra struct {
+  Extra                map [ string ] string
+}
+
+
+
+func ( e * Extra ) Set ( name, value string ) {
+  if e.Extra == nil {
+    e.Extra = make ( map [ string ] string )
+  }
+  e.Extra [ name ] = value
+}
+
+
+
+type Router_entity struct {
+  Id                   string
+  // "interior", "edge", or "standalone"
+  Mode                 string
+  // 0 leaves the router's default.
+  Worker_threads       int
+  // Where the Cyrus SASL config is, and its name.
+  Sasl_config_dir      string
+  Sasl_config_name     string
+  Extra
+}
+
+
+
+type Listener struct {
+  Name                 string
+  // "normal", "inter-router", "edge", or "route-container"
+  Role                 string
+  Host                 string
+  Port                 string
+  // "in", "out", "both", or "no"
+  Strip_annotations    string
+  Idle_timeout_seconds int
+  Sasl_mechanisms      string
+  Authenticate_peer    bool
+  Require_ssl          bool
+  Ssl_profile          string
+  Http                 bool
+  Extra
+}
+
+
+
+type Connector struct {
+  Name                 string
+  // "normal", "inter-router", "edge", or "route-container"
+  Role                 string
+  Host                 string
+  Port                 string
+  Idle_timeout_seconds int
+  Sasl_mechanisms      string
+  Sasl_username        string
+  Sasl_password        string
+  Ssl_profile          string
+  // "yes", "no", or "" for the router's default, which is yes.
+  Verify_hostname      string
+  Extra
+}
+
+
+
+/*
+  Exactly one of Prefix and Pattern.
+*/
+type Address struct {
+  Prefix               string
+  Pattern              string
+  // "closest", "balanced", or "multicast"
+  Distribution         string
+  // 1 to 9. 0 leaves the router's default.
+  Priority             int
+  Waypoint             bool
+  Extra
+}
+
+
+
+/*
+  Exactly one of Prefix and Pattern, and one of
+  Connection and Container_id.
+*/
+type Link_route struct {
+  Name                 string
+  Prefix               string
+  Pattern              string
+  // "in" or "out"
+  Direction            string
+  Connection           string
+  Container_id         string
+  Extra
+}
+
+
+
+/*
+  One of Connection and Container_id.
+*/
+type Auto_link struct {
+  Address              string
+  // "in" or "out"
+  Direction            string
+  Connection           string
+  Container_id         string
+  Phase                int
+  Extra
+}
+
+
+
+type Log struct {
+  // e.g. "DEFAULT", "ROUTER_CORE"
+  Module               string
+  // e.g. "info+", "trace+", or "none"
+  Enable               string
+  Output_file          string
+  Include_source       bool
+  Extra
+}
+
+
+
+type Ssl_profile struct {
+  Name                 string
+  Ca_cert_file         string
+  Cert_file            string
+  Private_key_file     string
+  Password             string
+  Extra
+}
+
+
+
+type Policy struct {
+  Max_connections      int
+  Enable_vhost_policy  bool
+  Policy_dir           string
+  Default_vhost        string
+  Extra
+}
+
+
+
+/*
+  An entity of a kind that the model doesn't know,
+  written as it is.
+*/
+type Other_entity struct {
+  Type                 string
+  Extra
+}
+
+
+
+type Config struct {
+  Router               Router_entity
+  Policy             * Policy
+  Ssl_profiles      [] Ssl_profile
+  Addresses         [] Address
+  Link_routes       [] Link_route
+  Auto_links        [] Auto_link
+  Logs              [] Log
+  Listeners         [] Listener
+  Connectors        [] Connector
+  Others            [] Other_entity
+}
+
+
+
+
+
+/*
+  A name and a value, as written in the file.
+*/
+type attribute struct {
+  name                 string
+  value                string
+}
+
+
+
+type attributes [] attribute
+
+func ( a * attributes ) add ( name, value string ) {
+  if value != "" {
+    * a = append ( * a, attribute { name, value } )
+  }
+}
+
+func ( a * attributes ) add_int ( name string, value int ) {
+  if value != 0 {
+    a.add ( name, strconv.Itoa ( value ) )
+  }
+}
+
+func ( a * attributes ) add_bool ( name string, value bool ) {
+  if value {
+    a.add ( name, "yes" )
+  }
+}
+
+func ( a * attributes ) add_extra ( e Extra ) {
+  names := make ( [] string, 0, len ( e.Extra ) )
+  for name := range e.Extra {
+    names = append ( names, name )
+  }
+  sort.Strings ( names )
+  for _, name := range names {
+    a.add ( name, e.Extra [ name ] )
+  }
+}
+
+
+
+
+
+/*
+  The listener with this name, or nil.
+*/
+func ( c * Config ) Listener ( name string ) ( * Listener ) {
+  for i := range c.Listeners {
+    if c.Listeners[i].Name == name {
+      return & c.Listeners[i]
+    }
+  }
+  return nil
+}
+
+
+
+
+
+/*
+  The connector with this name, or nil.
+*/
+func ( c * Config ) Connector ( name string ) ( * Connector ) {
+  for i := range c.Connectors {
+    if c.Connectors[i].Name == name {
+      return & c.Connectors[i]
+    }
+  }
+  return nil
+}
+
+
+
+
+
+/*
+  The address with this prefix, or nil.
+*/
+func ( c * Config ) Address ( prefix string ) ( * Address ) {
+  for i := range c.Addresses {
+    if c.Addresses[i].Prefix == prefix {
+      return & c.Addresses[i]
+    }
+  }
+  return nil
+}
+
+
+
+
+
+/*
+  Give every listener and connector the same idle timeout.
+*/
+func ( c * Config ) Set_idle_timeout ( seconds int ) {
+  for i := range c.Listeners {
+    c.Listeners[i].Idle_timeout_seconds = seconds
+  }
+  for i := range c.Connectors {
+    c.Connectors[i].Idle_timeout_seconds = seconds
+  }
+}
+
+
+
+
+
+func one_of ( what, value string, allowed ... string ) ( error ) {
+  for _, a := range allowed {
+    if value == a {
+      return nil
+    }
+  }
+  return fmt.Errorf ( "%s |%s| is not one of %s", what, value, strings.Join ( allowed, ", " ) )
+}
+
+
+
+
+
+func check_port ( port string ) ( error ) {
+  if port == "" {
+    return errors.New ( "no port" )
+  }
+  // A service name, like amqps, is fine too.
+  if n, err := strconv.Atoi ( port ); err == nil && ( n < 1 || n > 65535 ) {
+    return fmt.Errorf ( "bad port |%s|", port )
+  }
+  return nil
+}
+
+
+
+
+
+// From least to most severe.
+var log_severities = [] string { "trace", "debug", "info", "notice", "warning", "error", "critical" }
+
+
+
+
+
+/*
+  A log level is "none", or a comma-separated list of severities,
+  each of which may have a "+" to include the more severe ones.
+*/
+func Check_log_level ( level string ) ( error ) {
+  if level == "" || level == "none" {
+    return nil
+  }
+  for _, severity := range strings.Split ( level, "," ) {
+    if err := one_of ( "log severity", strings.TrimSuffix ( strings.TrimSpace ( severity ), "+" ), log_severities ... ); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+
+
+
+/*
+  Does a log with this level write lines of this severity?
+*/
+func Logs_level ( level, severity string ) ( bool ) {
+  if level == "none" {
+    return false
+  }
+  rank := func ( s string ) ( int ) {
+    for i, known := range log_severities {
+      if s == known {
+        return i
+      }
+    }
+    return -1
+  }
+  for _, s := range strings.Split ( level, "," ) {
+    s = strings.TrimSpace ( s )
+    if s == severity {
+      return true
+    }
+    if floor := rank ( strings.TrimSuffix ( s, "+" ) ); strings.HasSuffix ( s, "+" ) && floor >= 0 && floor <= rank ( severity ) {
+      return true
+    }
+  }
+  return false
+}
+
+
+
+
+
+/*
+  Check the config for mistakes that would stop the router from
+  starting, or that it would quietly ignore.
+*/
+func ( c * Config ) Validate ( ) ( error ) {
+  if c.Router.Id == "" {
+    return errors.New ( "router has no id" )
+  }
+  if err := one_of ( "router mode", c.Router.Mode, "interior", "edge", "standalone" ); err != nil {
+    return err
+  }
+  if c.Router.Worker_threads < 0 {
+    return fmt.Errorf ( "router has %d worker threads", c.Router.Worker_threads )
+  }
+
+  profiles := make ( map [ string ] bool )
+  for _, p := range c.Ssl_profiles {
+    if p.Name == "" {
+      return errors.New ( "sslProfile with no name" )
+    }
+    if profiles [ p.Name ] {
+      return fmt.Errorf ( "sslProfile |%s| defined twice", p.Name )
+    }
+    if p.Ca_cert_file == "" && p.Cert_file == "" {
+      return fmt.Errorf ( "sslProfile |%s| has no certificates", p.Name )
+    }
+    if ( p.Cert_file == "" ) != ( p.Private_key_file == "" ) {
+      return fmt.Errorf ( "sslProfile |%s| needs both a certificate and a private key", p.Name )
+    }
+    profiles [ p.Name ] = true
+  }
+
+  roles := [] string { "normal", "inter-router", "edge", "route-container" }
+  names := make ( map [ string ] bool )
+  for _, l := range c.Listeners {
+    what := fmt.Sprintf ( "listener |%s|", l.Name )
+    if l.Name != "" {
+      if names [ l.Name ] {
+        return fmt.Errorf ( "%s defined twice", what )
+      }
+      names [ l.Name ] = true
+    }
+    if err := check_port ( l.Port ); err != nil {
+      return fmt.Errorf ( "%s : %s", what, err.Error() )
+    }
+    if err := one_of ( what + " role", l.Role, roles ... ); err != nil {
+      return err
+    }
+    if l.Strip_annotations != "" {
+      if err := one_of ( what + " stripAnnotations", l.Strip_annotations, "in", "out", "both", "no" ); err != nil {
+        return err
+      }
+    }
+    if l.Ssl_profile != "" && ! profiles [ l.Ssl_profile ] {
+      return fmt.Errorf ( "%s uses unknown sslProfile |%s|", what, l.Ssl_profile )
+    }
+    if l.Require_ssl && l.Ssl_profile == "" {
+      return fmt.Errorf ( "%s requires SSL but has no sslProfile", what )
+    }
+  }
+
+  for _, k := range c.Connectors {
+    what := fmt.Sprintf ( "connector |%s|", k.Name )
+    if k.Name != "" {
+      if names [ k.Name ] {
+        return fmt.Errorf ( "%s defined twice", what )
+      }
+      names [ k.Name ] = true
+    }
+    if k.Host == "" {
+      return fmt.Errorf ( "%s has no host", what )
+    }
+    if err := check_port ( k.Port ); err != nil {
+      return fmt.Errorf ( "%s : %s", what, err.Error() )
+    }
+    if err := one_of ( what + " role", k.Role, roles ... ); err != nil {
+      return err
+    }
+    if k.Ssl_profile != "" && ! profiles [ k.Ssl_profile ] {
+      return fmt.Errorf ( "%s uses unknown sslProfile |%s|", what, k.Ssl_profile )
+    }
+    if k.Verify_hostname != "" {
+      if err := one_of ( what + " verifyHostname", k.Verify_hostname, "yes", "no" ); err != nil {
+        return err
+      }
+    }
+  }
+
+  for _, a := range c.Addresses {
+    what := fmt.Sprintf ( "address |%s%s|", a.Prefix, a.Pattern )
+    if ( a.Prefix == "" ) == ( a.Pattern == "" ) {
+      return fmt.Errorf ( "%s needs exactly one of prefix and pattern", what )
+    }
+    if a.Distribution != "" {
+      if err := one_of ( what + " distribution", a.Distribution, "closest", "balanced", "multicast" ); err != nil {
+        return err
+      }
+    }
+    if a.Priority < 0 || a.Priority > 9 {
+      return fmt.Errorf ( "%s has priority %d", what, a.Priority )
+    }
+  }
+
+  for _, l := range c.Link_routes {
+    what := fmt.Sprintf ( "linkRoute |%s%s|", l.Prefix, l.Pattern )
+    if ( l.Prefix == "" ) == ( l.Pattern == "" ) {
+      return fmt.Errorf ( "%s needs exactly one of prefix and pattern", what )
+    }
+    if err := one_of ( what + " direction", l.Direction, "in", "out" ); err != nil {
+      return err
+    }
+    if ( l.Connection == "" ) == ( l.Container_id == "" ) {
+      return fmt.Errorf ( "%s needs exactly one of connection and containerId", what )
+    }
+  }
+
+  for _, a := range c.Auto_links {
+    what := fmt.Sprintf ( "autoLink |%s|", a.Address )
+    if a.Address == "" {
+      return errors.New ( "autoLink with no address" )
+    }
+    if err := one_of ( what + " direction", a.Direction, "in", "out" ); err != nil {
+      return err
+    }
+    if ( a.Connection == "" ) == ( a.Container_id == "" ) {
+      return fmt.Errorf ( "%s needs exactly one of connection and containerId", what )
+    }
+  }
+
+  modules := make ( map [ string ] bool )
+  for _, l := range c.Logs {
+    if l.Module == "" {
+      return errors.New ( "log with no module" )
+    }
+    if strings.Trim ( l.Module, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_" ) != "" {
+      return fmt.Errorf ( "bad log module |%s|", l.Module )
+    }
+    if modules [ l.Module ] {
+      return fmt.Errorf ( "log module |%s| configured twice", l.Module )
+    }
+    modules [ l.Module ] = true
+    if err := Check_log_level ( l.Enable ); err != nil {
+      return fmt.Errorf ( "log module |%s| : %s", l.Module, err.Error() )
+    }
+  }
+
+  for _, o := range c.Others {
+    if o.Type == "" {
+      return errors.New ( "entity with no type" )
+    }
+  }
+
+  return nil
+}
+
+
+
+
+
+/*
+  Write one entity, with its values lined up.
+*/
+func write_entity ( w io.Writer, entity_type string, attrs attributes ) ( error ) {
+  width := 0
+  for _, a := range attrs {
+    if len ( a.name ) > width {
+      width = len ( a.name )
+    }
+  }
+
+  if _, err := fp ( w, "%s {\n", entity_type ); err != nil {
+    return err
+  }
+  for _, a := range attrs {
+    if _, err := fp ( w, "  %-*s : %s\n", width, a.name, a.value ); err != nil {
+      return err
+    }
+  }
+  _, err := fp ( w, "}\n" )
+  return err
+}
+
+
+
+
+
+/*
+  Write the config in qdrouterd's syntax. It is checked first,
+  and nothing is written if it is wrong.
+*/
+func ( c * Config ) Write ( w io.Writer ) ( error ) {
+  if err := c.Validate ( ); err != nil {
+    return err
+  }
+
+  type entity struct {
+    entity_type        string
+    attrs              attributes
+  }
+  var entities [] entity
+
+  var a attributes
+  a.add     ( "id",             c.Router.Id )
+  a.add     ( "mode",           c.Router.Mode )
+  a.add_int ( "workerThreads",  c.Router.Worker_threads )
+  a.add     ( "saslConfigDir",  c.Router.Sasl_config_dir )
+  a.add     ( "saslConfigName", c.Router.Sasl_config_name )
+  a.add_extra ( c.Router.Extra )
+  entities = append ( entities, entity { "router", a } )
+
+  if p := c.Policy; p != nil {
+    var a attributes
+    a.add_int   ( "maxConnections",    p.Max_connections )
+    a.add_bool  ( "enableVhostPolicy", p.Enable_vhost_policy )
+    a.add       ( "policyDir",         p.Policy_dir )
+    a.add       ( "defaultVhost",      p.Default_vhost )
+    a.add_extra ( p.Extra )
+    entities = append ( entities, entity { "policy", a } )
+  }
+
+  for _, p := range c.Ssl_profiles {
+    var a attributes
+    a.add       ( "name",           p.Name )
+    a.add       ( "caCertFile",     p.Ca_cert_file )
+    a.add       ( "certFile",       p.Cert_file )
+    a.add       ( "privateKeyFile", p.Private_key_file )
+    a.add       ( "password",       p.Password )
+    a.add_extra ( p.Extra )
+    entities = append ( entities, entity { "sslProfile", a } )
+  }
+
+  for _, addr := range c.Addresses {
+    var a attributes
+    a.add       ( "prefix",       addr.Prefix )
+    a.add       ( "pattern",      addr.Pattern )
+    a.add       ( "distribution", addr.Distribution )
+    a.add_int   ( "priority",     addr.Priority )
+    a.add_bool  ( "waypoint",     addr.Waypoint )
+    a.add_extra ( addr.Extra )
+    entities = append ( entities, entity { "address", a } )
+  }
+
+  for _, l := range c.Link_routes {
+    var a attributes
+    a.add       ( "name",        l.Name )
+    a.add       ( "prefix",      l.Prefix )
+    a.add       ( "pattern",     l.Pattern )
+    a.add       ( "direction",   l.Direction )
+    a.add       ( "connection",  l.Connection )
+    a.add       ( "containerId", l.Container_id )
+    a.add_extra ( l.Extra )
+    entities = append ( entities, entity { "linkRoute", a } )
+  }
+
+  for _, l := range c.Auto_links {
+    var a attributes
+    a.add       ( "address",     l.Address )
+    a.add       ( "direction",   l.Direction )
+    a.add       ( "connection",  l.Connection )
+    a.add       ( "containerId", l.Container_id )
+    a.add_int   ( "phase",       l.Phase )
+    a.add_extra ( l.Extra )
+    entities = append ( entities, entity { "autoLink", a } )
+  }
+
+  for _, l := range c.Logs {
+    var a attributes
+    a.add       ( "module",        l.Module )
+    a.add       ( "enable",        l.Enable )
+    a.add       ( "outputFile",    l.Output_file )
+    a.add_bool  ( "includeSource", l.Include_source )
+    a.add_extra ( l.Extra )
+    entities = append ( entities, entity { "log", a } )
+  }
+
+  for _, l := range c.Listeners {
+    var a attributes
+    a.add       ( "name",               l.Name )
+    a.add       ( "role",               l.Role )
+    a.add       ( "host",               l.Host )
+    a.add       ( "port",               l.Port )
+    a.add       ( "stripAnnotations",   l.Strip_annotations )
+    a.add_int   ( "idleTimeoutSeconds", l.Idle_timeout_seconds )
+    a.add       ( "saslMechanisms",     l.Sasl_mechanisms )
+    // Say so either way: it matters too much to leave to a default.
+    if l.Authenticate_peer {
+      a.add ( "authenticatePeer", "yes" )
+    } else {
+      a.add ( "authenticatePeer", "no" )
+    }
+    a.add_bool  ( "requireSsl",         l.Require_ssl )
+    a.add       ( "sslProfile",         l.Ssl_profile )
+    a.add_bool  ( "http",               l.Http )
+    a.add_extra ( l.Extra )
+    entities = append ( entities, entity { "listener", a } )
+  }
+
+  for _, k := range c.Connectors {
+    var a attributes
+    a.add       ( "name",               k.Name )
+    a.add       ( "role",               k.Role )
+    a.add       ( "host",               k.Host )
+    a.add       ( "port",               k.Port )
+    a.add_int   ( "idleTimeoutSeconds", k.Idle_timeout_seconds )
+    a.add       ( "saslMechanisms",     k.Sasl_mechanisms )
+    a.add       ( "saslUsername",       k.Sasl_username )
+    a.add       ( "saslPassword",       k.Sasl_password )
+    a.add       ( "sslProfile",         k.Ssl_profile )
+    a.add       ( "verifyHostname",     k.Verify_hostname )
+    a.add_extra ( k.Extra )
+    entities = append ( entities, entity { "connector", a } )
+  }
+
+  for _, o := range c.Others {
+    var a attributes
+    a.add_extra ( o.Extra )
+    entities = append ( entities, entity { o.Type, a } )
+  }
+
+  for _, e := range entities {
+    if err := write_entity ( w, e.entity_type, e.attrs ); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+
+
+
+func ( c * Config ) Write_file ( file_name string ) ( error ) {
+  // Don't leave a broken file behind.
+  if err := c.Validate ( ); err != nil {
+    return err
+  }
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+  if err = c.Write ( f ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
@@ -2,8 +2,11 @@ package router
 
 import ( "errors"
          "fmt"
+         "io/ioutil"
+         "net"
          "os"
          "os/exec"
+         "sort"
          "syscall"
          "strings"
          "time"
@@ -32,6 +35,14 @@ const (
 
 
 
+/*
+  How long a router is given to shut down after it has
+  been asked to, before it is killed.
+*/
+const Default_halt_grace = 10 * time.Second
+
+
+
 
 
 func state_to_string ( state router_state ) ( string ) {
@@ -70,8 +81,9 @@ type Router struct {
   log_path                       string
   Log_file_path                  string
   config_file_path               string
-  ld_library_path                string
-  pythonpath                     string
+  // What the router process is started with, on top
+  // of mercury's own environment.
+  env                            utils.Environment
   include_path                   string
   console_path                   string
   client_port                    string
@@ -79,10 +91,17 @@ type Router struct {
   router_port                    string
   edge_port                      string
   verbose                        bool
+  halt_grace                     time.Duration
 
   Pid                            int
   state                          router_state            
   cmd                          * exec.Cmd
+  supervisor                   * utils.Supervisor
+
+  // Called when the router process exits, expectedly or not.
+  On_exit                        func ( utils.Process_exit )
+  // Called with the process id each time the router process starts.
+  On_start                       func ( pid int )
   i_connect_to_ports            [] string
   I_connect_to_names            [] string
 
@@ -90,6 +109,25 @@ type Router struct {
   Connect_to_me_interior        [] string
   connect_to_me_edge            [] string
 
+  // Log enable levels by Dispatch module, e.g. "ROUTER_CORE" : "trace+"
+  log_levels                     map [ string ] string
+
+  // Changes to the default config, in order.
+  config_edits                  [] func ( * Config )
+
+  // If tls_ca is set, the listeners named here use TLS,
+  // as do the connectors to other routers' listeners
+  // of the same names.
+  tls_ca                       * utils.Certificate_authority
+  tls_listeners                  map [ string ] bool
+
+  // If sasl is set, the listeners named here authenticate
+  // their peers with the SASL mechanism, and the connectors
+  // to other routers' listeners of the same names log in.
+  sasl                         * utils.Sasl_config
+  sasl_mechanism                 string
+  sasl_listeners                 map [ string ] bool
+
   start_time                    float64
 }
 
@@ -152,13 +190,15 @@ func New_Router ( name                        string,
                  log_path        : log_path,
                  include_path    : include_path,
                  console_path    : console_path,
-                 ld_library_path : ld_library_path,
-                 pythonpath      : pythonpath,
                  client_port     : client_port,
                  console_port    : console_port,
                  router_port     : router_port,
                  edge_port       : edge_port,
-                 verbose         : verbose }
+                 verbose         : verbose,
+                 halt_grace      : Default_halt_grace }
+
+  r.env = utils.Environment { "LD_LIBRARY_PATH" : ld_library_path,
+                              "PYTHONPATH"      : pythonpath }
 
   r.start_time = utils.Timestamp()
   return r
@@ -168,6 +208,50 @@ func New_Router ( name                        string,
 
 
 
+/*
+  How many worker threads the router is configured with.
+  A running router must be run again to see a change.
+*/
+func ( r * Router ) Set_worker_threads ( n int ) {
+  r.worker_threads = n
+}
+
+
+
+func ( r * Router ) Worker_threads ( ) ( int ) {
+  return r.worker_threads
+}
+
+
+
+
+
+/*
+  How long Halt() waits for the router to shut down before
+  killing it.
+*/
+func ( r * Router ) Set_halt_grace ( grace time.Duration ) {
+  r.halt_grace = grace
+}
+
+
+
+
+
+/*
+  Start the router process with this environment variable set,
+  in addition to LD_LIBRARY_PATH and PYTHONPATH. Setting one of
+  those replaces the router's value. Only takes effect the next
+  time the router is run.
+*/
+func ( r * Router ) Setenv ( name, value string ) {
+  r.env [ name ] = value
+}
+
+
+
+
+
 func ( r * Router ) Verbose ( val bool ) {
   r.verbose = val
 }
@@ -319,7 +403,8 @@ func ( r * Router ) Edge_port ( ) string {
 /*
   Initialization of a router does whatever is needed 
   to get ready to launch the router, i.e. write the
-  configuration file.
+  configuration file. A router whose config file can't be
+  written stays as it was.
 */
 func ( r * Router ) Init ( ) error {
   if r.state >= initialized {
@@ -327,129 +412,347 @@ func ( r * Router ) Init ( ) error {
   }
 
   r.config_file_path = r.config_path + "/" + r.name + ".conf"
+  if err := r.write_config_file ( ); err != nil {
+    return err
+  }
   r.state = initialized
-  return r.write_config_file ( )
+  return nil
 }
 
 
 
 
 
-func ( r * Router ) write_config_file ( ) error {
-  f, err := os.Create ( r.config_file_path )
-  if err != nil {
-    return err
+/*
+  The config that every router gets, before any edits:
+  listeners for clients and the console -- and, for interior
+  routers, for other interiors and for edges -- and a connector
+  to each router that this one connects to. With Use_tls(),
+  an sslProfile for the listeners and connectors that use TLS,
+  and with Use_sasl(), the SASL config that authenticates them.
+*/
+func ( r * Router ) default_config ( ) ( * Config ) {
+  c := & Config { Router : Router_entity { Id             : r.name,
+                                           Mode           : r.router_type,
+                                           Worker_threads : r.worker_threads } }
+
+  c.Addresses = [] Address { { Prefix : "closest",   Distribution : "closest" },
+                             { Prefix : "speedy",    Distribution : "closest", Priority : 8 },
+                             { Prefix : "balanced",  Distribution : "balanced" },
+                             { Prefix : "multicast", Distribution : "multicast" } }
+
+  c.Logs = [] Log { { Module         : "DEFAULT",
+                      Enable         : r.log_levels [ "DEFAULT" ],
+                      Output_file    : r.log_path + "/" + r.name + ".log",
+                      Include_source : true } }
+  // The other modules write to the DEFAULT log's file.
+  var modules [] string
+  for module := range r.log_levels {
+    if module != "DEFAULT" {
+      modules = append ( modules, module )
+    }
   }
-  defer f.Close ( )
-
-  fp ( f, "router {\n" )
-  fp ( f, "  workerThreads : %d\n", r.worker_threads )
-  // fp ( f, "  actionListCapacity : 131072\n" )   TEMP -- Franz lock-free
-  fp ( f, "  mode          : %s\n", r.router_type )
-  fp ( f, "  id            : %s\n", r.name )
-  fp ( f, "}\n" )
-
-  fp ( f, "address {\n" );
-  fp ( f, "  prefix       : closest\n" );
-  fp ( f, "  distribution : closest\n" );
-  fp ( f, "}\n" )
-
-  fp ( f, "address {\n" );
-  fp ( f, "  prefix       : speedy\n" );
-  fp ( f, "  distribution : closest\n" );
-  fp ( f, "  priority     : 8\n" );
-  fp ( f, "}\n" )
-
-  fp ( f, "address {\n" );
-  fp ( f, "  prefix       : balanced\n" );
-  fp ( f, "  distribution : balanced\n" );
-  fp ( f, "}\n" )
-
-  fp ( f, "address {\n" );
-  fp ( f, "  prefix       : multicast\n" );
-  fp ( f, "  distribution : multicast\n" );
-  fp ( f, "}\n" )
-
-  r.Log_file_path = r.log_path + "/" + r.name + ".log"
-
-  fp ( f, "log {\n" )
-  fp ( f, "  outputFile    : %s\n", r.Log_file_path )
-  // TODO -- make this available from the top.
-  // Use this if you want no output.
-  //fp ( f, "  enable        : none\n" )
-  fp ( f, "  includeSource : true\n" )
-  fp ( f, "  module        : DEFAULT\n" )
-  // fp ( f, "  enable        : trace+\n" )   TODO 
-  fp ( f, "}\n" )
-
-  // The Client Listener -----------------
-  fp ( f, "listener {\n" )
-  fp ( f, "  role               : normal\n")
-  fp ( f, "  host               : 0.0.0.0\n")
-  fp ( f, "  port               : %s\n", r.client_port )
-  fp ( f, "  stripAnnotations   : no\n")
-  fp ( f, "  idleTimeoutSeconds : 120\n")
-  fp ( f, "  saslMechanisms     : ANONYMOUS\n")
-  fp ( f, "  authenticatePeer   : no\n")
-  fp ( f, "}\n")
-
-  // The Console Listener -----------------
-  fp ( f, "listener {\n" )
-  fp ( f, "  role               : normal\n")
-  fp ( f, "  host               : 0.0.0.0\n")
-  fp ( f, "  port               : %s\n", r.console_port )
-  fp ( f, "  stripAnnotations   : no\n")
-  fp ( f, "  idleTimeoutSeconds : 120\n")
-  fp ( f, "  saslMechanisms     : ANONYMOUS\n")
-  fp ( f, "  authenticatePeer   : no\n")
-  fp ( f, "  http               : true\n")
-  fp ( f, "}\n")
-
-  // The Router Listener -----------------
-  if r.router_type != "edge" {
-    fp ( f, "listener {\n" )
-    fp ( f, "  role               : inter-router\n")
-    fp ( f, "  host               : 0.0.0.0\n")
-    fp ( f, "  port               : %s\n", r.router_port )
-    fp ( f, "  stripAnnotations   : no\n")
-    fp ( f, "  idleTimeoutSeconds : 120\n")
-    fp ( f, "  saslMechanisms     : ANONYMOUS\n")
-    fp ( f, "  authenticatePeer   : no\n")
-    fp ( f, "}\n")
-  }
-
-  /*
-  */
-  // The Edge Listener -----------------
+  sort.Strings ( modules )
+  for _, module := range modules {
+    c.Logs = append ( c.Logs, Log { Module : module, Enable : r.log_levels [ module ] } )
+  }
+
+  listener := func ( name, role, port string ) ( Listener ) {
+    return Listener { Name                 : name,
+                      Role                 : role,
+                      Host                 : "0.0.0.0",
+                      Port                 : port,
+                      Strip_annotations    : "no",
+                      Idle_timeout_seconds : 120,
+                      Sasl_mechanisms      : "ANONYMOUS" }
+  }
+  c.Listeners = append ( c.Listeners, listener ( "client", "normal", r.client_port ) )
+  console := listener ( "console", "normal", r.console_port )
+  console.Http = true
+  c.Listeners = append ( c.Listeners, console )
   if r.router_type != "edge" {
+    c.Listeners = append ( c.Listeners, listener ( "inter-router", "inter-router", r.router_port ) )
     // Edge routers do not get an edge listener.
-    fp ( f, "listener {\n" )
-    fp ( f, "  role               : edge\n")
-    fp ( f, "  host               : 0.0.0.0\n")
-    fp ( f, "  port               : %s\n", r.edge_port )
-    fp ( f, "  stripAnnotations   : no\n")
-    fp ( f, "  idleTimeoutSeconds : 120\n")
-    fp ( f, "  saslMechanisms     : ANONYMOUS\n")
-    fp ( f, "  authenticatePeer   : no\n")
-    fp ( f, "}\n")
-  }
-
-  // The Connectors --------------------
+    c.Listeners = append ( c.Listeners, listener ( "edge", "edge", r.edge_port ) )
+  }
+
+  role := "inter-router"
+  if r.router_type == "edge" {
+    role = "edge"
+  }
   for _, port := range r.i_connect_to_ports {
-    // fp ( os.Stderr, "router %s connect to %s\n", r.name, port )
-    fp ( f, "connector {\n" )
-    //fp ( f, "  verifyHostname     : no\n")
-    fp ( f, "  name               : %s_connector_to_%s\n", r.name, port)
-    fp ( f, "  idleTimeoutSeconds : 120\n")
-    fp ( f, "  saslMechanisms     : ANONYMOUS\n")
-    fp ( f, "  host               : 127.0.0.1\n")
-    fp ( f, "  port               : %s\n", port)
-    if r.router_type == "edge" {
-      fp ( f, "  role               : edge\n")
-    } else {
-      fp ( f, "  role               : inter-router\n")
-    }
-    fp ( f, "}\n")
+    c.Connectors = append ( c.Connectors,
+                            Connector { Name                 : r.name + "_connector_to_" + port,
+                                        Role                 : role,
+                                        Host                 : "127.0.0.1",
+                                        Port                 : port,
+                                        Idle_timeout_seconds : 120,
+                                        Sasl_mechanisms      : "ANONYMOUS" } )
+  }
+
+  if r.tls_ca != nil {
+    cert_file, key_file := r.tls_files ( )
+    c.Ssl_profiles = append ( c.Ssl_profiles, Ssl_profile { Name             : tls_profile,
+                                                            Ca_cert_file     : r.tls_ca.Cert_file,
+                                                            Cert_file        : cert_file,
+                                                            Private_key_file : key_file } )
+    for i := range c.Listeners {
+      if r.tls_listeners [ c.Listeners[i].Name ] {
+        c.Listeners[i].Ssl_profile = tls_profile
+        c.Listeners[i].Require_ssl = true
+      }
+    }
+    // An inter-router connector goes to an inter-router
+    // listener, and an edge connector to an edge listener.
+    for i := range c.Connectors {
+      if r.tls_listeners [ c.Connectors[i].Role ] {
+        c.Connectors[i].Ssl_profile = tls_profile
+      }
+    }
+  }
+
+  if r.sasl != nil {
+    c.Router.Sasl_config_dir  = r.sasl.Dir
+    c.Router.Sasl_config_name = r.sasl.Name
+    for i := range c.Listeners {
+      if r.sasl_listeners [ c.Listeners[i].Name ] {
+        c.Listeners[i].Sasl_mechanisms   = r.sasl_mechanism
+        c.Listeners[i].Authenticate_peer = true
+      }
+    }
+    for i := range c.Connectors {
+      if r.sasl_listeners [ c.Connectors[i].Role ] {
+        c.Connectors[i].Sasl_mechanisms = r.sasl_mechanism
+        c.Connectors[i].Sasl_username   = r.sasl.User
+        c.Connectors[i].Sasl_password   = r.sasl.Password
+      }
+    }
+  }
+
+  return c
+}
+
+
+
+
+
+/*
+  Set what one Dispatch module -- ROUTER_CORE, PROTOCOL, SERVER,
+  and so on, or DEFAULT for all the others -- logs, as qdrouterd
+  has it: "trace+" for everything, "info+", "none" for nothing
+  at all, or a list like "info,error". An empty level goes back
+  to the router's own default. A running router must be run
+  again to see the change.
+
+  Readiness checks do not need the log, so performance runs
+  can turn it off.
+*/
+func ( r * Router ) Set_log_level ( module, level string ) {
+  if module == "" {
+    module = "DEFAULT"
+  }
+  if r.log_levels == nil {
+    r.log_levels = make ( map [ string ] string )
+  }
+  if level == "" {
+    delete ( r.log_levels, module )
+  } else {
+    r.log_levels [ module ] = level
+  }
+}
+
+
+
+
+
+/*
+  The listeners that can use TLS, by name.
+*/
+var Tls_listeners = [] string { "client", "inter-router", "edge" }
+
+// The sslProfile that Use_tls() adds.
+const tls_profile = "mercury_tls"
+
+
+
+
+
+func Check_tls_listener ( name string ) ( error ) {
+  return one_of ( "TLS listener", name, Tls_listeners ... )
+}
+
+
+
+
+
+/*
+  Use TLS on the named listeners, with a certificate from ca
+  that is made when the config is written, and on the
+  connectors to other routers' listeners of the same names.
+  No names turns TLS off again. A running router must be run
+  again to see the change.
+*/
+func ( r * Router ) Use_tls ( ca * utils.Certificate_authority, listeners [] string ) ( error ) {
+  for _, name := range listeners {
+    if err := Check_tls_listener ( name ); err != nil {
+      return err
+    }
+  }
+
+  r.tls_ca        = nil
+  r.tls_listeners = make ( map [ string ] bool )
+  if len ( listeners ) == 0 {
+    return nil
+  }
+  r.tls_ca = ca
+  for _, name := range listeners {
+    r.tls_listeners [ name ] = true
+  }
+  return nil
+}
+
+
+
+
+
+/*
+  The SASL mechanisms that Use_sasl() knows. Without it, every
+  listener and connector uses ANONYMOUS.
+*/
+var Sasl_mechanisms = [] string { "PLAIN", "SCRAM-SHA-1", "SCRAM-SHA-256" }
+
+
+
+
+
+func Check_sasl_mechanism ( mechanism string ) ( error ) {
+  return one_of ( "SASL mechanism", mechanism, Sasl_mechanisms ... )
+}
+
+
+
+
+
+/*
+  The listeners that can authenticate their peers are the
+  ones that can use TLS.
+*/
+func Check_sasl_listener ( name string ) ( error ) {
+  return one_of ( "SASL listener", name, Tls_listeners ... )
+}
+
+
+
+
+
+/*
+  Authenticate peers on the named listeners -- the same ones that
+  can use TLS -- with the mechanism, and log in with it on the
+  connectors to other routers' listeners of the same names, as
+  the user in sasl. No names turns authentication off again.
+  A running router must be run again to see the change.
+
+  PLAIN sends the password in the clear, so the router refuses
+  it on a listener that does not also use TLS. Topologies may not
+  ask for that.
+*/
+func ( r * Router ) Use_sasl ( mechanism string, sasl * utils.Sasl_config, listeners [] string ) ( error ) {
+  for _, name := range listeners {
+    if err := Check_sasl_listener ( name ); err != nil {
+      return err
+    }
+  }
+  if len ( listeners ) > 0 {
+    if err := Check_sasl_mechanism ( mechanism ); err != nil {
+      return err
+    }
+  }
+
+  r.sasl           = nil
+  r.sasl_mechanism = ""
+  r.sasl_listeners = make ( map [ string ] bool )
+  if len ( listeners ) == 0 {
+    return nil
+  }
+  r.sasl           = sasl
+  r.sasl_mechanism = mechanism
+  for _, name := range listeners {
+    r.sasl_listeners [ name ] = true
+  }
+  return nil
+}
+
+
+
+
+
+/*
+  Where the router's own certificate and key go.
+*/
+func ( r * Router ) tls_files ( ) ( cert_file, key_file string ) {
+  base := r.config_path + "/" + r.name
+  return base + ".crt", base + ".key"
+}
+
+
+
+
+
+/*
+  Change the router's config before it is written, e.g.
+
+    r.Edit_config ( func ( c * router.Config ) {
+      c.Router.Set ( "actionListCapacity", "131072" )
+      c.Logs[0].Enable = "trace+"
+    } )
+
+  Edits are applied in the order they are made, each time the
+  config is written, so they survive changes to the router's
+  ports. A running router must be run again to see them.
+*/
+func ( r * Router ) Edit_config ( edit func ( * Config ) ) {
+  r.config_edits = append ( r.config_edits, edit )
+}
+
+
+
+
+
+/*
+  The router's config as it will be written: the default,
+  with all the edits made.
+*/
+func ( r * Router ) Config ( ) ( * Config ) {
+  c := r.default_config ( )
+  for _, edit := range r.config_edits {
+    edit ( c )
+  }
+  return c
+}
+
+
+
+
+
+func ( r * Router ) write_config_file ( ) error {
+  if r.tls_ca != nil {
+    cert_file, key_file := r.tls_files ( )
+    if err := r.tls_ca.Issue ( r.name, cert_file, key_file ); err != nil {
+      return fmt.Errorf ( "router |%s| can't have a certificate : %s", r.name, err.Error() )
+    }
+  }
+
+  c := r.Config ( )
+  if err := c.Write_file ( r.config_file_path ); err != nil {
+    return fmt.Errorf ( "router |%s| config : %s", r.name, err.Error() )
+  }
+
+  // The log may have been moved by an edit.
+  r.Log_file_path = ""
+  for _, l := range c.Logs {
+    if l.Module == "DEFAULT" {
+      r.Log_file_path = l.Output_file
+    }
   }
 
   umi ( r.verbose, "router |%s| config file written to |%s|", r.name, r.config_file_path )
@@ -472,10 +775,6 @@ func ( r * Router ) Run ( ) ( int, error ) {
     return 0, nil
   }
 
-  // Set up the environment for the router process.
-  os.Setenv ( "LD_LIBRARY_PATH", r.ld_library_path )
-  os.Setenv ( "PYTHONPATH"     , r.pythonpath )
-
   router_args := " --config " + r.config_file_path + " -I " + r.include_path
   args        := router_args
 
@@ -488,28 +787,36 @@ func ( r * Router ) Run ( ) ( int, error ) {
     fp ( os.Stdout, "   router.Run error: can't execute |%s|\n", r.executable_path )
     return 0, errors.New ( "Can't execute router executable." )
   }
-  r.cmd.Start ( )
+  r.cmd.Env = r.env.List ( )
+  // In its own process group, so that Ctrl-C reaches only
+  // mercury, which then halts the router properly.
+  r.cmd.SysProcAttr = & syscall.SysProcAttr { Setpgid : true }
+
+  // Anything the router says outside its log -- a Python
+  // traceback, a bad option -- goes to these files.
+  output_base := r.log_path + "/" + r.name
+  if err := utils.Capture_output ( r.cmd, output_base, "router " + r.name, r.verbose ); err != nil {
+    return 0, fmt.Errorf ( "router |%s| can't capture output: %s", r.name, err.Error() )
+  }
+  if err := r.cmd.Start ( ); err != nil {
+    utils.Close_output ( r.cmd )
+    ume ( "router |%s| can't start |%s| : %s", r.name, r.executable_path, err.Error() )
+    return 0, fmt.Errorf ( "router |%s| can't start: %s", r.name, err.Error() )
+  }
   r.state = running
 
-  if r.cmd.Process == nil {
-    fp ( os.Stdout, "   router.Run error: can't execute |%s|\n", r.executable_path )
-    return 0, errors.New ( "Can't execute router executable." )
+  r.Pid        = r.cmd.Process.Pid
+  r.supervisor = utils.Supervise ( r.cmd, "router", r.name, r.Log_file_path, output_base + ".stderr", r.On_exit )
+  if r.On_start != nil {
+    r.On_start ( r.Pid )
   }
 
-  r.Pid = r.cmd.Process.Pid
-
   umi ( r.verbose, "Router |%s| has started with Pid %d .", r.name, r.Pid )
 
   // Write the environment variables to the config directory.
   // This helps the user to reproduce this test, if desired.
   env_file_name := r.config_path + "/" + r.name + "_environment_variables"
-  env_file, err := os.Create ( env_file_name )
-  utils.Check ( err )
-  defer env_file.Close ( )
-  env_string := "export LD_LIBRARY_PATH=" + r.ld_library_path + "\n"
-  env_file.WriteString ( env_string )
-  env_string  = "export PYTHONPATH=" + r.pythonpath + "\n"
-  env_file.WriteString ( env_string )
+  utils.Check ( r.env.Write_file ( env_file_name ) )
 
   // Write the command line to the results directory.
   // This helps the user to reproduce this test, if desired.
@@ -527,6 +834,346 @@ func ( r * Router ) Run ( ) ( int, error ) {
 
 
 
+// The ports that this router listens on.
+func ( r * Router ) listener_ports ( ) ( [] string ) {
+  ports := [] string { r.client_port, r.console_port }
+  if r.router_type != "edge" {
+    ports = append ( ports, r.router_port, r.edge_port )
+  }
+  return ports
+}
+
+
+
+
+
+/*
+  Has the router process gone away?
+*/
+func ( r * Router ) process_has_exited ( ) ( bool ) {
+  return r.supervisor == nil || r.supervisor.Exit() != nil
+}
+
+
+
+/*
+  How the router process ended, or nil if it has not.
+*/
+func ( r * Router ) Exit ( ) ( * utils.Process_exit ) {
+  if r.supervisor == nil {
+    return nil
+  }
+  return r.supervisor.Exit ( )
+}
+
+
+
+
+
+/*
+  The router logs a line like this each time one of its
+  connectors opens its connection:
+    [C3] Connection Opened: dir=out host=127.0.0.1:41237 ...
+  Return the connector ports for which there is no such line yet.
+
+  Better still, the router's own connections can be seen in /proc,
+  which works however little the router logs. The log is only used
+  when they can't.
+*/
+func ( r * Router ) unopened_connectors ( ) ( [] string ) {
+  if len ( r.i_connect_to_ports ) == 0 {
+    return nil
+  }
+
+  if r.Pid > 0 {
+    if connected, err := utils.Connected_ports ( r.Pid ); err == nil {
+      var unopened [] string
+      for _, port := range r.i_connect_to_ports {
+        if ! connected [ port ] {
+          unopened = append ( unopened, port )
+        }
+      }
+      return unopened
+    }
+  }
+
+  if ! r.logs_connections ( ) {
+    // There is no way to tell. The connectors will
+    // open soon enough, once the listeners are up.
+    return nil
+  }
+
+  content, _ := ioutil.ReadFile ( r.Log_file_path )
+  var opened [] string
+  for _, line := range strings.Split ( string(content), "\n" ) {
+    if strings.Contains ( line, "Connection Opened" ) && strings.Contains ( line, "dir=out" ) {
+      opened = append ( opened, line )
+    }
+  }
+
+  var unopened [] string
+  for _, port := range r.i_connect_to_ports {
+    found := false
+    for _, line := range opened {
+      if strings.Contains ( line, ":" + port + " " ) {
+        found = true
+        break
+      }
+    }
+    if ! found {
+      unopened = append ( unopened, port )
+    }
+  }
+
+  return unopened
+}
+
+
+
+
+
+/*
+  Wait until the router is ready for traffic: all of its
+  listeners accept TCP connections and all of its connectors
+  have opened their connections, as reported in its log.
+  If that has not happened by the deadline, or if the router
+  exits while we wait, return an error that says what is missing.
+  If some other process has one of the router's ports, return
+  a Bind_error, so that the router can be given new ones.
+  Closing stop gives up the wait at once.
+*/
+func ( r * Router ) Wait_until_ready ( deadline time.Time, stop <-chan struct{} ) ( error ) {
+  if r.state != running {
+    return fmt.Errorf ( "router |%s| is not running", r.name )
+  }
+
+  pending_listeners := r.listener_ports ( )
+
+  for {
+    var still_pending [] string
+    for _, port := range pending_listeners {
+      if ! r.is_listening ( port ) {
+        still_pending = append ( still_pending, port )
+      }
+    }
+    pending_listeners = still_pending
+
+    if conflicts := r.port_conflicts ( pending_listeners ); len ( conflicts ) > 0 {
+      return & Bind_error { Router : r.name, Ports : conflicts }
+    }
+
+    var unopened [] string
+    if len ( pending_listeners ) == 0 {
+      unopened = r.unopened_connectors ( )
+      if len ( unopened ) == 0 {
+        umi ( r.verbose, "router |%s| is ready.", r.name )
+        return nil
+      }
+    }
+
+    if r.process_has_exited ( ) {
+      how := "exited"
+      if exit := r.Exit(); exit != nil {
+        how = exit.String()
+      }
+      return fmt.Errorf ( "router |%s| : %s before it was ready. See |%s|", r.name, how, r.Log_file_path )
+    }
+
+    if time.Now().After ( deadline ) {
+      if len ( pending_listeners ) > 0 {
+        return fmt.Errorf ( "router |%s| never listened on ports %v", r.name, pending_listeners )
+      }
+      return fmt.Errorf ( "router |%s| connectors to ports %v never opened. See |%s|", r.name, unopened, r.Log_file_path )
+    }
+
+    select {
+      case <- stop :
+        return fmt.Errorf ( "router |%s| : stopped waiting for it to be ready", r.name )
+      case <- time.After ( 100 * time.Millisecond ) :
+    }
+  }
+}
+
+
+
+
+
+/*
+  Returned when a router can't listen on some of its ports
+  because something else already has them.
+*/
+type Bind_error struct {
+  Router               string
+  Ports             [] string
+}
+
+
+
+func ( e * Bind_error ) Error ( ) ( string ) {
+  return fmt.Sprintf ( "router |%s| can't listen on ports %v : they are in use", e.Router, e.Ports )
+}
+
+
+
+
+
+/*
+  Is the router itself listening on the port? It is not enough
+  that something accepts connections there. If the router's
+  sockets can't be seen in /proc, that has to do.
+*/
+func ( r * Router ) is_listening ( port string ) ( bool ) {
+  if r.Pid > 0 {
+    if ports, err := utils.Listening_ports ( r.Pid ); err == nil {
+      return ports [ port ]
+    }
+  }
+
+  conn, err := net.DialTimeout ( "tcp", "127.0.0.1:" + port, 250 * time.Millisecond )
+  if err != nil {
+    return false
+  }
+  conn.Close ( )
+  return true
+}
+
+
+
+
+
+/*
+  Of the ports that the router is not listening on yet, those that
+  it never will be, because it failed to bind them. The router logs
+  a line like this when that happens:
+    Listener error on 0.0.0.0:5673: Address already in use - listen on 0.0.0.0:5673 (proton:io)
+  A port that some other process is listening on counts too.
+*/
+func ( r * Router ) port_conflicts ( pending [] string ) ( [] string ) {
+  if len ( pending ) == 0 {
+    return nil
+  }
+
+  content, _ := ioutil.ReadFile ( r.Log_file_path )
+  var failures [] string
+  for _, line := range strings.Split ( string(content), "\n" ) {
+    if strings.Contains ( line, "Listener error" ) || strings.Contains ( line, "Address already in use" ) {
+      failures = append ( failures, line )
+    }
+  }
+
+  var conflicts [] string
+  for _, port := range pending {
+    in_log := false
+    for _, line := range failures {
+      if mentions_port ( line, port ) {
+        in_log = true
+        break
+      }
+    }
+    if in_log {
+      conflicts = append ( conflicts, port )
+      continue
+    }
+
+    // Only if the router's own sockets can be seen can
+    // a listener there be known to belong to someone else.
+    if _, err := utils.Listening_ports ( r.Pid ); err == nil {
+      if conn, err := net.DialTimeout ( "tcp", "127.0.0.1:" + port, 250 * time.Millisecond ); err == nil {
+        conn.Close ( )
+        conflicts = append ( conflicts, port )
+      }
+    }
+  }
+
+  return conflicts
+}
+
+
+
+
+
+/*
+  Does the router's log say when connections open? That's an info
+  line from the SERVER module, which logs at the DEFAULT level
+  unless it has its own. The router's default is info and up.
+*/
+func ( r * Router ) logs_connections ( ) ( bool ) {
+  if r.Log_file_path == "" {
+    return false
+  }
+  level, present := r.log_levels [ "SERVER" ]
+  if ! present {
+    level, present = r.log_levels [ "DEFAULT" ]
+  }
+  return ! present || Logs_level ( level, "info" )
+}
+
+
+
+
+
+// Does the line have ":port" in it, and not as part of a longer number?
+func mentions_port ( line, port string ) ( bool ) {
+  target := ":" + port
+  for start := 0; ; {
+    i := strings.Index ( line[start:], target )
+    if i < 0 {
+      return false
+    }
+    end := start + i + len ( target )
+    if end == len ( line ) || line[end] < '0' || line[end] > '9' {
+      return true
+    }
+    start = end
+  }
+}
+
+
+
+
+
+/*
+  Use new_port wherever the router used old_port, for a listener
+  or a connector. Returns true if the router used old_port.
+  Call Rewrite_config() once all of the router's ports are right.
+*/
+func ( r * Router ) Replace_port ( old_port, new_port string ) ( bool ) {
+  replaced := false
+  for _, port := range [] * string { & r.client_port, & r.console_port, & r.router_port, & r.edge_port } {
+    if * port == old_port {
+      * port   = new_port
+      replaced = true
+    }
+  }
+  for i := range r.i_connect_to_ports {
+    if r.i_connect_to_ports [ i ] == old_port {
+      r.i_connect_to_ports [ i ] = new_port
+      replaced = true
+    }
+  }
+  return replaced
+}
+
+
+
+
+
+/*
+  Write the router's config file again, after its ports have
+  changed. A running router must be halted and run again for
+  the change to take effect.
+*/
+func ( r * Router ) Rewrite_config ( ) ( error ) {
+  if r.state < initialized {
+    return nil
+  }
+  return r.write_config_file ( )
+}
+
+
+
+
+
 func ( r * Router ) Is_not_halted ( ) ( bool ) {
   return "halted" != r.State()
 }
@@ -560,12 +1207,14 @@ func ( r * Router ) State ( ) ( string ) {
 
 
 /*
-  Halt the router.
+  Halt the router, and wait until it has exited.
   If it has already halted on its own, that is returned
-  as an error. If the process returned an error code, 
-  return that to the caller -- but early termination is
-  considered an error even if the process did not return 
-  an error code.
+  as an error, saying how it ended: early termination is
+  an error even if the process did not return an error code.
+
+  A router that is still running after its halt grace period
+  is killed. That is not an error, but Exit() shows it, and
+  how long the router took to go.
 */
 func ( r * Router ) Halt ( ) error {
   if r.verbose {
@@ -577,55 +1226,28 @@ func ( r * Router ) Halt ( ) error {
     return nil
   }
 
-  // Set up a channel that will return a 
-  // message immediately if the process has
-  // already terminated. Then set up a half-second 
-  // timer. If the timer expires before the Wait 
-  // returns a 'done' message, we judge that the 
-  // process was still running when we came along
-  // and killed it. Which is good.
-  done := make ( chan error, 1 )
-  go func ( ) {
-      done <- r.cmd.Wait ( )
-  } ( )
-
   // In any case, the router is now halted.
   r.state = halted
 
-  select {
-    /*
-      This is the expected case.
-      Our timer times out while the above Wait() is still waiting.
-      This means that the process is still running normally when we kill it.
-    */
-    case <-time.After ( 250 * time.Millisecond ) :
-
-      // Don't just kill it! Give the router a chance to shut down.
-      // if err := r.cmd.Process.Kill(); err != nil {
-      if err := r.cmd.Process.Signal( syscall.SIGTERM ); err != nil {
-        return errors.New ( "failed to kill process: " + err.Error() )
-      }
-
-      // This is the good case. It was not already dead when 
-      // we came here, and we successfully halted it.
-      umi ( r.verbose, "Router |%s| halted.", r.name )
-      return nil
-
-    case err := <-done:
-      if err != nil {
-        return errors.New ( "process terminated early with error: " + err.Error() )
-      }
+  if r.supervisor == nil {
+    return nil
+  }
 
-      // Even though there was no error reported -- the process 
-      // mevertheless stopped early, which is an error in the 
-      // context of this test.
-      return errors.New ( "process self-terminated." )
+  // Don't just kill it! Give the router a chance to shut down.
+  exit, early, err := r.supervisor.Halt ( syscall.SIGTERM, r.halt_grace )
+  if err != nil {
+    return err
+  }
+  if early {
+    return errors.New ( "process terminated early: " + exit.String() )
   }
 
+  // This is the good case. It was not already dead when
+  // we came here, and we successfully halted it.
+  if exit.Forced {
+    ume ( "Router |%s| did not halt within %v, and was killed.", r.name, r.halt_grace )
+  } else {
+    umi ( r.verbose, "Router |%s| halted in %v.", r.name, exit.Shutdown )
+  }
   return nil
 }
-
-
-
-
-
@@ -0,0 +1,112 @@
+package router_network
+
+import ( "os"
+         "os/signal"
+         "sync"
+         "syscall"
+       )
+
+
+
+
+
+/*===================================================================
+
+  When mercury is interrupted, or panics, the routers and clients
+  of any network that is running must be halted on the way out.
+  Otherwise they keep running, holding their ports, and the next
+  test fails for reasons that have nothing to do with it.
+
+  A network is live from the moment Run() starts its processes
+  until Halt() has stopped them all.
+
+===================================================================*/
+
+var live_lock      sync.Mutex
+var live_networks  = make ( map [ * Router_network ] bool )
+var handle_signals sync.Once
+
+
+
+
+
+func ( rn * Router_network ) set_live ( live bool ) {
+  live_lock.Lock ( )
+  defer live_lock.Unlock ( )
+
+  if live {
+    live_networks [ rn ] = true
+    handle_signals.Do ( halt_on_signals )
+  } else {
+    delete ( live_networks, rn )
+  }
+}
+
+
+
+
+
+/*
+  On SIGINT, SIGTERM or SIGHUP, halt every live network, then exit
+  the way the signal would have. A second signal while that is
+  going on exits at once.
+*/
+func halt_on_signals ( ) {
+  signals := make ( chan os.Signal, 2 )
+  signal.Notify ( signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP )
+
+  go func ( ) {
+    sig := <- signals
+    ume ( "mercury: caught %v : halting all routers and clients. Again to exit now.", sig )
+
+    go func ( ) {
+      <- signals
+      ume ( "mercury: exiting without halting. Use 'mercury cleanup' to find what is left." )
+      os.Exit ( 128 + int ( sig.(syscall.Signal) ) )
+    } ( )
+
+    Halt_all ( )
+    os.Exit ( 128 + int ( sig.(syscall.Signal) ) )
+  } ( )
+}
+
+
+
+
+
+/*
+  Halt every network that is running.
+*/
+func Halt_all ( ) {
+  live_lock.Lock ( )
+  var networks [] * Router_network
+  for rn := range live_networks {
+    networks = append ( networks, rn )
+  }
+  live_lock.Unlock ( )
+
+  for _, rn := range networks {
+    rn.Halt ( )
+  }
+}
+
+
+
+
+
+/*
+  Defer this in main() so that a panic halts every network that
+  is running before it takes mercury down:
+
+    defer rn.Halt_all_on_panic ( )
+
+  Panics in other goroutines can't be caught, but the PID
+  registry still lets "mercury cleanup" find what they leave.
+*/
+func Halt_all_on_panic ( ) {
+  if p := recover ( ); p != nil {
+    ume ( "mercury: panic: %v : halting all routers and clients.", p )
+    Halt_all ( )
+    panic ( p )
+  }
+}
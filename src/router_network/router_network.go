@@ -1,13 +1,13 @@
 package router_network
 
-import ( "bufio"
-         "errors"
+import ( "errors"
          "fmt"
          "os"
          "os/exec"
+         "sort"
+         "strconv"
          "strings"
          "math/rand"
-         "strconv"
          "sync"
          "time"
 
@@ -22,6 +22,15 @@ var module_name = "router_network"
 var ume         = utils.M_error
 var umi         = utils.M_info
 
+// Unless the network or the router says otherwise.
+const Default_worker_threads = 30
+
+// How many times a router that can't have its ports gets new ones.
+const max_bind_retries = 3
+
+// How many networks this process has made.
+var n_networks  = 0
+
 
 
 
@@ -108,7 +117,7 @@ func new_version_with_roots ( name          string,
   // Calculate LD_LIBRARY_PATH for this version.
   DISPATCH_LIBRARY_PATH := v.dispatch_root + "/lib"
   PROTON_LIBRARY_PATH   := v.proton_root   + "/lib64"
-  v.Ld_library_path      = DISPATCH_LIBRARY_PATH +":"+ PROTON_LIBRARY_PATH
+  v.Ld_library_path      = Ld_library_path ( v.dispatch_root, v.proton_root )
   check_path ( "dispatch library path", DISPATCH_LIBRARY_PATH, true )
   check_path (   "proton library path",   PROTON_LIBRARY_PATH, true )
 
@@ -141,6 +150,18 @@ func new_version_with_roots ( name          string,
 
 
 
+/*
+  The LD_LIBRARY_PATH that a router from these install
+  roots runs with.
+*/
+func Ld_library_path ( dispatch_root, proton_root string ) ( string ) {
+  return dispatch_root + "/lib:" + proton_root + "/lib64"
+}
+
+
+
+
+
 type Router_network struct {
   Name                        string
   Running                     bool
@@ -149,6 +170,12 @@ type Router_network struct {
   events_path                 string
   log_path                    string
 
+  // The clients report to, and take commands from,
+  // the control server on this socket.
+  control_socket_path         string
+  control                   * client.Control_server
+  final_client_statuses    [] client.Client_status
+
   mercury_root                string
 
   /*
@@ -179,7 +206,64 @@ type Router_network struct {
   init_only                   bool
 
   Router_PIDs            []   int
-  previous_idle_time, previous_total_time uint64
+  status_check_stop           chan struct{}
+  ready_timeout               time.Duration
+  // How long receivers may go without reporting progress.
+  stall_timeout               time.Duration
+  // The slowest sender's msec between messages.
+  max_throttle                int
+  sample_interval             time.Duration
+
+  // Routers and clients that exited without being asked to.
+  exit_lock                   sync.Mutex
+  unexpected_exits         [] utils.Process_exit
+  abort_on_exit               bool
+
+  // Extra environment variables for every router and client.
+  environment                 utils.Environment
+  // Changes to every router's config.
+  config_edits             [] func ( * router.Config )
+  // Every router's log levels, by module.
+  log_levels                  map [ string ] string
+
+  worker_threads              int
+  // Routers that have their own number of worker threads.
+  router_worker_threads       map [ string ] int
+
+  // Every router's certificate comes from here.
+  tls_ca                    * utils.Certificate_authority
+  // The listeners that use TLS. See router.Tls_listeners .
+  tls_listeners            [] string
+
+  // What the routers authenticate their peers with.
+  sasl                      * utils.Sasl_config
+  sasl_mechanism              string
+  // The listeners that authenticate.
+  sasl_listeners           [] string
+
+  halt_grace                  time.Duration
+  // Held by Halt(), and by Run() while it starts a process.
+  halt_lock                   sync.Mutex
+  // Closed when Halt() begins, so that Run() stops starting
+  // things and stops waiting for them.
+  halting                     chan struct{}
+  halting_once                sync.Once
+  // How each router ended when it was halted.
+  router_halts             [] utils.Process_exit
+
+  // Every router's listener ports come from here.
+  ports                     * utils.Port_allocator
+
+  // Where every process that is started is recorded,
+  // so that it can be found if mercury dies.
+  pid_registry                string
+
+  // The routers and clients that are running, by pid. They are
+  // started and restarted from many goroutines, so the sampler
+  // learns of them from here rather than from the routers and
+  // clients themselves.
+  process_lock                sync.Mutex
+  running_processes           map [ int ] utils.Sampled_process
 
   start_time                  float64
 }
@@ -316,16 +400,33 @@ func ( rn * Router_network )  Build_clients ( ) {
 
 
 // Create a new router network.
-// Tell it how many worker threads each router should have,
-// and provide lots of paths.
+// Each router gets Default_worker_threads worker threads,
+// unless Set_worker_threads() says otherwise.
 func New_router_network ( name         string,
                           mercury_root string,
                           log_path     string ) * Router_network {
 
-  rn := & Router_network { Name         : name,
-                           log_path     : log_path,
-                           mercury_root : mercury_root }
+  rn := & Router_network { Name                  : name,
+                           log_path              : log_path,
+                           mercury_root          : mercury_root,
+                           environment           : make ( utils.Environment ),
+                           log_levels            : make ( map [ string ] string ),
+                           worker_threads        : Default_worker_threads,
+                           router_worker_threads : make ( map [ string ] int ),
+                           running_processes     : make ( map [ int ] utils.Sampled_process ),
+                           halting               : make ( chan struct{} ),
+                           ports                 : utils.New_port_allocator ( ) }
   rn.ticker_frequency = 10
+  rn.ready_timeout    = 60 * time.Second
+  rn.stall_timeout    = Default_stall_timeout
+  rn.sample_interval  = 5 * time.Second
+  rn.halt_grace       = Default_halt_grace
+
+  // Socket paths are limited to about a hundred characters,
+  // which results paths can easily exceed. So the socket
+  // lives in the temp dir, named for this process and network.
+  n_networks ++
+  rn.control_socket_path = fmt.Sprintf ( "%s/mercury_%d_%d.sock", os.TempDir(), os.Getpid(), n_networks )
 
   rn.start_time = utils.Timestamp()
 
@@ -472,21 +573,17 @@ func ( rn * Router_network ) add_router ( name         string,
   of the router code we are using, and they come in as part of the
   version structure.
 */
-  var console_port string
-  if name == "A" {
-    console_port = "5673"
-  } else {
-    console_port, _ = utils.Available_port ( )
-  }
-
-  client_port, _  := utils.Available_port ( )
-  router_port, _  := utils.Available_port ( )
-  edge_port, _    := utils.Available_port ( )
+  client_port  := rn.next_port ( )
+  console_port := rn.next_port ( )
+  router_port  := rn.next_port ( )
+  edge_port    := rn.next_port ( )
 
   version := rn.Get_version_from_name ( version_name )
 
-  // TODO -- pass this down from on high
-  worker_threads := 30
+  worker_threads := rn.worker_threads
+  if n, present := rn.router_worker_threads [ name ]; present {
+    worker_threads = n
+  }
 
   r := router.New_Router ( name,
                            version.Name,
@@ -504,6 +601,21 @@ func ( rn * Router_network ) add_router ( name         string,
                            router_port,
                            edge_port,
                            rn.verbose )
+  r.On_exit  = rn.process_exited
+  r.On_start = func ( pid int ) { rn.process_started ( "router", name, pid, version.Router_path ) }
+  r.Set_halt_grace ( rn.halt_grace )
+  for _, edit := range rn.config_edits {
+    r.Edit_config ( edit )
+  }
+  for module, level := range rn.log_levels {
+    r.Set_log_level ( module, level )
+  }
+  for name, value := range rn.environment {
+    r.Setenv ( name, value )
+  }
+  // The listener names and the mechanism have been checked already.
+  r.Use_tls ( rn.tls_ca, rn.tls_listeners )
+  r.Use_sasl ( rn.sasl_mechanism, rn.sasl, rn.sasl_listeners )
   rn.routers = append ( rn.routers, r )
 }
 
@@ -576,7 +688,7 @@ func ( rn * Router_network ) Add_receiver ( name               string,
                   config_path,
                   host,
                   rn.results_path,
-                  rn.events_path,
+                  rn.control_socket_path,
                   false, 
                   n_messages, 
                   message_length, 
@@ -603,7 +715,7 @@ func ( rn * Router_network ) Add_sender ( name               string,
                   config_path,
                   host,
                   rn.results_path,
-                  rn.events_path,
+                  rn.control_socket_path,
                   true, 
                   n_messages, 
                   message_length, 
@@ -621,7 +733,7 @@ func ( rn * Router_network ) add_client ( name               string,
                                           config_path        string,
                                           host               string,
                                           results_path       string,
-                                          events_path        string,
+                                          control_socket     string,
                                           sender             bool, 
                                           n_messages         int, 
                                           message_length     int, 
@@ -635,6 +747,9 @@ func ( rn * Router_network ) add_client ( name               string,
   if sender {
     operation = "send"
     rn.n_senders ++
+    if msec, err := strconv.Atoi ( throttle ); err == nil && msec > rn.max_throttle {
+      rn.max_throttle = msec
+    }
   } else {
     operation = "receive"
     throttle = "0" // Receivers do not get throttled.
@@ -657,7 +772,7 @@ func ( rn * Router_network ) add_client ( name               string,
                            config_path,
                            host,
                            results_path,
-                           events_path,
+                           control_socket,
                            operation,
                            r.Client_port ( ),
                            rn.client_dir + "/" + rn.client_names[0],
@@ -671,6 +786,17 @@ func ( rn * Router_network ) add_client ( name               string,
                            delay,
                            soak )
 
+  c.On_exit  = rn.process_exited
+  c.On_start = func ( pid int ) { rn.process_started ( "client", name, pid, c.Path ) }
+  for name, value := range rn.environment {
+    c.Setenv ( name, value )
+  }
+  if rn.tls_on ( "client" ) {
+    c.Use_tls ( rn.tls_ca.Cert_file )
+  }
+  if rn.sasl_on ( "client" ) {
+    c.Use_sasl ( rn.sasl_mechanism, rn.sasl.User, rn.sasl.Password )
+  }
   rn.clients = append ( rn.clients, c )
 }
 
@@ -749,17 +875,21 @@ func ( rn * Router_network ) Are_connected ( router_1_name string, router_2_name
   running, after new routers have been added.
   And uninitialized routers will be initialized, i.e. their config
   files will be created, so they will be ready to start.
+  The error is from the first router that could not be.
 */
-func ( rn * Router_network ) Init ( ) {
+func ( rn * Router_network ) Init ( ) ( error ) {
   for _, router := range rn.routers {
-    router.Init ( )
+    if err := router.Init ( ); err != nil {
+      return fmt.Errorf ( "network |%s| : %s", rn.Name, err.Error() )
+    }
   }
-  
+
   umi ( rn.verbose, "Network is initialized." )
   if rn.init_only {
     umi ( rn.verbose, "Init only is set : halting." )
     os.Exit ( 0 )
   }
+  return nil
 }
 
 
@@ -767,40 +897,80 @@ func ( rn * Router_network ) Init ( ) {
 
 
 /*
-  Start all routers in the network that are not already started.
+  Start all routers in the network that are not already started,
+  wait until they are ready, and then start any clients.
+  If any router is not ready within the network's ready timeout,
+  the clients are not started and the error names that router.
+
+  If Halt() is called meanwhile, Run() starts nothing more and
+  returns an error. Each process is started under the halt lock,
+  so Halt() never misses one that is just starting.
 */
-func ( rn * Router_network ) Run ( ) {
+func ( rn * Router_network ) Run ( ) ( error ) {
+  // From here on, Ctrl-C halts the network rather than
+  // leaving its processes running.
+  rn.set_live ( true )
 
-  router_run_count := 0
+  var started [] * router.Router
 
   for _, r := range rn.routers {
     if r.State() == "initialized" {
-      pid, _ := r.Run ( )
-      router_run_count ++
-      rn.Router_PIDs = append ( rn.Router_PIDs, pid )
+      err := rn.unless_halting ( func ( ) ( error ) {
+        pid, err := r.Run ( )
+        if err == nil {
+          rn.Router_PIDs = append ( rn.Router_PIDs, pid )
+        }
+        return err
+      } )
+      if err != nil {
+        return err
+      }
+      started = append ( started, r )
     }
   }
 
-  go rn.Router_status_check ( )
+  if rn.status_check_stop == nil {
+    rn.status_check_stop = make ( chan struct{} )
+    go rn.Sample_processes ( rn.status_check_stop )
+  }
 
-  if len(rn.clients) > 0 {
-    if router_run_count > 0 {
-      nap_time := 5
-      if rn.verbose {
-        fp ( os.Stdout, 
-             "    network info: sleeping %d seconds to wait for network stabilization.\n", 
-             nap_time )
+  if len ( started ) > 0 {
+    deadline := time.Now().Add ( rn.ready_timeout )
+    for _, r := range started {
+      if err := rn.wait_until_ready ( r, deadline ); err != nil {
+        ume ( "network |%s| is not ready: %s", rn.Name, err.Error() )
+        return err
       }
-      time.Sleep ( time.Duration(nap_time) * time.Second )
+    }
+    umi ( rn.verbose, "network |%s| : %d routers ready after %.3f seconds.", 
+          rn.Name, 
+          len ( started ),
+          rn.ready_timeout.Seconds() - time.Until ( deadline ).Seconds() )
+  }
+
+  if len(rn.clients) > 0 {
+    if err := rn.start_control_server ( ); err != nil {
+      ume ( "network |%s| can't start control server: %s", rn.Name, err.Error() )
+      return err
     }
 
     count := 0
     for _, c := range rn.clients {
-      c.Run ( )
+      err := rn.unless_halting ( func ( ) ( error ) {
+        c.Run ( )
+        return nil
+      } )
+      if err != nil {
+        return err
+      }
 
       // TODO replace this with more intelligence in senders.
       // Inter-client sleep 
-      time.Sleep ( 50 * time.Millisecond )
+      select {
+        case <- rn.halting :
+          return rn.halted_error ( )
+        case <- time.After ( 50 * time.Millisecond ) :
+      }
       count ++
       if 0 == (count % 20) {
         fp ( os.Stdout, "started %d clients.\n", count )
@@ -809,132 +979,171 @@ func ( rn * Router_network ) Run ( ) {
   }
 
   rn.Running = true
+  return nil
 }
 
 
 
 
 
-func ( rn * Router_network ) Router_status_check ( ) ( ) {
-
-  for {
-    time.Sleep ( 5 * time.Second )
-    for _, pid := range rn.Router_PIDs {
-      // TODO -- figure out how to read cpu
-      // fp ( os.Stdout, "MDEBUG Read CPU: \n" )
-      // rn.read_CPU ( )
-      // rss := utils.Memory_usage ( pid )
-      //fp ( os.Stdout, "MDEBUG router %d has RSS == %d\n", pid, rss )
-      // Just use:  show_router_mem.sh   args == pid
-      command_name := fmt.Sprintf ( "show_router_mem.sh" )
-      args         := fmt.Sprintf ( "%d", pid )
-      args_list    := strings.Fields ( args )
-
-      cmd      := exec.Command ( command_name, args_list... )
-      out, err := cmd.Output ( )
-      if err != nil {
-        fp ( os.Stdout, "Router_status_check error: |%s|\n", err.Error() )
-      } else {
-        //rss, err := strconv.Atoi ( string(out) )
-        var rss int
-        fmt.Sscanf ( string(out), "%d", & rss )
-        if err == nil {
-          // fp ( os.Stdout, "router %d rss == %d\n", pid, rss )
-          // umi ( true, "router %d rss == %d\n", pid, rss )
-          utils.M_info_seconds ( rn.start_time, "router %d rss == %d\n", pid, rss )
-        } else {
-          fp ( os.Stdout, "Router_status_check strconv error: |%s|\n", err.Error() )
-        }
-      }
-    }
+/*
+  Call start with the halt lock held, unless the network is
+  halting, in which case say so instead.
+*/
+func ( rn * Router_network ) unless_halting ( start func ( ) ( error ) ) ( error ) {
+  rn.halt_lock.Lock ( )
+  defer rn.halt_lock.Unlock ( )
+
+  select {
+    case <- rn.halting :
+      return rn.halted_error ( )
+    default :
   }
+  return start ( )
 }
 
 
 
 
 
-func ( rn * Router_network ) Client_port ( target_router_name string ) ( client_port string ) {
-  r := rn.get_router_by_name ( target_router_name )
-  return r.Client_port ( )
+func ( rn * Router_network ) halted_error ( ) ( error ) {
+  return fmt.Errorf ( "network |%s| is halting", rn.Name )
 }
 
 
 
 
 
-func halt_router ( wg * sync.WaitGroup, r * router.Router ) {
-  defer wg.Done()
-  err := r.Halt ( )
-  if err != nil {
-    ume ( "Router %s halting error: %s", r.Name(), err.Error() )
+/*
+  Wait until the router is ready. If it can't have some of its
+  ports, give it new ones and try again, a few times.
+*/
+func ( rn * Router_network ) wait_until_ready ( r * router.Router, deadline time.Time ) ( error ) {
+  err := r.Wait_until_ready ( deadline, rn.halting )
+
+  for attempt := 1; attempt <= max_bind_retries; attempt ++ {
+    bind_error, ok := err.(* router.Bind_error)
+    if ! ok {
+      break
+    }
+    ume ( "network |%s| : %s . Trying new ports.", rn.Name, bind_error.Error() )
+
+    // Rebinding restarts routers, so Halt() must wait for it.
+    var restarted [] * router.Router
+    rebind_err := rn.unless_halting ( func ( ) ( error ) {
+      var err error
+      restarted, err = rn.rebind ( r, bind_error.Ports )
+      return err
+    } )
+    if rebind_err != nil {
+      return rebind_err
+    }
+    // Starting again takes as long as starting did.
+    deadline = time.Now().Add ( rn.ready_timeout )
+    for _, other := range restarted {
+      if other != r {
+        if other_err := other.Wait_until_ready ( deadline, rn.halting ); other_err != nil {
+          return other_err
+        }
+      }
+    }
+    err = r.Wait_until_ready ( deadline, rn.halting )
   }
+
+  return err
 }
 
 
 
 
 
-func (rn * Router_network) Halt_router ( router_name string ) ( error ) {
-  r := rn.get_router_by_name ( router_name )
-  if r == nil {
-    return errors.New ( "No such router." )
-  }
+/*
+  How long receivers may go without any progress, unless
+  Set_stall_timeout() says otherwise.
+*/
+const Default_stall_timeout = 30 * time.Second
 
-  go r.Halt()
-  return nil
+
+
+
+
+/*
+  How long Wait_for_receivers() lets the clients go without
+  reporting any progress before it decides that the network
+  has stalled. Zero means it never decides that.
+*/
+func ( rn * Router_network ) Set_stall_timeout ( timeout time.Duration ) {
+  rn.stall_timeout = timeout
 }
 
 
 
 
 
-func (rn * Router_network) Halt_and_restart_router ( router_name string, pause int ) ( error ) {
-  r := rn.get_router_by_name ( router_name )
-  if r == nil {
-    return errors.New ( "No such router." )
+/*
+  The stall timeout, but never less than a few of the slowest
+  sender's gaps between messages, so that a heavily throttled
+  run is not taken for a stalled one.
+*/
+func ( rn * Router_network ) stall_limit ( ) ( time.Duration ) {
+  if rn.stall_timeout <= 0 {
+    return 0
   }
-
-  r.Halt()
-  if rn.verbose {
-    umi ( rn.verbose, "Halt_and_restart_router: Pausing %d seconds.", pause )
+  gaps := 5 * time.Duration ( rn.max_throttle ) * time.Millisecond
+  if gaps > rn.stall_timeout {
+    return gaps
   }
-  time.Sleep ( time.Duration(pause) * time.Second )
+  return rn.stall_timeout
+}
 
-  r.Run ( )
 
-  return nil
+
+
+
+/*
+  How long Run() will wait for newly started routers to
+  open all their listeners and connectors.
+*/
+func ( rn * Router_network ) Set_ready_timeout ( timeout time.Duration ) {
+  rn.ready_timeout = timeout
 }
 
 
 
 
 
-func (rn * Router_network) Get_edge_list ( ) ( edge_list [] string) {
-  for _, r := range rn.routers {
-    if r.Type() == "edge" {
-      edge_list = append ( edge_list, r.Name() )
-    }
+func ( rn * Router_network ) start_control_server ( ) ( error ) {
+  if rn.control != nil {
+    return nil
   }
 
-  return edge_list
-}
+  log_file_name := ""
+  if rn.events_path != "" {
+    log_file_name = rn.events_path + "/control.log"
+  }
 
+  var err error
+  rn.control, err = client.New_control_server ( rn.control_socket_path, log_file_name, rn.verbose )
+  return err
+}
 
 
 
 
-func halt_client ( wg * sync.WaitGroup, c * client.Client ) {
-  defer wg.Done()
 
-  /*
-   This looks like it is not actually an error.
-  err := c.Halt ( )
-  if err != nil && err.Error() != "process self-terminated." {
-    ume ( "Client |%s| halting error: %s", c.Name, err.Error() )
+/*
+  Tell all clients to start sending.
+  Clients wait for this command so that all of them
+  start at the same time, after the network is up.
+*/
+func ( rn * Router_network ) Start_sending ( ) ( error ) {
+  if rn.control == nil {
+    return errors.New ( "Start_sending: no clients are running." )
   }
-  */
-  c.Halt ( )
+
+  umi ( rn.verbose, "start_sending at %f", utils.Timestamp() )
+  rn.control.Broadcast ( "start" )
+  return nil
 }
 
 
@@ -942,166 +1151,1027 @@ func halt_client ( wg * sync.WaitGroup, c * client.Client ) {
 
 
 /*
-  It takes a while to halt each router, so use a workgroup of
-  goroutines to do them all in parallel.
+  Tell all clients to write out the data they have collected,
+  and then exit.
 */
-func ( rn * Router_network ) Halt ( ) {
-  var wg sync.WaitGroup
-
-  for _, c := range rn.clients {
-    wg.Add ( 1 )
-    go halt_client ( & wg, c )
-  }
-
-  for _, r := range rn.routers {
-    if r.Is_not_halted() {
-      wg.Add ( 1 )
-      go halt_router ( & wg, r )
-    }
+func ( rn * Router_network ) Dump_data ( ) ( error ) {
+  if rn.control == nil {
+    return errors.New ( "Dump_data: no clients are running." )
   }
 
-  wg.Wait()
-  rn.Running = false
+  rn.control.Broadcast ( "dump" )
+  return nil
 }
 
 
 
 
-func ( rn * Router_network ) Display_routers ( ) {
-  for index, r := range rn.routers {
-    umi ( rn.verbose, "router %d: %s %d %s", index, r.Name(), r.Pid, r.State() )
+
+func ( rn * Router_network ) client_names_for ( operation string ) ( names [] string ) {
+  for _, c := range rn.clients {
+    if operation == "" || c.Operation == operation {
+      names = append ( names, c.Name )
+    }
   }
+  return names
 }
 
 
 
-func ( rn * Router_network ) Halt_first_edge ( ) error {
-  
-  for _, r := range rn.routers {
-    if "edge" == r.Type() {
-      if r.State() == "running" {
-        if rn.verbose {
-          umi ( rn.verbose, "halting router |%s|", r.Name() )
-        }
-        err := r.Halt ( )
-        if err != nil {
-          umi ( rn.verbose, "error halting router |%s| : |%s|\n", r.Name(), err.Error() )
-        }
-        return err
-      }
-    }
+
+
+/*
+  Wait until every receiver has reported that it has received
+  all of its messages. This fails as soon as a receiver goes
+  away without finishing, if no client reports any progress for
+  the stall timeout, or if 'timeout' passes. A timeout of zero
+  means wait as long as the clients keep making progress.
+*/
+func ( rn * Router_network ) Wait_for_receivers ( timeout time.Duration ) ( error ) {
+  if rn.control == nil {
+    return errors.New ( "Wait_for_receivers: no clients are running." )
   }
 
-  return errors.New ( "Router_network.Halt_first_edge error : Could not find an edge router to halt." )
+  return rn.control.Wait_for ( rn.client_names_for ( "receive" ),
+                               func ( s * client.Client_status ) bool { return s.Done },
+                               timeout,
+                               rn.stall_limit ( ),
+                               func ( n_done, n_total int ) {
+                                 umi ( rn.verbose, "%d of %d receivers done.", n_done, n_total )
+                               } )
 }
 
 
 
 
 
-func ( rn * Router_network ) get_router_by_name ( target_name string ) * router.Router {
-  for _, router := range rn.routers {
-    if router.Name() == target_name {
-      return router
-    }
+/*
+  After Dump_data(), wait until every client has
+  reported that it has written out its data.
+*/
+func ( rn * Router_network ) Wait_for_dumps ( timeout time.Duration ) ( error ) {
+  if rn.control == nil {
+    return errors.New ( "Wait_for_dumps: no clients are running." )
   }
 
-  ume ( "router_network: get_router_by_name: no such router |%s|", target_name )
-  fp ( os.Stdout, "    routers are:\n" )
-  for _, router := range rn.routers {
-    fp ( os.Stdout, "      %s\n", router.Name() )
-  }
-  os.Exit ( 1 )
-  return nil
+  return rn.control.Wait_for ( rn.client_names_for ( "" ),
+                               func ( s * client.Client_status ) bool { return s.Dumped },
+                               timeout,
+                               0,
+                               nil )
 }
 
 
 
 
 
-func ( rn * Router_network ) How_many_interior_routers ( ) ( int ) {
-  count := 0
-
-  for _, r := range rn.routers {
-    if r.Is_interior() {
-      count ++
-    }
+/*
+  The latest reports from all the clients. After the network
+  has halted, these are the reports as they stood at the halt.
+*/
+func ( rn * Router_network ) Client_statuses ( ) ( [] client.Client_status ) {
+  if rn.control == nil {
+    return rn.final_client_statuses
   }
-
-  return count
+  return rn.control.Statuses ( )
 }
 
 
 
 
 
-func ( rn * Router_network ) Get_nth_interior_router_name ( index int ) ( string ) {
-  count := 0
+/*
+  Until the network halts, sample every router and client from
+  /proc , once every sample interval, into
+    results_path/process_metrics
+  in the columns of utils.Proc_metrics_header . Without a results
+  path, or with an interval of zero, nothing is sampled.
+*/
+func ( rn * Router_network ) Sample_processes ( stop chan struct{} ) ( ) {
+  if rn.results_path == "" || rn.sample_interval <= 0 {
+    return
+  }
 
-  for _, r := range rn.routers {
-    if r.Is_interior() {
-      if count == index {
-        return r.Name()
-      }
-      count ++
-    }
+  err := utils.Sample_processes ( rn.results_path + "/process_metrics",
+                                  rn.sample_interval,
+                                  rn.start_time,
+                                  rn.running,
+                                  stop )
+  if err != nil {
+    ume ( "network |%s| : can't record process metrics: %s", rn.Name, err.Error() )
   }
-  return ""
 }
 
 
 
 
 
-func element_of ( target_str string, strings [] string ) ( bool ) {
-  for _, str := range strings {
-    if target_str == str {
-      return true
-    }
+/*
+  Start every router and client, including those added later,
+  with this environment variable set. Use this for things like
+  tracing switches and malloc tuning. Takes effect the next
+  time each process is run.
+*/
+func ( rn * Router_network ) Setenv ( name, value string ) {
+  rn.environment [ name ] = value
+  for _, r := range rn.routers {
+    r.Setenv ( name, value )
+  }
+  for _, c := range rn.clients {
+    c.Setenv ( name, value )
   }
-
-  return false
 }
 
 
 
 
 
-func union ( list_1, list_2 [] string ) ( [] string ) {
+/*
+  How long each router is given to shut down, unless
+  Set_halt_grace() says otherwise.
+*/
+const Default_halt_grace = router.Default_halt_grace
 
-  var union_list [] string
 
-  for _, str := range list_1 {
-    if ! element_of ( str, union_list ) {
-      union_list = append ( union_list, str )
-    }
-  }
 
-  for _, str := range list_2 {
-    if ! element_of ( str, union_list ) {
-      union_list = append ( union_list, str )
-    }
-  }
 
-  return union_list 
+
+/*
+  How long each router is given to shut down, when the network
+  is halted, before it is killed.
+*/
+func ( rn * Router_network ) Set_halt_grace ( grace time.Duration ) {
+  rn.halt_grace = grace
+  for _, r := range rn.routers {
+    r.Set_halt_grace ( grace )
+  }
 }
 
 
 
 
 
-func print_list ( label string, list [] string ) {
-  fp ( os.Stdout, "%s\n", label )
-  for _, x := range list {
-    fp ( os.Stdout, "    %s\n", x )
+/*
+  A port from the network's own blocks. If none can be had,
+  fall back on one that is free right now, and hope.
+*/
+func ( rn * Router_network ) next_port ( ) ( string ) {
+  port, err := rn.ports.Next ( )
+  if err != nil {
+    ume ( "network |%s| : %s", rn.Name, err.Error() )
+    port, _ = utils.Available_port ( )
   }
+  return port
 }
 
 
 
 
 
-func ( rn * Router_network ) Is_the_network_connected ( ) ( bool ) {
+/*
+  The router could not listen on some of its ports. Give it new
+  ones, and make every router that connects to those ports, and
+  every client that has not started yet, use the new ones too.
+  Then run the router again, along with any connecting routers
+  that were already running. The routers that were run again
+  are returned.
+*/
+func ( rn * Router_network ) rebind ( r * router.Router, ports [] string ) ( [] * router.Router, error ) {
+  changed := make ( map [ * router.Router ] bool )
+  for _, old_port := range ports {
+    new_port, err := rn.ports.Next ( )
+    if err != nil {
+      return nil, err
+    }
+    umi ( rn.verbose, "network |%s| : router |%s| moves from port %s to %s", rn.Name, r.Name(), old_port, new_port )
+    for _, other := range rn.routers {
+      if other.Replace_port ( old_port, new_port ) {
+        changed [ other ] = true
+      }
+    }
+    for _, c := range rn.clients {
+      if c.Port == old_port && ! c.Is_running ( ) {
+        c.Port = new_port
+      }
+    }
+  }
+
+  var restart [] * router.Router
+  for _, other := range rn.routers {
+    if changed [ other ] && ( other == r || other.State() == "running" ) {
+      restart = append ( restart, other )
+    }
+  }
+
+  for _, other := range restart {
+    other.Halt ( )
+    // If it died of the port it couldn't have, that's not a crash.
+    if exit := other.Exit(); exit != nil && ! exit.Expected {
+      rn.forget_exit ( exit.Pid )
+    }
+  }
+  for other := range changed {
+    if err := other.Rewrite_config ( ); err != nil {
+      return nil, err
+    }
+  }
+  for _, other := range restart {
+    if _, err := other.Run ( ); err != nil {
+      return nil, err
+    }
+  }
+
+  return restart, nil
+}
+
+
+
+
+
+func ( rn * Router_network ) forget_exit ( pid int ) {
+  rn.exit_lock.Lock ( )
+  defer rn.exit_lock.Unlock ( )
+
+  var kept [] utils.Process_exit
+  for _, exit := range rn.unexpected_exits {
+    if exit.Pid != pid {
+      kept = append ( kept, exit )
+    }
+  }
+  rn.unexpected_exits = kept
+}
+
+
+
+
+
+/*
+  Record every router and client that is started in this file,
+  so that a later "mercury cleanup" can find any that are left
+  running if mercury dies without halting them.
+*/
+func ( rn * Router_network ) Set_pid_registry ( file_name string ) {
+  rn.pid_registry = file_name
+}
+
+
+
+
+
+/*
+  Called by every router and client that has just started.
+*/
+func ( rn * Router_network ) process_started ( kind, name string, pid int, executable string ) {
+  rn.process_lock.Lock ( )
+  rn.running_processes [ pid ] = utils.Sampled_process { Kind : kind, Name : name, Pid : pid }
+  rn.process_lock.Unlock ( )
+
+  rn.register_process ( kind, name, pid, executable )
+}
+
+
+
+
+
+/*
+  The routers and clients that are running now,
+  routers first, each kind in order of name.
+*/
+func ( rn * Router_network ) running ( ) ( [] utils.Sampled_process ) {
+  rn.process_lock.Lock ( )
+  defer rn.process_lock.Unlock ( )
+
+  var list [] utils.Sampled_process
+  for _, p := range rn.running_processes {
+    list = append ( list, p )
+  }
+  sort.Slice ( list, func ( i, j int ) bool {
+                       if list[i].Kind != list[j].Kind {
+                         return list[i].Kind == "router"
+                       }
+                       return list[i].Name < list[j].Name
+                     } )
+  return list
+}
+
+
+
+
+
+func ( rn * Router_network ) register_process ( kind, name string, pid int, executable string ) {
+  if rn.pid_registry == "" {
+    return
+  }
+  if err := utils.Register_process ( rn.pid_registry, kind, name, pid, executable ); err != nil {
+    ume ( "network |%s| can't register %s |%s| : %s", rn.Name, kind, name, err.Error() )
+  }
+}
+
+
+
+
+
+/*
+  Set what one Dispatch module logs, on every router, including
+  those added later. See router.Set_log_level() . For example
+
+    rn.Set_log_level ( "DEFAULT", "none" )         // performance runs
+    rn.Set_log_level ( "DEFAULT", "trace+" )       // debugging
+    rn.Set_log_level ( "ROUTER_CORE", "debug+" )
+*/
+func ( rn * Router_network ) Set_log_level ( module, level string ) {
+  if module == "" {
+    module = "DEFAULT"
+  }
+  if level == "" {
+    delete ( rn.log_levels, module )
+  } else {
+    rn.log_levels [ module ] = level
+  }
+  for _, r := range rn.routers {
+    r.Set_log_level ( module, level )
+  }
+}
+
+
+
+
+
+/*
+  How many worker threads every router has, including those added
+  later, except for the ones given their own number with
+  Set_router_worker_threads() .
+*/
+func ( rn * Router_network ) Set_worker_threads ( n int ) {
+  rn.worker_threads = n
+  for _, r := range rn.routers {
+    if _, present := rn.router_worker_threads [ r.Name() ]; ! present {
+      r.Set_worker_threads ( n )
+    }
+  }
+}
+
+
+
+
+
+/*
+  Give one router its own number of worker threads.
+  It may be set before the router is added.
+*/
+func ( rn * Router_network ) Set_router_worker_threads ( router_name string, n int ) {
+  rn.router_worker_threads [ router_name ] = n
+  for _, r := range rn.routers {
+    if r.Name() == router_name {
+      r.Set_worker_threads ( n )
+    }
+  }
+}
+
+
+
+
+
+/*
+  Use TLS on the named listeners of every router, including those
+  added later -- "client", "inter-router", or "edge" -- and on the
+  connectors and clients that connect to them. A CA for the
+  network is made in dir, and issues each router its certificate
+  there when its config is written. No names turns TLS off again.
+  Takes effect the next time each router and client is run.
+*/
+func ( rn * Router_network ) Enable_tls ( dir string, listeners [] string ) ( error ) {
+  for _, name := range listeners {
+    if err := router.Check_tls_listener ( name ); err != nil {
+      return err
+    }
+  }
+
+  rn.tls_ca        = nil
+  rn.tls_listeners = nil
+  if len ( listeners ) > 0 {
+    ca, err := utils.New_certificate_authority ( dir, "mercury test CA " + rn.Name )
+    if err != nil {
+      return fmt.Errorf ( "network |%s| can't make a CA : %s", rn.Name, err.Error() )
+    }
+    rn.tls_ca        = ca
+    rn.tls_listeners = append ( [] string { }, listeners ... )
+  }
+
+  for _, r := range rn.routers {
+    r.Use_tls ( rn.tls_ca, rn.tls_listeners )
+  }
+  for _, c := range rn.clients {
+    if rn.tls_on ( "client" ) {
+      c.Use_tls ( rn.tls_ca.Cert_file )
+    } else {
+      c.Use_tls ( "" )
+    }
+  }
+  return nil
+}
+
+
+
+
+
+func ( rn * Router_network ) tls_on ( listener string ) ( bool ) {
+  return rn.tls_ca != nil && element_of ( listener, rn.tls_listeners )
+}
+
+
+
+
+
+/*
+  Have the named listeners of every router, including those added
+  later -- "client", "inter-router", or "edge" -- authenticate
+  their peers with the SASL mechanism, e.g. "SCRAM-SHA-1", and
+  have the connectors and clients that connect to them log in.
+  The SASL config and its sasldb, with one user for everything
+  in the network, are made in dir. No names turns authentication
+  off again. Takes effect the next time each router and client
+  is run.
+*/
+func ( rn * Router_network ) Enable_sasl ( dir, mechanism string, listeners [] string ) ( error ) {
+  for _, name := range listeners {
+    if err := router.Check_sasl_listener ( name ); err != nil {
+      return err
+    }
+  }
+
+  rn.sasl           = nil
+  rn.sasl_mechanism = ""
+  rn.sasl_listeners = nil
+  if len ( listeners ) > 0 {
+    if err := router.Check_sasl_mechanism ( mechanism ); err != nil {
+      return err
+    }
+    sasl, err := utils.New_sasl_config ( dir, "qdrouterd", [] string { mechanism }, "mercury" )
+    if err != nil {
+      return fmt.Errorf ( "network |%s| : %s", rn.Name, err.Error() )
+    }
+    rn.sasl           = sasl
+    rn.sasl_mechanism = mechanism
+    rn.sasl_listeners = append ( [] string { }, listeners ... )
+  }
+
+  for _, r := range rn.routers {
+    r.Use_sasl ( rn.sasl_mechanism, rn.sasl, rn.sasl_listeners )
+  }
+  for _, c := range rn.clients {
+    if rn.sasl_on ( "client" ) {
+      c.Use_sasl ( rn.sasl_mechanism, rn.sasl.User, rn.sasl.Password )
+    } else {
+      c.Use_sasl ( "", "", "" )
+    }
+  }
+  return nil
+}
+
+
+
+
+
+func ( rn * Router_network ) sasl_on ( listener string ) ( bool ) {
+  return rn.sasl != nil && element_of ( listener, rn.sasl_listeners )
+}
+
+
+
+
+
+/*
+  The log levels that every router has been given, by module.
+  Modules that are not here log at the router's own defaults.
+*/
+func ( rn * Router_network ) Log_levels ( ) ( map [ string ] string ) {
+  levels := make ( map [ string ] string )
+  for module, level := range rn.log_levels {
+    levels [ module ] = level
+  }
+  return levels
+}
+
+
+
+
+
+/*
+  Change the config of every router, including those added
+  later. See router.Edit_config() . Like a router's own edits,
+  these are applied in the order they are made.
+*/
+func ( rn * Router_network ) Edit_router_configs ( edit func ( * router.Config ) ) {
+  rn.config_edits = append ( rn.config_edits, edit )
+  for _, r := range rn.routers {
+    r.Edit_config ( edit )
+  }
+}
+
+
+
+
+
+/*
+  Start only the named router with this environment variable set.
+*/
+func ( rn * Router_network ) Setenv_router ( router_name, name, value string ) {
+  rn.get_router_by_name ( router_name ).Setenv ( name, value )
+}
+
+
+
+
+
+/*
+  How often Run() samples the routers and clients.
+  Zero turns sampling off.
+*/
+func ( rn * Router_network ) Set_sample_interval ( interval time.Duration ) {
+  rn.sample_interval = interval
+}
+
+
+
+
+
+/*
+  Stop waiting for the clients as soon as any router or client
+  exits unexpectedly, instead of carrying on without it.
+*/
+func ( rn * Router_network ) Abort_on_exit ( val bool ) {
+  rn.abort_on_exit = val
+}
+
+
+
+
+
+/*
+  Called by each router's and client's supervisor when its
+  process exits. Routers should never stop by themselves,
+  but clients do, once they have sent or received everything
+  and dumped their data. Any other exit is reported right away,
+  with the end of the process's log and stderr, and recorded in
+  results_path/process_exits .
+*/
+func ( rn * Router_network ) process_exited ( exit utils.Process_exit ) {
+  rn.process_lock.Lock ( )
+  delete ( rn.running_processes, exit.Pid )
+  rn.process_lock.Unlock ( )
+
+  if exit.Expected || ( exit.Kind == "client" && exit.Code == 0 ) {
+    return
+  }
+
+  rn.exit_lock.Lock ( )
+  defer rn.exit_lock.Unlock ( )
+
+  rn.unexpected_exits = append ( rn.unexpected_exits, exit )
+  seconds := utils.Timestamp() - rn.start_time
+
+  ume ( "network |%s| : %s at %.3f seconds.", rn.Name, exit.String(), seconds )
+  for _, line := range exit.Log_tail {
+    fp ( os.Stderr, "    %s\n", line )
+  }
+  if len ( exit.Stderr_tail ) > 0 {
+    fp ( os.Stderr, "  stderr:\n" )
+    for _, line := range exit.Stderr_tail {
+      fp ( os.Stderr, "    %s\n", line )
+    }
+  }
+
+  if rn.results_path != "" {
+    f, err := os.OpenFile ( rn.results_path + "/process_exits", os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644 )
+    if err == nil {
+      fp ( f, "%.3f %s\n", seconds, exit.String() )
+      for _, line := range exit.Log_tail {
+        fp ( f, "    %s\n", line )
+      }
+      if len ( exit.Stderr_tail ) > 0 {
+        fp ( f, "  stderr:\n" )
+        for _, line := range exit.Stderr_tail {
+          fp ( f, "    %s\n", line )
+        }
+      }
+      f.Close ( )
+    }
+  }
+
+  if rn.abort_on_exit && rn.control != nil {
+    rn.control.Abort ( exit.String() )
+  }
+}
+
+
+
+
+
+/*
+  Every router and client that has exited without being asked to.
+*/
+func ( rn * Router_network ) Unexpected_exits ( ) ( [] utils.Process_exit ) {
+  rn.exit_lock.Lock ( )
+  defer rn.exit_lock.Unlock ( )
+  return append ( [] utils.Process_exit { }, rn.unexpected_exits ... )
+}
+
+
+
+
+
+func ( rn * Router_network ) Client_port ( target_router_name string ) ( client_port string ) {
+  r := rn.get_router_by_name ( target_router_name )
+  return r.Client_port ( )
+}
+
+
+
+
+
+func halt_router ( wg * sync.WaitGroup, r * router.Router ) {
+  defer wg.Done()
+  err := r.Halt ( )
+  if err != nil {
+    ume ( "Router %s halting error: %s", r.Name(), err.Error() )
+  }
+}
+
+
+
+
+
+func (rn * Router_network) Halt_router ( router_name string ) ( error ) {
+  r := rn.get_router_by_name ( router_name )
+  if r == nil {
+    return errors.New ( "No such router." )
+  }
+
+  go r.Halt()
+  return nil
+}
+
+
+
+
+
+func (rn * Router_network) Halt_and_restart_router ( router_name string, pause int ) ( error ) {
+  r := rn.get_router_by_name ( router_name )
+  if r == nil {
+    return errors.New ( "No such router." )
+  }
+
+  r.Halt()
+  if rn.verbose {
+    umi ( rn.verbose, "Halt_and_restart_router: Pausing %d seconds.", pause )
+  }
+  time.Sleep ( time.Duration(pause) * time.Second )
+
+  r.Run ( )
+
+  return nil
+}
+
+
+
+
+
+func (rn * Router_network) Get_edge_list ( ) ( edge_list [] string) {
+  for _, r := range rn.routers {
+    if r.Type() == "edge" {
+      edge_list = append ( edge_list, r.Name() )
+    }
+  }
+
+  return edge_list
+}
+
+
+
+
+
+func halt_client ( wg * sync.WaitGroup, c * client.Client ) {
+  defer wg.Done()
+
+  /*
+   This looks like it is not actually an error.
+  err := c.Halt ( )
+  if err != nil && err.Error() != "process self-terminated." {
+    ume ( "Client |%s| halting error: %s", c.Name, err.Error() )
+  }
+  */
+  c.Halt ( )
+}
+
+
+
+
+
+/*
+  It takes a while to halt each router, so use a workgroup of
+  goroutines to do them all in parallel. This returns only when
+  every router has exited, so that the next run can have their
+  ports. How each one went is written to results_path/router_halts .
+*/
+func ( rn * Router_network ) Halt ( ) {
+  // Tell Run() to stop, if it is still going.
+  rn.halting_once.Do ( func ( ) { close ( rn.halting ) } )
+
+  // A signal can arrive while the network is already halting.
+  rn.halt_lock.Lock ( )
+  defer rn.halt_lock.Unlock ( )
+
+  var wg sync.WaitGroup
+
+  for _, c := range rn.clients {
+    wg.Add ( 1 )
+    go halt_client ( & wg, c )
+  }
+
+  for _, r := range rn.routers {
+    if r.Is_not_halted() {
+      wg.Add ( 1 )
+      go halt_router ( & wg, r )
+    }
+  }
+
+  wg.Wait()
+  rn.record_router_halts ( )
+
+  if rn.status_check_stop != nil {
+    close ( rn.status_check_stop )
+    rn.status_check_stop = nil
+  }
+
+  rn.exit_lock.Lock ( )
+  if rn.control != nil {
+    rn.final_client_statuses = rn.control.Statuses ( )
+    rn.control.Close ( )
+    rn.control = nil
+    rn.record_connection_setup ( )
+  }
+  rn.exit_lock.Unlock ( )
+
+  rn.Running = false
+  // The routers are gone, so their ports are free.
+  rn.ports.Release ( )
+  rn.set_live ( false )
+}
+
+
+
+
+/*
+  Write how long each client's connection took to open to
+  results_path/connection_setup , one line per client that
+  said:
+    <client> <msec>
+*/
+func ( rn * Router_network ) record_connection_setup ( ) {
+  if rn.results_path == "" {
+    return
+  }
+  f, err := os.Create ( rn.results_path + "/connection_setup" )
+  if err != nil {
+    ume ( "router_network: can't record connection setup : %s", err.Error() )
+    return
+  }
+  for _, status := range rn.final_client_statuses {
+    if status.Connect_msec > 0 {
+      fp ( f, "%s %.3f\n", status.Name, status.Connect_msec )
+    }
+  }
+  f.Close ( )
+}
+
+
+
+
+
+/*
+  Note how every router that was halted went, and complain
+  about the ones that had to be killed.
+*/
+func ( rn * Router_network ) record_router_halts ( ) {
+  var f * os.File
+  if rn.results_path != "" {
+    f, _ = os.OpenFile ( rn.results_path + "/router_halts", os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644 )
+  }
+
+  for _, r := range rn.routers {
+    exit := r.Exit ( )
+    if exit == nil || ! exit.Expected || rn.was_recorded ( exit ) {
+      continue
+    }
+    rn.router_halts = append ( rn.router_halts, * exit )
+
+    how := fmt.Sprintf ( "code %d", exit.Code )
+    if exit.Signal != "" {
+      how = "signal " + exit.Signal
+    }
+    if exit.Forced {
+      how += " forced"
+    }
+    if f != nil {
+      fp ( f, "%s %d %.3f %s\n", exit.Name, exit.Pid, exit.Shutdown.Seconds(), how )
+    }
+  }
+
+  if f != nil {
+    f.Close ( )
+  }
+
+  if forced := rn.Forced_halts ( ); len ( forced ) > 0 {
+    var names [] string
+    for _, exit := range forced {
+      names = append ( names, exit.Name )
+    }
+    ume ( "network |%s| : routers had to be killed: %s", rn.Name, strings.Join ( names, " " ) )
+  }
+}
+
+
+
+
+
+func ( rn * Router_network ) was_recorded ( exit * utils.Process_exit ) ( bool ) {
+  for _, recorded := range rn.router_halts {
+    if recorded.Pid == exit.Pid && recorded.Time.Equal ( exit.Time ) {
+      return true
+    }
+  }
+  return false
+}
+
+
+
+
+
+/*
+  How each router ended when it was halted, with how long it took.
+*/
+func ( rn * Router_network ) Router_halts ( ) ( [] utils.Process_exit ) {
+  return rn.router_halts
+}
+
+
+
+
+
+/*
+  The routers that did not shut down within the halt grace
+  period, and had to be killed.
+*/
+func ( rn * Router_network ) Forced_halts ( ) ( [] utils.Process_exit ) {
+  var forced [] utils.Process_exit
+  for _, exit := range rn.router_halts {
+    if exit.Forced {
+      forced = append ( forced, exit )
+    }
+  }
+  return forced
+}
+
+
+
+
+
+func ( rn * Router_network ) Display_routers ( ) {
+  for index, r := range rn.routers {
+    umi ( rn.verbose, "router %d: %s %d %s", index, r.Name(), r.Pid, r.State() )
+  }
+}
+
+
+
+func ( rn * Router_network ) Halt_first_edge ( ) error {
+  
+  for _, r := range rn.routers {
+    if "edge" == r.Type() {
+      if r.State() == "running" {
+        if rn.verbose {
+          umi ( rn.verbose, "halting router |%s|", r.Name() )
+        }
+        err := r.Halt ( )
+        if err != nil {
+          umi ( rn.verbose, "error halting router |%s| : |%s|\n", r.Name(), err.Error() )
+        }
+        return err
+      }
+    }
+  }
+
+  return errors.New ( "Router_network.Halt_first_edge error : Could not find an edge router to halt." )
+}
+
+
+
+
+
+func ( rn * Router_network ) get_router_by_name ( target_name string ) * router.Router {
+  for _, router := range rn.routers {
+    if router.Name() == target_name {
+      return router
+    }
+  }
+
+  ume ( "router_network: get_router_by_name: no such router |%s|", target_name )
+  fp ( os.Stdout, "    routers are:\n" )
+  for _, router := range rn.routers {
+    fp ( os.Stdout, "      %s\n", router.Name() )
+  }
+  os.Exit ( 1 )
+  return nil
+}
+
+
+
+
+
+func ( rn * Router_network ) How_many_interior_routers ( ) ( int ) {
+  count := 0
+
+  for _, r := range rn.routers {
+    if r.Is_interior() {
+      count ++
+    }
+  }
+
+  return count
+}
+
+
+
+
+
+func ( rn * Router_network ) Get_nth_interior_router_name ( index int ) ( string ) {
+  count := 0
+
+  for _, r := range rn.routers {
+    if r.Is_interior() {
+      if count == index {
+        return r.Name()
+      }
+      count ++
+    }
+  }
+  return ""
+}
+
+
+
+
+
+func element_of ( target_str string, strings [] string ) ( bool ) {
+  for _, str := range strings {
+    if target_str == str {
+      return true
+    }
+  }
+
+  return false
+}
+
+
+
+
+
+func union ( list_1, list_2 [] string ) ( [] string ) {
+
+  var union_list [] string
+
+  for _, str := range list_1 {
+    if ! element_of ( str, union_list ) {
+      union_list = append ( union_list, str )
+    }
+  }
+
+  for _, str := range list_2 {
+    if ! element_of ( str, union_list ) {
+      union_list = append ( union_list, str )
+    }
+  }
+
+  return union_list 
+}
+
+
+
+
+
+func print_list ( label string, list [] string ) {
+  fp ( os.Stdout, "%s\n", label )
+  for _, x := range list {
+    fp ( os.Stdout, "    %s\n", x )
+  }
+}
+
+
+
+
+
+func ( rn * Router_network ) Is_the_network_connected ( ) ( bool ) {
 
   if len ( rn.routers ) <= 0  {
     return false
@@ -1165,46 +2235,3 @@ func ( rn * Router_network ) Is_the_network_connected ( ) ( bool ) {
     }
   }
 }
-
-
-
-
-
-// I don't think this works.
-func ( rn * Router_network ) read_CPU ( ) {
-
-  file, err := os.Open ( "/proc/stat" ) 
-  if err != nil {
-    fp ( os.Stdout, "Router_network.read_CPU error: |%s|\n", err.Error() )
-  }
-
-  scanner := bufio.NewScanner ( file ) 
-  scanner.Scan (  ) 
-  first_line := scanner.Text (  ) [5:] // get rid of cpu plus 2 spaces
-  file.Close (  ) 
-  err = scanner.Err (  ) 
-  if err != nil {
-    fp ( os.Stdout, "Router_network.read_CPU error: |%s|\n", err.Error() )
-  }
-
-  split := strings.Fields ( first_line ) 
-  idle_time, _ := strconv.ParseUint ( split[3], 10, 64 ) 
-  total_time := uint64 ( 0 ) 
-  for _, s := range split {
-    u, _ := strconv.ParseUint ( s, 10, 64 ) 
-    total_time += u
-  }
-
-  delta_idle_time := idle_time - rn.previous_idle_time
-  delta_total_time := total_time - rn.previous_total_time
-  cpuUsage :=  ( 1.0 - float64 ( delta_idle_time ) /float64 ( delta_total_time )  )  * 100.0
-  fp ( os.Stdout, "MDEBUG %6.3f\n", cpuUsage )
-
-  rn.previous_idle_time  = idle_time
-  rn.previous_total_time = total_time
-}
-
-
-
-
-
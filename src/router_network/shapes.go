@@ -0,0 +1,312 @@
+package router_network
+
+import ( "errors"
+         "fmt"
+         "math/rand"
+       )
+
+
+
+
+
+/*===================================================================
+
+  Generators for the network shapes that tests use over and over.
+
+  A Shape describes a topology by kind and size. Its routers are
+  named the way the drivers have always named them -- "A", "B",
+  "C" ... -- but the names keep going past "Z" the way spreadsheet
+  columns do: "AA", "AB", ... "ZZ", "AAA".
+
+  Connectors always go from the newer router to the older one,
+  and from edge routers to interior routers, since nothing can
+  connect to an edge.
+
+  Shape kinds:
+    linear : each router connects to the previous one.
+    mesh   : every router connects to every other router.
+    ring   : linear, plus the last router connects to the first.
+    star   : every router connects to the first one.
+    tree   : balanced tree with 'Fanout' children per router.
+    random : random spanning tree plus 'Extra_connectors' more
+             connectors, reproducible from 'Seed'.
+    edges  : N_routers fully meshed interiors, each with N_edges
+             edge routers attached.
+
+===================================================================*/
+
+type Shape struct {
+  Kind               string    `json:"kind"`
+  N_routers          int       `json:"n_routers"`
+  N_edges            int       `json:"n_edges"`
+  Fanout             int       `json:"fanout"`
+  Extra_connectors   int       `json:"extra_connectors"`
+  Seed               int64     `json:"seed"`
+  // Only used in topology files. Defaults to the first version.
+  Version            string    `json:"version"`
+}
+
+
+
+
+
+/*
+  Make a safe router name from a zero-based index:
+  0 -> "A", 25 -> "Z", 26 -> "AA", 701 -> "ZZ", 702 -> "AAA".
+*/
+func Router_name ( index int ) ( string ) {
+  name := ""
+  for index >= 0 {
+    name  = string ( rune ( 'A' + index % 26 ) ) + name
+    index = index / 26 - 1
+  }
+  return name
+}
+
+
+
+
+
+/*
+  Work out the routers and connectors for the shape.
+  Router names are taken in order from the Router_name()
+  sequence, skipping any name for which in_use() is true.
+*/
+func ( s * Shape ) generate ( in_use func ( string ) bool ) ( routers [] Topology_router, connectors [] Topology_connector, err error ) {
+
+  if s.N_routers <= 0 {
+    return nil, nil, fmt.Errorf ( "shape |%s| : n_routers must be positive", s.Kind )
+  }
+
+  next_index := 0
+  next_name  := func ( ) ( string ) {
+    for {
+      name := Router_name ( next_index )
+      next_index ++
+      if ! in_use ( name ) {
+        return name
+      }
+    }
+  }
+
+  names := make ( [] string, s.N_routers )
+  for i := range names {
+    names [ i ] = next_name ( )
+    routers = append ( routers, Topology_router { Name : names[i], Type : "interior", Version : s.Version } )
+  }
+
+  connect := func ( from, to string ) {
+    connectors = append ( connectors, Topology_connector { From : from, To : to } )
+  }
+
+  switch s.Kind {
+
+    case "linear" :
+      for i := 1; i < len(names); i ++ {
+        connect ( names[i], names[i-1] )
+      }
+
+    case "mesh", "edges" :
+      for i := 1; i < len(names); i ++ {
+        for j := 0; j < i; j ++ {
+          connect ( names[i], names[j] )
+        }
+      }
+
+    case "ring" :
+      for i := 1; i < len(names); i ++ {
+        connect ( names[i], names[i-1] )
+      }
+      // With only two routers the ring is just a line.
+      if len(names) > 2 {
+        connect ( names[len(names)-1], names[0] )
+      }
+
+    case "star" :
+      for i := 1; i < len(names); i ++ {
+        connect ( names[i], names[0] )
+      }
+
+    case "tree" :
+      fanout := s.Fanout
+      if fanout <= 0 {
+        fanout = 2
+      }
+      for i := 1; i < len(names); i ++ {
+        connect ( names[i], names[(i-1)/fanout] )
+      }
+
+    case "random" :
+      rng := rand.New ( rand.NewSource ( s.Seed ) )
+      connected := make ( map [ [2]int ] bool )
+      link := func ( i, j int ) {
+        // Always connect the newer router to the older one.
+        if i < j {
+          i, j = j, i
+        }
+        connected [ [2]int { i, j } ] = true
+        connect ( names[i], names[j] )
+      }
+
+      // A random spanning tree guarantees that the network is connected.
+      for i := 1; i < len(names); i ++ {
+        link ( i, rng.Intn ( i ) )
+      }
+
+      n := len(names)
+      room := n * (n - 1) / 2 - (n - 1)
+      extra := s.Extra_connectors
+      if extra > room {
+        extra = room
+      }
+      for extra > 0 {
+        i, j := rng.Intn ( n ), rng.Intn ( n )
+        if i == j || connected [ [2]int { i, j } ] || connected [ [2]int { j, i } ] {
+          continue
+        }
+        link ( i, j )
+        extra --
+      }
+
+    default :
+      return nil, nil, fmt.Errorf ( "unknown shape |%s|", s.Kind )
+  }
+
+  if s.Kind == "edges" {
+    for _, interior := range names {
+      for j := 0; j < s.N_edges; j ++ {
+        edge_name := fmt.Sprintf ( "%s_edge_%02d", interior, j )
+        if in_use ( edge_name ) {
+          return nil, nil, fmt.Errorf ( "shape |%s| : router name |%s| is already in use", s.Kind, edge_name )
+        }
+        routers = append ( routers, Topology_router { Name : edge_name, Type : "edge", Version : s.Version } )
+        connect ( edge_name, interior )
+      }
+    }
+  } else if s.N_edges != 0 {
+    return nil, nil, fmt.Errorf ( "shape |%s| : only the 'edges' shape has edge routers", s.Kind )
+  }
+
+  return routers, connectors, nil
+}
+
+
+
+
+
+/*
+  Add all the routers of a shape to the network, and connect them.
+  Names that are already used by routers in this network are
+  skipped, so several shapes can be added to one network.
+  The names of the new routers are returned in the order they
+  were added. The routers use the shape's version if it has one,
+  and otherwise version_name; if both are given they must agree.
+*/
+func ( rn * Router_network ) Add_shape ( shape        Shape,
+                                         version_name string,
+                                         config_path  string,
+                                         log_path     string ) ( [] string, error ) {
+
+  if shape.Version != "" {
+    if version_name != "" && version_name != shape.Version {
+      return nil, fmt.Errorf ( "Add_shape: shape version |%s| conflicts with |%s|", shape.Version, version_name )
+    }
+    version_name = shape.Version
+  }
+
+  if rn.Get_version_from_name ( version_name ) == nil {
+    return nil, errors.New ( "Add_shape: no such version: " + version_name )
+  }
+
+  in_use := func ( name string ) ( bool ) {
+    for _, r := range rn.routers {
+      if r.Name() == name {
+        return true
+      }
+    }
+    return false
+  }
+
+  routers, connectors, err := shape.generate ( in_use )
+  if err != nil {
+    return nil, err
+  }
+
+  var names [] string
+  for _, r := range routers {
+    if r.Type == "edge" {
+      rn.Add_edge ( r.Name, version_name, config_path, log_path )
+    } else {
+      rn.Add_router ( r.Name, version_name, config_path, log_path )
+    }
+    names = append ( names, r.Name )
+  }
+
+  for _, c := range connectors {
+    rn.Connect_router ( c.From, c.To )
+  }
+
+  return names, nil
+}
+
+
+
+
+
+func ( rn * Router_network ) Add_linear ( n_routers int, version_name, config_path, log_path string ) ( [] string, error ) {
+  return rn.Add_shape ( Shape { Kind : "linear", N_routers : n_routers }, version_name, config_path, log_path )
+}
+
+
+
+
+
+func ( rn * Router_network ) Add_mesh ( n_routers int, version_name, config_path, log_path string ) ( [] string, error ) {
+  return rn.Add_shape ( Shape { Kind : "mesh", N_routers : n_routers }, version_name, config_path, log_path )
+}
+
+
+
+
+
+func ( rn * Router_network ) Add_ring ( n_routers int, version_name, config_path, log_path string ) ( [] string, error ) {
+  return rn.Add_shape ( Shape { Kind : "ring", N_routers : n_routers }, version_name, config_path, log_path )
+}
+
+
+
+
+
+func ( rn * Router_network ) Add_star ( n_routers int, version_name, config_path, log_path string ) ( [] string, error ) {
+  return rn.Add_shape ( Shape { Kind : "star", N_routers : n_routers }, version_name, config_path, log_path )
+}
+
+
+
+
+
+func ( rn * Router_network ) Add_tree ( n_routers, fanout int, version_name, config_path, log_path string ) ( [] string, error ) {
+  return rn.Add_shape ( Shape { Kind : "tree", N_routers : n_routers, Fanout : fanout }, version_name, config_path, log_path )
+}
+
+
+
+
+
+func ( rn * Router_network ) Add_random ( n_routers, extra_connectors int, seed int64, version_name, config_path, log_path string ) ( [] string, error ) {
+  return rn.Add_shape ( Shape { Kind             : "random",
+                                N_routers        : n_routers,
+                                Extra_connectors : extra_connectors,
+                                Seed             : seed },
+                        version_name,
+                        config_path,
+                        log_path )
+}
+
+
+
+
+
+func ( rn * Router_network ) Add_interiors_with_edges ( n_interiors, n_edges_per_interior int, version_name, config_path, log_path string ) ( [] string, error ) {
+  return rn.Add_shape ( Shape { Kind : "edges", N_routers : n_interiors, N_edges : n_edges_per_interior }, version_name, config_path, log_path )
+}
@@ -0,0 +1,983 @@
+package router_network
+
+import ( "encoding/json"
+         "errors"
+         "fmt"
+         "io/ioutil"
+         "strconv"
+         "strings"
+
+         "router"
+         "utils"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A Topology is a declarative description of a router network:
+  the versions it uses, its interior and edge routers, how they
+  are connected, and the clients that attach to them.
+
+  Topologies are stored as JSON files, so that a test scenario can
+  be kept as data rather than as yet another copy of a driver's
+  main(). Build_network() turns a Topology into a fully populated
+  Router_network that is ready for Init() and Run().
+
+  A minimal file looks like this:
+
+    {
+      "name"     : "basic",
+      "versions" : [ { "name"          : "latest",
+                       "proton_root"   : "/home/me/latest/install/proton",
+                       "dispatch_root" : "/home/me/latest/install/dispatch" } ],
+      "shapes"   : [ { "kind" : "linear", "n_routers" : 2 } ],
+      "routers"  : [ { "name" : "B_edge", "type" : "edge" } ],
+      "connectors"   : [ { "from" : "B_edge", "to" : "B" } ],
+      "client_pairs" : [ { "count"           : 10,
+                           "sender_router"   : "A",
+                           "receiver_router" : "B_edge",
+                           "n_messages"      : 1000,
+                           "message_length"  : 100,
+                           "throttle"        : 10 } ]
+    }
+
+  An "environment" map, at the top level or in a router, gives
+  the processes extra environment variables, and a "logging" map
+  sets the routers' log levels by module.
+
+===================================================================*/
+
+type Topology_version struct {
+  Name               string    `json:"name"`
+  Proton_root        string    `json:"proton_root"`
+  Dispatch_root      string    `json:"dispatch_root"`
+}
+
+
+
+type Topology_router struct {
+  Name               string    `json:"name"`
+  // "interior" or "edge". Defaults to "interior".
+  Type               string    `json:"type"`
+  // Defaults to the first version in the file.
+  Version            string    `json:"version"`
+  // Extra environment variables for this router alone.
+  Environment        map [ string ] string `json:"environment,omitempty"`
+  // 0 means the topology's worker_threads.
+  Worker_threads     int       `json:"worker_threads,omitempty"`
+}
+
+
+
+// The router named 'From' gets a connector to the router named 'To'.
+// Edge routers may connect to interiors, but nothing may connect
+// to an edge.
+type Topology_connector struct {
+  From               string    `json:"from"`
+  To                 string    `json:"to"`
+}
+
+
+
+type Topology_client struct {
+  Name               string    `json:"name"`
+  // "send" or "receive".
+  Operation          string    `json:"operation"`
+  Router             string    `json:"router"`
+  Host               string    `json:"host"`
+  N_messages         int       `json:"n_messages"`
+  Message_length     int       `json:"message_length"`
+  // Msec between messages. Senders only.
+  Throttle           int       `json:"throttle"`
+  Delay              int       `json:"delay"`
+  Soak               bool      `json:"soak"`
+  Addresses       [] string    `json:"addresses"`
+}
+
+
+
+/*
+  A shorthand for the most common client arrangement:
+  'Count' sender/receiver pairs, each pair sharing its own
+  address. Sender, receiver, and address names are numbered
+  just as the drivers have always numbered them, i.e.
+  sender_00000, receiver_00000, addr_00000.
+*/
+type Topology_client_pairs struct {
+  Count              int       `json:"count"`
+  Sender_router      string    `json:"sender_router"`
+  Receiver_router    string    `json:"receiver_router"`
+  Host               string    `json:"host"`
+  N_messages         int       `json:"n_messages"`
+  Message_length     int       `json:"message_length"`
+  Throttle           int       `json:"throttle"`
+  Delay              int       `json:"delay"`
+  Soak               bool      `json:"soak"`
+  // Prepended to the sender, receiver and address names,
+  // so that several groups of pairs can live in one network.
+  Name_prefix        string    `json:"name_prefix"`
+}
+
+
+
+/*
+  The listeners that authenticate their peers, on every router,
+  and the SASL mechanism they use, e.g.
+    { "mechanism" : "SCRAM-SHA-1", "listeners" : [ "client" ] }
+*/
+type Topology_sasl struct {
+  Mechanism          string    `json:"mechanism"`
+  Listeners       [] string    `json:"listeners"`
+}
+
+
+
+type Topology struct {
+  Name               string                    `json:"name"`
+  Versions        [] Topology_version          `json:"versions"`
+  // Generated routers are added before the explicit ones.
+  Shapes          [] Shape                     `json:"shapes"`
+  Routers         [] Topology_router           `json:"routers"`
+  Connectors      [] Topology_connector        `json:"connectors"`
+  Clients         [] Topology_client           `json:"clients"`
+  Client_pairs    [] Topology_client_pairs     `json:"client_pairs"`
+  // Extra environment variables for every router and client,
+  // e.g. { "MALLOC_ARENA_MAX" : "2" }
+  Environment        map [ string ] string     `json:"environment,omitempty"`
+  // Every router's log levels, by Dispatch module,
+  // e.g. { "DEFAULT" : "none" } or { "ROUTER_CORE" : "trace+" }
+  Logging            map [ string ] string     `json:"logging,omitempty"`
+  // For every router that doesn't have its own.
+  // 0 means the network's default.
+  Worker_threads     int                       `json:"worker_threads,omitempty"`
+  // The listeners that use TLS, on every router, e.g.
+  // [ "inter-router", "edge" ]. See router.Tls_listeners .
+  Tls             [] string                    `json:"tls,omitempty"`
+  // How every router authenticates its peers, if it does.
+  Sasl             * Topology_sasl             `json:"sasl,omitempty"`
+}
+
+
+
+
+
+/*
+  Read a topology file and check it for consistency.
+  Nothing is built or started.
+*/
+func Read_topology_file ( file_name string ) ( * Topology, error ) {
+  content, err := ioutil.ReadFile ( file_name )
+  if err != nil {
+    return nil, err
+  }
+
+  t := & Topology { }
+  if err = json.Unmarshal ( content, t ); err != nil {
+    return nil, fmt.Errorf ( "topology file |%s| : %s", file_name, err.Error() )
+  }
+
+  if err = t.Validate ( ); err != nil {
+    return nil, fmt.Errorf ( "topology file |%s| : %s", file_name, err.Error() )
+  }
+
+  return t, nil
+}
+
+
+
+
+
+/*
+  Fill in defaults and make sure that everything the topology
+  refers to actually exists. The network code itself is not very
+  forgiving about bad names -- get_router_by_name() exits the
+  program -- so we catch those problems here.
+*/
+func ( t * Topology ) Validate ( ) ( error ) {
+  if len ( t.Versions ) == 0 {
+    return errors.New ( "no versions" )
+  }
+
+  version_names := make ( map [ string ] bool )
+  for _, v := range t.Versions {
+    if v.Name == "" {
+      return errors.New ( "version with no name" )
+    }
+    if version_names [ v.Name ] {
+      return fmt.Errorf ( "version |%s| defined twice", v.Name )
+    }
+    version_names [ v.Name ] = true
+  }
+
+  router_types := make ( map [ string ] string )
+  for i := range t.Routers {
+    r := & t.Routers [ i ]
+    if r.Name == "" {
+      return errors.New ( "router with no name" )
+    }
+    if _, present := router_types [ r.Name ]; present {
+      return fmt.Errorf ( "router |%s| defined twice", r.Name )
+    }
+    if r.Type == "" {
+      r.Type = "interior"
+    }
+    if r.Type != "interior" && r.Type != "edge" {
+      return fmt.Errorf ( "router |%s| has bad type |%s|", r.Name, r.Type )
+    }
+    if r.Version == "" {
+      r.Version = t.Versions[0].Name
+    }
+    router_types [ r.Name ] = r.Type
+    if err := check_environment ( r.Environment ); err != nil {
+      return fmt.Errorf ( "router |%s| : %s", r.Name, err.Error() )
+    }
+    if r.Worker_threads < 0 {
+      return fmt.Errorf ( "router |%s| has %d worker threads", r.Name, r.Worker_threads )
+    }
+  }
+  if t.Worker_threads < 0 {
+    return fmt.Errorf ( "topology has %d worker threads", t.Worker_threads )
+  }
+  if err := check_environment ( t.Environment ); err != nil {
+    return err
+  }
+  for module, level := range t.Logging {
+    if err := router.Check_log_level ( level ); err != nil {
+      return fmt.Errorf ( "logging for |%s| : %s", module, err.Error() )
+    }
+  }
+  for _, listener := range t.Tls {
+    if err := router.Check_tls_listener ( listener ); err != nil {
+      return err
+    }
+  }
+  if t.Sasl != nil {
+    if err := router.Check_sasl_mechanism ( t.Sasl.Mechanism ); err != nil {
+      return err
+    }
+    if len ( t.Sasl.Listeners ) == 0 {
+      return errors.New ( "sasl has no listeners" )
+    }
+    for _, listener := range t.Sasl.Listeners {
+      if err := router.Check_sasl_listener ( listener ); err != nil {
+        return err
+      }
+      // The router will not take a password in the clear.
+      if t.Sasl.Mechanism == "PLAIN" {
+        secure := false
+        for _, tls := range t.Tls {
+          if tls == listener {
+            secure = true
+          }
+        }
+        if ! secure {
+          return fmt.Errorf ( "sasl PLAIN on listener |%s| needs tls on it too", listener )
+        }
+      }
+    }
+  }
+
+  routers, connectors, err := t.expand ( )
+  if err != nil {
+    return err
+  }
+  if len ( routers ) == 0 {
+    return errors.New ( "no routers" )
+  }
+  for _, r := range routers {
+    if ! version_names [ r.Version ] {
+      return fmt.Errorf ( "router |%s| uses unknown version |%s|", r.Name, r.Version )
+    }
+    router_types [ r.Name ] = r.Type
+  }
+
+  for _, c := range connectors {
+    if _, present := router_types [ c.From ]; ! present {
+      return fmt.Errorf ( "connector from unknown router |%s|", c.From )
+    }
+    to_type, present := router_types [ c.To ]
+    if ! present {
+      return fmt.Errorf ( "connector to unknown router |%s|", c.To )
+    }
+    if to_type == "edge" {
+      return fmt.Errorf ( "connector |%s| -> |%s| : nothing can connect to an edge router", c.From, c.To )
+    }
+    if c.From == c.To {
+      return fmt.Errorf ( "router |%s| connects to itself", c.From )
+    }
+  }
+
+  for i := range t.Clients {
+    c := & t.Clients [ i ]
+    if c.Name == "" {
+      return errors.New ( "client with no name" )
+    }
+    if c.Operation != "send" && c.Operation != "receive" {
+      return fmt.Errorf ( "client |%s| has bad operation |%s|", c.Name, c.Operation )
+    }
+    if _, present := router_types [ c.Router ]; ! present {
+      return fmt.Errorf ( "client |%s| attaches to unknown router |%s|", c.Name, c.Router )
+    }
+    if len ( c.Addresses ) == 0 {
+      return fmt.Errorf ( "client |%s| has no addresses", c.Name )
+    }
+    if c.Host == "" {
+      c.Host = "0.0.0.0"
+    }
+  }
+
+  for i := range t.Client_pairs {
+    p := & t.Client_pairs [ i ]
+    if p.Count < 0 {
+      return fmt.Errorf ( "client pairs group %d has negative count", i )
+    }
+    if _, present := router_types [ p.Sender_router ]; ! present {
+      return fmt.Errorf ( "client pairs group %d : unknown sender router |%s|", i, p.Sender_router )
+    }
+    if _, present := router_types [ p.Receiver_router ]; ! present {
+      return fmt.Errorf ( "client pairs group %d : unknown receiver router |%s|", i, p.Receiver_router )
+    }
+    if p.Host == "" {
+      p.Host = "0.0.0.0"
+    }
+  }
+
+  // The client pairs' names are made from their prefixes,
+  // so they can clash with each other or the explicit ones.
+  client_names := make ( map [ string ] bool )
+  for _, c := range t.All_clients ( ) {
+    if client_names [ c.Name ] {
+      return fmt.Errorf ( "client |%s| defined twice", c.Name )
+    }
+    client_names [ c.Name ] = true
+  }
+
+  return nil
+}
+
+
+
+
+
+func check_environment ( env map [ string ] string ) ( error ) {
+  for name := range env {
+    if name == "" || strings.ContainsAny ( name, "= \t\n" ) {
+      return fmt.Errorf ( "bad environment variable name |%s|", name )
+    }
+  }
+  return nil
+}
+
+
+
+
+
+/*
+  Generate the routers and connectors for all of the topology's
+  shapes, and return them together with the explicitly listed
+  ones. The topology itself is not changed, so that a sweep can
+  alter a shape and expand it again.
+*/
+func ( t * Topology ) expand ( ) ( routers [] Topology_router, connectors [] Topology_connector, err error ) {
+  in_use := func ( name string ) ( bool ) {
+    for _, r := range t.Routers {
+      if r.Name == name {
+        return true
+      }
+    }
+    for _, r := range routers {
+      if r.Name == name {
+        return true
+      }
+    }
+    return false
+  }
+
+  for _, shape := range t.Shapes {
+    if shape.Version == "" && len ( t.Versions ) > 0 {
+      shape.Version = t.Versions[0].Name
+    }
+    shape_routers, shape_connectors, err := shape.generate ( in_use )
+    if err != nil {
+      return nil, nil, err
+    }
+    routers    = append ( routers,    shape_routers ... )
+    connectors = append ( connectors, shape_connectors ... )
+  }
+
+  routers    = append ( routers,    t.Routers ... )
+  connectors = append ( connectors, t.Connectors ... )
+
+  return routers, connectors, nil
+}
+
+
+
+
+
+/*
+  All the routers and connectors in the topology: those
+  generated by its shapes, followed by the explicit ones.
+*/
+func ( t * Topology ) All_routers ( ) ( [] Topology_router, [] Topology_connector, error ) {
+  return t.expand ( )
+}
+
+
+
+
+
+/*
+  The names that the i'th of a group of client pairs gets.
+*/
+func ( p * Topology_client_pairs ) names ( i int ) ( sender, receiver, address string ) {
+  return fmt.Sprintf ( "%ssender_%05d",   p.Name_prefix, i ),
+         fmt.Sprintf ( "%sreceiver_%05d", p.Name_prefix, i ),
+         fmt.Sprintf ( "%saddr_%05d",     p.Name_prefix, i )
+}
+
+
+
+
+
+/*
+  All the clients in the topology: the explicit ones, followed
+  by those of the client pairs, each pair as a sender and then
+  its receiver.
+*/
+func ( t * Topology ) All_clients ( ) ( [] Topology_client ) {
+  clients := append ( [] Topology_client { }, t.Clients ... )
+
+  for _, p := range t.Client_pairs {
+    for i := 0; i < p.Count; i ++ {
+      sender, receiver, address := p.names ( i )
+      clients = append ( clients,
+                         Topology_client { Name           : sender,
+                                           Operation      : "send",
+                                           Router         : p.Sender_router,
+                                           Host           : p.Host,
+                                           N_messages     : p.N_messages,
+                                           Message_length : p.Message_length,
+                                           Throttle       : p.Throttle,
+                                           Delay          : p.Delay,
+                                           Soak           : p.Soak,
+                                           Addresses      : [] string { address } },
+                         Topology_client { Name           : receiver,
+                                           Operation      : "receive",
+                                           Router         : p.Receiver_router,
+                                           Host           : p.Host,
+                                           N_messages     : p.N_messages,
+                                           Message_length : p.Message_length,
+                                           Delay          : p.Delay,
+                                           Soak           : p.Soak,
+                                           Addresses      : [] string { address } } )
+    }
+  }
+  return clients
+}
+
+
+
+
+
+/*
+  The load, in msg/sec, that the senders offer the network,
+  in total and for each address, from their throttles.
+  If any sender is not throttled there is no telling what
+  it will offer, and 'limited' is false.
+*/
+func ( t * Topology ) Offered_load ( ) ( total float64, by_address map [ string ] float64, limited bool ) {
+  by_address = make ( map [ string ] float64 )
+  limited    = true
+
+  for _, c := range t.All_clients ( ) {
+    if c.Operation != "send" {
+      continue
+    }
+    if c.Throttle <= 0 {
+      limited = false
+      continue
+    }
+    // One message every throttle msec, spread over its addresses.
+    rate := 1000 / float64(c.Throttle)
+    total += rate
+    for _, addr := range c.Addresses {
+      by_address [ addr ] += rate / float64(len(c.Addresses))
+    }
+  }
+  return total, by_address, limited
+}
+
+
+
+
+
+/*
+  Make the standard directory layout for one run under 'run_path'
+  and populate a new network from the topology. The network is
+  returned un-initialized, so the caller can still change it
+  before calling Init() and Run().
+*/
+func ( t * Topology ) Build_network ( mercury_root, run_path string ) ( * Router_network, error ) {
+  if err := t.Validate ( ); err != nil {
+    return nil, err
+  }
+
+  routers, connectors, err := t.expand ( )
+  if err != nil {
+    return nil, err
+  }
+
+  log_path    := run_path + "/log"
+  config_path := run_path + "/config"
+  event_path  := run_path + "/event"
+  result_path := run_path + "/result"
+
+  utils.Find_or_create_dir ( log_path )
+  utils.Find_or_create_dir ( config_path )
+  utils.Find_or_create_dir ( event_path )
+  utils.Find_or_create_dir ( result_path )
+
+  rn := New_router_network ( t.Name, mercury_root, log_path )
+  rn.Set_pid_registry ( run_path + "/pids" )
+
+  for _, v := range t.Versions {
+    rn.Add_version_with_roots ( v.Name, v.Proton_root, v.Dispatch_root )
+  }
+
+  // Before anything is added, so that everything gets it.
+  for name, value := range t.Environment {
+    rn.Setenv ( name, value )
+  }
+  for module, level := range t.Logging {
+    rn.Set_log_level ( module, level )
+  }
+  if t.Worker_threads > 0 {
+    rn.Set_worker_threads ( t.Worker_threads )
+  }
+  if len ( t.Tls ) > 0 {
+    if err := rn.Enable_tls ( run_path + "/tls", t.Tls ); err != nil {
+      return nil, err
+    }
+  }
+  if t.Sasl != nil {
+    if err := rn.Enable_sasl ( run_path + "/sasl", t.Sasl.Mechanism, t.Sasl.Listeners ); err != nil {
+      return nil, err
+    }
+  }
+
+  for _, r := range routers {
+    if r.Worker_threads > 0 {
+      rn.Set_router_worker_threads ( r.Name, r.Worker_threads )
+    }
+    if r.Type == "edge" {
+      rn.Add_edge ( r.Name, r.Version, config_path, log_path )
+    } else {
+      rn.Add_router ( r.Name, r.Version, config_path, log_path )
+    }
+    for name, value := range r.Environment {
+      rn.Setenv_router ( r.Name, name, value )
+    }
+  }
+
+  for _, c := range connectors {
+    rn.Connect_router ( c.From, c.To )
+  }
+
+  // The clients get their paths from the network,
+  // so these must be set before any clients are added.
+  rn.Set_results_path ( result_path )
+  rn.Set_events_path  ( event_path )
+
+  for _, c := range t.Clients {
+    if c.Operation == "send" {
+      rn.Add_sender ( c.Name,
+                      config_path,
+                      c.Host,
+                      c.N_messages,
+                      c.Message_length,
+                      c.Router,
+                      strconv.Itoa ( c.Throttle ),
+                      strconv.Itoa ( c.Delay ),
+                      strconv.FormatBool ( c.Soak ) )
+    } else {
+      rn.Add_receiver ( c.Name,
+                        config_path,
+                        c.Host,
+                        c.N_messages,
+                        c.Message_length,
+                        c.Router,
+                        strconv.Itoa ( c.Delay ),
+                        strconv.FormatBool ( c.Soak ) )
+    }
+
+    for _, addr := range c.Addresses {
+      rn.Add_Address_To_Client ( c.Name, addr )
+    }
+  }
+
+  for _, p := range t.Client_pairs {
+    for i := 0; i < p.Count; i ++ {
+      sender_name, receiver_name, address := p.names ( i )
+
+      rn.Add_sender ( sender_name,
+                      config_path,
+                      p.Host,
+                      p.N_messages,
+                      p.Message_length,
+                      p.Sender_router,
+                      strconv.Itoa ( p.Throttle ),
+                      strconv.Itoa ( p.Delay ),
+                      strconv.FormatBool ( p.Soak ) )
+
+      rn.Add_receiver ( receiver_name,
+                        config_path,
+                        p.Host,
+                        p.N_messages,
+                        p.Message_length,
+                        p.Receiver_router,
+                        strconv.Itoa ( p.Delay ),
+                        strconv.FormatBool ( p.Soak ) )
+
+      rn.Add_Address_To_Client ( sender_name,   address )
+      rn.Add_Address_To_Client ( receiver_name, address )
+    }
+  }
+
+  return rn, nil
+}
+
+
+
+
+
+/*
+  Read a topology file and build the network it describes,
+  with its log, config, event, and result directories under
+  'run_path'.
+*/
+func New_router_network_from_file ( file_name, mercury_root, run_path string ) ( * Router_network, error ) {
+  t, err := Read_topology_file ( file_name )
+  if err != nil {
+    return nil, err
+  }
+
+  return t.Build_network ( mercury_root, run_path )
+}
+
+
+
+
+
+/*
+  Save the topology as JSON, so that a run directory
+  records exactly what was run in it.
+*/
+func ( t * Topology ) Write_file ( file_name string ) ( error ) {
+  content, err := json.MarshalIndent ( t, "", "  " )
+  if err != nil {
+    return err
+  }
+
+  return ioutil.WriteFile ( file_name, append ( content, '\n' ), 0644 )
+}
+
+
+
+
+
+/*
+  A deep copy of the topology, so that one run's parameter
+  changes don't carry over into the next.
+*/
+func ( t * Topology ) Copy ( ) ( * Topology ) {
+  content, err := json.Marshal ( t )
+  if err != nil {
+    // Everything in a topology can be marshalled.
+    panic ( err )
+  }
+  var c Topology
+  if err = json.Unmarshal ( content, & c ); err != nil {
+    panic ( err )
+  }
+  return & c
+}
+
+
+
+
+
+/*
+  Replace the install roots of the named version, or add
+  the version if the topology does not have it yet.
+*/
+func ( t * Topology ) Set_version ( name, proton_root, dispatch_root string ) {
+  for i := range t.Versions {
+    if t.Versions[i].Name == name {
+      t.Versions[i].Proton_root   = proton_root
+      t.Versions[i].Dispatch_root = dispatch_root
+      return
+    }
+  }
+
+  t.Versions = append ( t.Versions, Topology_version { Name          : name,
+                                                       Proton_root   : proton_root,
+                                                       Dispatch_root : dispatch_root } )
+}
+
+
+
+
+
+/*
+  Change one of the topology's tunable parameters.
+  This is how sweeps vary a scenario from one run to the next.
+
+    n_routers      : size of every shape
+    n_edges        : edges per interior, for 'edges' shapes
+    n_client_pairs : count of every group of client pairs
+    n_messages     : messages per address, for all clients
+    message_length : bytes per message, for all clients
+                     (also called message_size)
+    throttle       : msec between messages, for all senders
+                     (also called msec_pause)
+    worker_threads : worker threads, for every router
+    version        : the version name that every router uses
+    tls            : the listeners that use TLS, e.g. client+edge,
+                     or none
+    sasl           : MECHANISM:LISTENERS to authenticate with,
+                     e.g. SCRAM-SHA-1:inter-router, or none.
+                     Without listeners, it is all of them.
+
+  Listeners may be separated with '+' or ','.
+*/
+func ( t * Topology ) Set_parameter ( name, value string ) ( error ) {
+  switch name {
+
+    case "tls" :
+      t.Tls = nil
+      if value != "none" {
+        t.Tls = split_listeners ( value )
+      }
+      return nil
+
+    case "sasl" :
+      t.Sasl = nil
+      if value != "none" {
+        t.Sasl = & Topology_sasl { Mechanism : value,
+                                   Listeners : router.Tls_listeners }
+        if colon := strings.Index ( value, ":" ); colon >= 0 {
+          t.Sasl.Mechanism = value[:colon]
+          t.Sasl.Listeners = split_listeners ( value[colon+1:] )
+        }
+      }
+      return nil
+  }
+
+  if name == "version" {
+    found := false
+    for _, v := range t.Versions {
+      if v.Name == value {
+        found = true
+      }
+    }
+    if ! found {
+      return fmt.Errorf ( "parameter version : topology has no version |%s|", value )
+    }
+    for i := range t.Shapes {
+      t.Shapes[i].Version = value
+    }
+    for i := range t.Routers {
+      t.Routers[i].Version = value
+    }
+    return nil
+  }
+
+  n, err := strconv.Atoi ( value )
+  if err != nil {
+    return fmt.Errorf ( "parameter |%s| : bad value |%s|", name, value )
+  }
+
+  switch name {
+
+    case "n_routers" :
+      if len ( t.Shapes ) == 0 {
+        return errors.New ( "parameter n_routers : topology has no shapes" )
+      }
+      for i := range t.Shapes {
+        t.Shapes[i].N_routers = n
+      }
+
+    case "n_edges" :
+      for i := range t.Shapes {
+        t.Shapes[i].N_edges = n
+      }
+
+    case "n_client_pairs" :
+      if len ( t.Client_pairs ) == 0 {
+        return errors.New ( "parameter n_client_pairs : topology has no client pairs" )
+      }
+      for i := range t.Client_pairs {
+        t.Client_pairs[i].Count = n
+      }
+
+    case "n_messages" :
+      for i := range t.Client_pairs {
+        t.Client_pairs[i].N_messages = n
+      }
+      for i := range t.Clients {
+        t.Clients[i].N_messages = n
+      }
+
+    case "message_length", "message_size" :
+      for i := range t.Client_pairs {
+        t.Client_pairs[i].Message_length = n
+      }
+      for i := range t.Clients {
+        t.Clients[i].Message_length = n
+      }
+
+    case "throttle", "msec_pause" :
+      for i := range t.Client_pairs {
+        t.Client_pairs[i].Throttle = n
+      }
+      for i := range t.Clients {
+        t.Clients[i].Throttle = n
+      }
+
+    case "worker_threads" :
+      if n < 1 {
+        return fmt.Errorf ( "parameter worker_threads : bad value %d", n )
+      }
+      t.Worker_threads = n
+      for i := range t.Routers {
+        t.Routers[i].Worker_threads = 0
+      }
+
+    default :
+      return fmt.Errorf ( "unknown parameter |%s|", name )
+  }
+
+  return nil
+}
+
+
+
+
+
+func split_listeners ( value string ) ( [] string ) {
+  return strings.FieldsFunc ( value, func ( c rune ) bool { return c == '+' || c == ',' } )
+}
+
+
+
+
+
+/*
+  How many worker threads the router will have.
+*/
+func ( t * Topology ) worker_threads ( r Topology_router ) ( int ) {
+  if r.Worker_threads > 0 {
+    return r.Worker_threads
+  }
+  if t.Worker_threads > 0 {
+    return t.Worker_threads
+  }
+  return Default_worker_threads
+}
+
+
+
+
+
+/*
+  Listener names, e.g. "client+edge", always in the same order
+  however they are given.
+*/
+func listener_list ( listeners [] string ) ( string ) {
+  var in_order [] string
+  for _, listener := range router.Tls_listeners {
+    for _, l := range listeners {
+      if l == listener {
+        in_order = append ( in_order, listener )
+        break
+      }
+    }
+  }
+  return strings.Join ( in_order, "+" )
+}
+
+
+
+
+
+/*
+  The current values of the parameters that Set_parameter can
+  change, as far as this topology has them. A parameter that
+  differs between shapes or client groups is taken from the
+  first one. The routers' total count, their worker threads,
+  and which of their listeners use TLS and SASL are always
+  present.
+*/
+func ( t * Topology ) Parameters ( ) ( map [ string ] string ) {
+  params := make ( map [ string ] string )
+
+  routers, _, err := t.All_routers ( )
+  if err == nil {
+    params [ "routers" ] = strconv.Itoa ( len ( routers ) )
+    versions := make ( map [ string ] bool )
+    for _, r := range routers {
+      versions [ r.Version ] = true
+    }
+    if len ( versions ) == 1 {
+      params [ "version" ] = routers[0].Version
+    }
+
+    // Every run records this, even if it's the default, so that
+    // runs with different numbers of threads are never confused.
+    threads := make ( map [ int ] bool )
+    for _, r := range routers {
+      threads [ t.worker_threads ( r ) ] = true
+    }
+    if len ( threads ) == 1 {
+      params [ "worker_threads" ] = strconv.Itoa ( t.worker_threads ( routers[0] ) )
+    } else if len ( threads ) > 1 {
+      params [ "worker_threads" ] = "mixed"
+    }
+  }
+
+  // Always there, like the threads.
+  params [ "tls" ] = "none"
+  if len ( t.Tls ) > 0 {
+    params [ "tls" ] = listener_list ( t.Tls )
+  }
+  params [ "sasl" ] = "none"
+  if t.Sasl != nil {
+    params [ "sasl" ] = t.Sasl.Mechanism + ":" + listener_list ( t.Sasl.Listeners )
+  }
+
+  if len ( t.Shapes ) > 0 {
+    params [ "n_routers" ] = strconv.Itoa ( t.Shapes[0].N_routers )
+    if t.Shapes[0].Kind == "edges" {
+      params [ "n_edges" ] = strconv.Itoa ( t.Shapes[0].N_edges )
+    }
+  }
+
+  if len ( t.Client_pairs ) > 0 {
+    p := t.Client_pairs[0]
+    params [ "n_client_pairs" ] = strconv.Itoa ( p.Count )
+    params [ "n_messages" ]     = strconv.Itoa ( p.N_messages )
+    params [ "message_length" ] = strconv.Itoa ( p.Message_length )
+    params [ "throttle" ]       = strconv.Itoa ( p.Throttle )
+  } else if len ( t.Clients ) > 0 {
+    c := t.Clients[0]
+    params [ "n_messages" ]     = strconv.Itoa ( c.N_messages )
+    params [ "message_length" ] = strconv.Itoa ( c.Message_length )
+    params [ "throttle" ]       = strconv.Itoa ( c.Throttle )
+  }
+
+  return params
+}
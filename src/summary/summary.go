@@ -0,0 +1,472 @@
+package summary
+
+import ( "encoding/csv"
+         "encoding/json"
+         "io"
+         "io/ioutil"
+         "os"
+         "sort"
+         "strconv"
+         "strings"
+         "time"
+
+         "client"
+         "results"
+         rn "router_network"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A machine-readable account of one run, for feeding into other
+  tools. Every run gets a summary.json, and one line in its
+  test's summary.csv .
+
+===================================================================*/
+
+type Latency struct {
+  Count                int                    `json:"count"`
+  Min                  float64                `json:"min"`
+  Max                  float64                `json:"max"`
+  Mean                 float64                `json:"mean"`
+  Stddev               float64                `json:"stddev"`
+  // By percentile name, e.g. "p99.9".
+  Percentiles          map [ string ] float64 `json:"percentiles"`
+}
+
+
+
+/*
+  Achieved throughput in msg/sec, counted over a sliding window
+  of the trimmed run. The groups give the median rate of their
+  windows. A receiver with several addresses is counted under
+  all of them, joined with '+'.
+*/
+type Throughput struct {
+  Window               float64                `json:"window_sec"`
+  Overall              float64                `json:"overall"`
+  Median               float64                `json:"median"`
+  Min                  float64                `json:"min"`
+  Max                  float64                `json:"max"`
+  // From the senders' throttles; zero if any sender is not throttled.
+  Offered              float64                `json:"offered"`
+  By_receiver          map [ string ] float64 `json:"by_receiver"`
+  By_address           map [ string ] float64 `json:"by_address"`
+  By_router            map [ string ] float64 `json:"by_router"`
+}
+
+
+
+/*
+  A run is saturated if it delivers less than this
+  fraction of the load that its senders offer.
+*/
+const Saturation_ratio = 0.95
+
+
+
+type Router struct {
+  Name                 string    `json:"name"`
+  Type                 string    `json:"type"`
+  Version              string    `json:"version"`
+  // Zero if the router's memory was not sampled.
+  Peak_rss_kb          int       `json:"peak_rss_kb"`
+}
+
+
+
+type Summary struct {
+  Test                 string                   `json:"test"`
+  Run                  string                   `json:"run"`
+  Time                 string                   `json:"time"`
+  Passed               bool                     `json:"passed"`
+  Error                string                   `json:"error"`
+
+  // See router_network's Topology.Parameters .
+  Parameters           map [ string ] string    `json:"parameters"`
+  Versions          [] rn.Topology_version      `json:"versions"`
+  Routers           [] Router                   `json:"routers"`
+
+  // From the clients' own reports.
+  Senders              int                      `json:"senders"`
+  Receivers            int                      `json:"receivers"`
+  Sent                 int                      `json:"sent"`
+  Received             int                      `json:"received"`
+  Accepted             int                      `json:"accepted"`
+  Rejected             int                      `json:"rejected"`
+  Released             int                      `json:"released"`
+  Modified             int                      `json:"modified"`
+
+  // From the receivers' flight times: all messages, from
+  // first arrival to last, without any trimming.
+  Duration             float64                  `json:"duration_sec"`
+  Throughput           float64                  `json:"throughput_msg_per_sec"`
+
+  // Trimmed, as for the report.
+  Latency              Latency                  `json:"latency_msec"`
+  Achieved             Throughput               `json:"achieved_msg_per_sec"`
+
+  // How long the clients' connections took to open,
+  // from their own reports.
+  Connection_setup     Latency                  `json:"connection_setup_msec"`
+}
+
+
+
+
+
+/*
+  Gather the summary of a run that has finished.
+  t may be nil if the run was not made from a topology,
+  and statuses may be empty if the clients did not report.
+*/
+func Make ( test_name     string,
+            run_name      string,
+            results_path  string,
+            t           * rn.Topology,
+            statuses   [] client.Client_status,
+            test_error    string,
+            warm_up       time.Duration,
+            cool_down     time.Duration ) ( * Summary, error ) {
+
+  s := & Summary { Test       : test_name,
+                   Run        : run_name,
+                   Time       : time.Now().Format ( time.RFC3339 ),
+                   Passed     : test_error == "",
+                   Error      : test_error,
+                   Parameters : make ( map [ string ] string ) }
+
+  samples, err := results.Load_router_memory ( results_path )
+  if err != nil {
+    return nil, err
+  }
+  peaks := results.Peak_memory ( samples )
+
+  if t != nil {
+    s.Parameters = t.Parameters ( )
+    s.Versions   = t.Versions
+    routers, _, err := t.All_routers ( )
+    if err != nil {
+      return nil, err
+    }
+    for _, r := range routers {
+      s.Routers = append ( s.Routers, Router { Name        : r.Name,
+                                               Type        : r.Type,
+                                               Version     : r.Version,
+                                               Peak_rss_kb : peaks [ r.Name ] } )
+    }
+  }
+
+  for _, status := range statuses {
+    if status.Operation == "send" {
+      s.Senders ++
+    } else {
+      s.Receivers ++
+    }
+    s.Sent     += status.Sent
+    s.Received += status.Received
+    s.Accepted += status.Accepted
+    s.Rejected += status.Rejected
+    s.Released += status.Released
+    s.Modified += status.Modified
+  }
+
+  setup, err := results.Load_connection_setup ( results_path )
+  if err != nil {
+    return nil, err
+  }
+  s.Connection_setup = make_latency ( results.Compute_stats ( setup ) )
+
+  // A run whose results can't be read did not pass,
+  // but still gets a summary.
+  r, err := results.Load ( results_path )
+  if err != nil {
+    if s.Passed {
+      s.Passed = false
+      s.Error  = err.Error()
+    }
+    return s, nil
+  }
+  s.Duration = r.Duration ( )
+  if s.Duration > 0 {
+    s.Throughput = float64 ( len ( r.Messages ) ) / s.Duration
+  }
+
+  trimmed := r.Trim ( warm_up, cool_down )
+  s.Achieved = Measure_throughput ( trimmed, t, results.Default_throughput_window )
+
+  s.Latency = make_latency ( trimmed.Stats ( ) )
+
+  return s, nil
+}
+
+
+
+
+
+func make_latency ( stats results.Stats ) ( Latency ) {
+  l := Latency { Count       : stats.Count,
+                 Min         : stats.Min,
+                 Max         : stats.Max,
+                 Mean        : stats.Mean,
+                 Stddev      : stats.Stddev,
+                 Percentiles : make ( map [ string ] float64 ) }
+  if stats.Count > 0 {
+    for i, p := range stats.Percentile_points {
+      l.Percentiles [ results.Percentile_name ( p ) ] = stats.Percentiles [ i ]
+    }
+  }
+  return l
+}
+
+
+
+
+
+/*
+  Measure the throughput of a run, overall and for each
+  receiver, address, and router. Without a topology, only
+  the receivers are known.
+*/
+func Measure_throughput ( r * results.Results, t * rn.Topology, window time.Duration ) ( Throughput ) {
+  ts := r.Throughput_stats ( window )
+  th := Throughput { Window  : window.Seconds(),
+                     Overall : ts.Overall,
+                     Median  : ts.Median,
+                     Min     : ts.Min,
+                     Max     : ts.Max }
+
+  median_by := func ( group func ( receiver string ) string ) ( map [ string ] float64 ) {
+    rates := make ( map [ string ] float64 )
+    for name, g := range r.Group ( group ) {
+      rates [ name ] = g.Throughput_stats ( window ).Median
+    }
+    return rates
+  }
+
+  th.By_receiver = median_by ( func ( receiver string ) string { return receiver } )
+  if t == nil {
+    return th
+  }
+
+  if offered, _, limited := t.Offered_load ( ); limited {
+    th.Offered = offered
+  }
+
+  addresses := make ( map [ string ] string )
+  routers   := make ( map [ string ] string )
+  for _, c := range t.All_clients ( ) {
+    if c.Operation == "receive" {
+      addresses [ c.Name ] = strings.Join ( c.Addresses, "+" )
+      routers   [ c.Name ] = c.Router
+    }
+  }
+  th.By_address = median_by ( func ( receiver string ) string { return addresses [ receiver ] } )
+  th.By_router  = median_by ( func ( receiver string ) string { return routers   [ receiver ] } )
+
+  return th
+}
+
+
+
+
+
+/*
+  True if the run delivered noticeably less than its
+  senders offered. A run whose offered load is not known
+  is never saturated.
+*/
+func ( th * Throughput ) Saturated ( ) ( bool ) {
+  return th.Offered > 0 && th.Median < Saturation_ratio * th.Offered
+}
+
+
+
+
+
+func ( s * Summary ) Write_json ( file_name string ) ( error ) {
+  content, err := json.MarshalIndent ( s, "", "  " )
+  if err != nil {
+    return err
+  }
+  return ioutil.WriteFile ( file_name, append ( content, '\n' ), 0644 )
+}
+
+
+
+
+
+func Read_json ( file_name string ) ( * Summary, error ) {
+  content, err := ioutil.ReadFile ( file_name )
+  if err != nil {
+    return nil, err
+  }
+  var s Summary
+  if err = json.Unmarshal ( content, & s ); err != nil {
+    return nil, err
+  }
+  return & s, nil
+}
+
+
+
+
+
+func ( s * Summary ) parameter_names ( ) ( [] string ) {
+  var names [] string
+  for name := range s.Parameters {
+    names = append ( names, name )
+  }
+  sort.Strings ( names )
+  return names
+}
+
+
+
+
+
+/*
+  The columns of the CSV line. The parameters come first,
+  sorted by name, so all the runs of one scenario line up.
+  Router versions and peaks are given as  name=value  lists
+  separated by spaces.
+*/
+func ( s * Summary ) csv_header ( ) ( [] string ) {
+  header := [] string { "test", "run", "time", "passed", "error" }
+  for _, name := range s.parameter_names() {
+    header = append ( header, "param_" + name )
+  }
+  header = append ( header, "versions", "router_peak_rss_kb",
+                            "senders", "receivers", "sent", "received", "accepted", "rejected", "released", "modified",
+                            "duration_sec", "throughput_msg_per_sec",
+                            "achieved_window_sec", "achieved_median", "achieved_min", "achieved_max", "offered_msg_per_sec",
+                            "latency_count", "latency_min", "latency_max", "latency_mean", "latency_stddev" )
+  for _, p := range results.Standard_percentiles {
+    header = append ( header, "latency_" + results.Percentile_name ( p ) )
+  }
+  header = append ( header, "connection_setup_count", "connection_setup_mean", "connection_setup_max" )
+  return header
+}
+
+
+
+
+
+func ( s * Summary ) csv_record ( ) ( [] string ) {
+  f := func ( x float64 ) string { return strconv.FormatFloat ( x, 'f', 6, 64 ) }
+  i := strconv.Itoa
+
+  record := [] string { s.Test, s.Run, s.Time, strconv.FormatBool ( s.Passed ), s.Error }
+  for _, name := range s.parameter_names() {
+    record = append ( record, s.Parameters [ name ] )
+  }
+
+  var versions, peaks string
+  for _, v := range s.Versions {
+    if versions != "" {
+      versions += " "
+    }
+    versions += v.Name + "=" + v.Dispatch_root
+  }
+  for _, r := range s.Routers {
+    if peaks != "" {
+      peaks += " "
+    }
+    peaks += r.Name + "=" + i ( r.Peak_rss_kb )
+  }
+
+  record = append ( record, versions, peaks,
+                            i ( s.Senders ), i ( s.Receivers ),
+                            i ( s.Sent ), i ( s.Received ), i ( s.Accepted ), i ( s.Rejected ), i ( s.Released ), i ( s.Modified ),
+                            f ( s.Duration ), f ( s.Throughput ),
+                            f ( s.Achieved.Window ), f ( s.Achieved.Median ), f ( s.Achieved.Min ), f ( s.Achieved.Max ), f ( s.Achieved.Offered ),
+                            i ( s.Latency.Count ), f ( s.Latency.Min ), f ( s.Latency.Max ), f ( s.Latency.Mean ), f ( s.Latency.Stddev ) )
+  for _, p := range results.Standard_percentiles {
+    value, present := s.Latency.Percentiles [ results.Percentile_name ( p ) ]
+    if present {
+      record = append ( record, f ( value ) )
+    } else {
+      record = append ( record, "" )
+    }
+  }
+  record = append ( record, i ( s.Connection_setup.Count ), f ( s.Connection_setup.Mean ), f ( s.Connection_setup.Max ) )
+  return record
+}
+
+
+
+
+
+/*
+  Add this run to a CSV file, writing the header line
+  first if the file is new. If it is not, the run's values
+  go in the columns of the header that is already there,
+  so that rows line up even when runs have different
+  parameters. A column that this run has no value for is
+  left empty, and a value with no column is left out.
+*/
+func ( s * Summary ) Append_csv ( file_name string ) ( error ) {
+  header, err := read_csv_header ( file_name )
+  if err != nil {
+    return err
+  }
+
+  f, err := os.OpenFile ( file_name, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644 )
+  if err != nil {
+    return err
+  }
+
+  w := csv.NewWriter ( f )
+  if header == nil {
+    w.Write ( s.csv_header() )
+    w.Write ( s.csv_record() )
+  } else {
+    values := make ( map [ string ] string )
+    record := s.csv_record ( )
+    for i, name := range s.csv_header ( ) {
+      values [ name ] = record [ i ]
+    }
+    row := make ( [] string, len ( header ) )
+    for i, name := range header {
+      row [ i ] = values [ name ]
+    }
+    w.Write ( row )
+  }
+  w.Flush ( )
+
+  if err = w.Error ( ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
+
+
+
+
+
+/*
+  The header line of a CSV file, or nil if the
+  file does not exist yet or is empty.
+*/
+func read_csv_header ( file_name string ) ( [] string, error ) {
+  f, err := os.Open ( file_name )
+  if err != nil {
+    if os.IsNotExist ( err ) {
+      return nil, nil
+    }
+    return nil, err
+  }
+  defer f.Close ( )
+
+  r := csv.NewReader ( f )
+  r.FieldsPerRecord = -1
+  header, err := r.Read ( )
+  if err == io.EOF {
+    return nil, nil
+  }
+  return header, err
+}
@@ -0,0 +1,275 @@
+package sweep
+
+import ( "bufio"
+         "io"
+         "os"
+         "time"
+
+         "results"
+         rn "router_network"
+         "summary"
+       )
+
+
+
+
+
+/*
+  How one run of a sweep turned out.
+*/
+type Result struct {
+  Run
+  // Empty if the run succeeded.
+  Error              string
+  Stats              results.Stats
+  Throughput         summary.Throughput
+}
+
+
+
+/*
+  Run one network, built from t, with its output in
+  test_path/run_name. This is supplied by the caller,
+  so that the sweep does not need to know how.
+*/
+type Run_function func ( t * rn.Topology, run_name string ) ( error )
+
+
+
+
+
+/*
+  The pause from the sweep file, or 'otherwise' if it has none.
+*/
+func ( s * Sweep ) Pause_duration ( otherwise time.Duration ) ( time.Duration, error ) {
+  if s.Pause == "" {
+    return otherwise, nil
+  }
+  return time.ParseDuration ( s.Pause )
+}
+
+
+
+
+
+/*
+  Check that every run of the sweep can be applied to
+  the base topology, before starting any of them.
+*/
+func ( s * Sweep ) Check ( base * rn.Topology ) ( error ) {
+  runs, err := s.Runs ( )
+  if err != nil {
+    return err
+  }
+  for _, run := range runs {
+    if _, err = run.topology ( base ); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+
+
+
+func ( r * Run ) topology ( base * rn.Topology ) ( * rn.Topology, error ) {
+  t := base.Copy ( )
+  for _, setting := range r.Settings {
+    if err := t.Set_parameter ( setting.Name, setting.Value ); err != nil {
+      return nil, err
+    }
+  }
+  return t, t.Validate ( )
+}
+
+
+
+
+
+/*
+  Do every run of the sweep, one after another, starting each
+  from a fresh copy of the base topology. Runs that fail do not
+  stop the sweep. After every run, the table of results so far
+  is rewritten in test_path/results.txt, so it is there even if
+  the sweep is stopped part way.
+*/
+func ( s * Sweep ) Execute ( base        * rn.Topology,
+                             test_path     string,
+                             run_function  Run_function,
+                             pause         time.Duration,
+                             warm_up       time.Duration,
+                             cool_down     time.Duration ) ( [] Result, error ) {
+  if err := s.Check ( base ); err != nil {
+    return nil, err
+  }
+  runs, _ := s.Runs ( )
+
+  var sweep_results [] Result
+  for i, run := range runs {
+    result := Result { Run : run }
+
+    t, _ := run.topology ( base )
+    if err := run_function ( t, run.Name ); err != nil {
+      result.Error = err.Error()
+    }
+
+    if r, err := results.Load ( test_path + "/" + run.Name + "/result" ); err == nil {
+      trimmed := r.Trim ( warm_up, cool_down )
+      result.Stats      = trimmed.Stats ( )
+      result.Throughput = summary.Measure_throughput ( trimmed, t, results.Default_throughput_window )
+    } else if result.Error == "" {
+      result.Error = err.Error()
+    }
+
+    sweep_results = append ( sweep_results, result )
+    if err := s.Write_table ( test_path + "/results.txt", sweep_results ); err != nil {
+      return sweep_results, err
+    }
+
+    // A little pause before starting the next one.
+    if i < len(runs) - 1 {
+      time.Sleep ( pause )
+    }
+  }
+
+  return sweep_results, nil
+}
+
+
+
+
+
+func ( s * Sweep ) Write_table ( file_name string, sweep_results [] Result ) ( error ) {
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+
+  if err = s.Print_table ( f, sweep_results ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
+
+
+
+
+
+/*
+  Where a stage of the sweep stopped keeping up. Saturated is
+  the first run, in the order they ran, that delivered less than
+  its senders offered; it is nil if none did, or if the offered
+  load is not known. Peak is the run with the greatest throughput.
+*/
+type Saturation_point struct {
+  Stage              string
+  Saturated        * Result
+  Peak             * Result
+}
+
+
+
+
+
+func Saturation_points ( sweep_results [] Result ) ( [] Saturation_point ) {
+  var points [] Saturation_point
+  for i := range sweep_results {
+    r := & sweep_results[i]
+    if len ( points ) == 0 || points[len(points)-1].Stage != r.Stage {
+      points = append ( points, Saturation_point { Stage : r.Stage } )
+    }
+    point := & points[len(points)-1]
+    if r.Error != "" || r.Stats.Count == 0 {
+      continue
+    }
+    if point.Saturated == nil && r.Throughput.Saturated() {
+      point.Saturated = r
+    }
+    if point.Peak == nil || r.Throughput.Median > point.Peak.Throughput.Median {
+      point.Peak = r
+    }
+  }
+  return points
+}
+
+
+
+
+
+/*
+  One line per run: its settings, its latency statistics in msec,
+  and its throughput in msg/sec. Settings that a run's stage did
+  not change are '-'. Then, for each stage, its saturation point.
+*/
+func ( s * Sweep ) Print_table ( writer io.Writer, sweep_results [] Result ) ( error ) {
+  w     := bufio.NewWriter ( writer )
+  names := s.Parameter_names ( )
+
+  for _, name := range names {
+    fp ( w, "%-16s ", name )
+  }
+  fp ( w, "%10s %10s %10s %10s", "messages", "mean", "stddev", "max" )
+  for _, p := range results.Standard_percentiles {
+    fp ( w, " %10s", results.Percentile_name ( p ) )
+  }
+  fp ( w, " %10s %10s  %s\n", "msg/sec", "offered", "result" )
+
+  for _, r := range sweep_results {
+    for _, name := range names {
+      value, present := r.Value ( name )
+      if ! present {
+        value = "-"
+      }
+      fp ( w, "%-16s ", value )
+    }
+
+    st := r.Stats
+    if st.Count == 0 {
+      fp ( w, "%10d %10s %10s %10s", 0, "-", "-", "-" )
+      for range results.Standard_percentiles {
+        fp ( w, " %10s", "-" )
+      }
+      fp ( w, " %10s", "-" )
+    } else {
+      fp ( w, "%10d %10.3f %10.3f %10.3f", st.Count, st.Mean, st.Stddev, st.Max )
+      for _, value := range st.Percentiles {
+        fp ( w, " %10.3f", value )
+      }
+      fp ( w, " %10.1f", r.Throughput.Median )
+    }
+    if r.Throughput.Offered > 0 {
+      fp ( w, " %10.1f", r.Throughput.Offered )
+    } else {
+      fp ( w, " %10s", "-" )
+    }
+
+    if r.Error == "" {
+      fp ( w, "  %s\n", "success" )
+    } else {
+      fp ( w, "  failure : %s\n", r.Error )
+    }
+  }
+
+  fp ( w, "\n" )
+  for _, point := range Saturation_points ( sweep_results ) {
+    stage := "sweep"
+    if point.Stage != "" {
+      stage = "stage " + point.Stage
+    }
+    if point.Peak == nil {
+      fp ( w, "%s : no runs with results.\n", stage )
+      continue
+    }
+    if point.Saturated != nil {
+      fp ( w, "%s : saturated at run %s : offered %.1f msg/sec, achieved %.1f\n",
+           stage, point.Saturated.Name, point.Saturated.Throughput.Offered, point.Saturated.Throughput.Median )
+    } else {
+      fp ( w, "%s : no run saturated.\n", stage )
+    }
+    fp ( w, "%s : peak throughput %.1f msg/sec at run %s\n", stage, point.Peak.Throughput.Median, point.Peak.Name )
+  }
+
+  return w.Flush ( )
+}
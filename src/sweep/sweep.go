@@ -0,0 +1,345 @@
+package sweep
+
+import ( "encoding/json"
+         "errors"
+         "fmt"
+         "io/ioutil"
+         "strconv"
+         "strings"
+       )
+
+
+var fp = fmt.Fprintf
+
+
+
+
+
+/*===================================================================
+
+  A sweep runs one scenario many times, changing some of its
+  parameters from run to run. Sweep files are JSON:
+
+    {
+      "name"     : "latency",
+      "scenario" : "topologies/linear.json",
+      "pause"    : "10s",
+      "stages"   : [
+        { "name"       : "pairs",
+          "parameters" : [ { "name" : "n_routers",      "values" : [ "1", "2" ] },
+                           { "name" : "n_client_pairs", "range"  : "500:12000:500" } ] },
+        { "name"       : "size",
+          "parameters" : [ { "name" : "message_size",   "values" : [ "100", "1000", "10000" ] } ] }
+      ],
+      "exclude"  : [ { "n_routers" : "2", "n_client_pairs" : "500" } ]
+    }
+
+  Each stage runs every combination of its parameters' values
+  (a cartesian sweep), and the stages run one after another
+  (a staged sweep). Every run starts from the scenario as it
+  is in its file, so a stage only changes what it names.
+  Combinations that match every setting of an 'exclude' entry
+  are skipped.
+
+  Parameter names are those of router_network's
+  Topology.Set_parameter.
+
+===================================================================*/
+
+type Parameter struct {
+  Name               string    `json:"name"`
+  // Either a list of values,
+  Values          [] string    `json:"values"`
+  // or an integer range  start:stop:step , with stop included.
+  Range              string    `json:"range"`
+}
+
+
+
+type Stage struct {
+  Name               string       `json:"name"`
+  Parameters      [] Parameter    `json:"parameters"`
+}
+
+
+
+type Sweep struct {
+  Name               string                 `json:"name"`
+  Scenario           string                 `json:"scenario"`
+  // Time to wait between runs, as in "10s".
+  Pause              string                 `json:"pause"`
+  Stages          [] Stage                  `json:"stages"`
+  Exclude         [] map [ string ] string  `json:"exclude"`
+}
+
+
+
+type Setting struct {
+  Name               string
+  Value              string
+}
+
+
+
+/*
+  One run of a sweep: which stage it belongs to, and
+  the parameter settings that make it different.
+*/
+type Run struct {
+  Name               string
+  Stage              string
+  Settings        [] Setting
+}
+
+
+
+
+
+func Read_sweep_file ( file_name string ) ( * Sweep, error ) {
+  content, err := ioutil.ReadFile ( file_name )
+  if err != nil {
+    return nil, err
+  }
+
+  var s Sweep
+  if err = json.Unmarshal ( content, & s ); err != nil {
+    return nil, fmt.Errorf ( "sweep file |%s| : %s", file_name, err.Error() )
+  }
+  return & s, nil
+}
+
+
+
+
+
+/*
+  Expand a list of values for a sweep. Either
+    a comma-separated list :  10,20,50
+  or an integer range      :  start:stop:step   (stop is included)
+*/
+func Parse_values ( spec string ) ( [] string, error ) {
+  if ! strings.Contains ( spec, ":" ) {
+    var values [] string
+    for _, value := range strings.Split ( spec, "," ) {
+      if value = strings.TrimSpace ( value ); value != "" {
+        values = append ( values, value )
+      }
+    }
+    if len ( values ) == 0 {
+      return nil, errors.New ( "no values" )
+    }
+    return values, nil
+  }
+
+  fields := strings.Split ( spec, ":" )
+  if len ( fields ) != 3 {
+    return nil, errors.New ( "range should be start:stop:step" )
+  }
+
+  var numbers [3] int
+  for i, field := range fields {
+    n, err := strconv.Atoi ( strings.TrimSpace ( field ) )
+    if err != nil {
+      return nil, errors.New ( "bad number in range: " + field )
+    }
+    numbers [ i ] = n
+  }
+  start, stop, step := numbers[0], numbers[1], numbers[2]
+  if step <= 0 || stop < start {
+    return nil, errors.New ( "range should count upward with a positive step" )
+  }
+
+  var values [] string
+  for n := start; n <= stop; n += step {
+    values = append ( values, strconv.Itoa ( n ) )
+  }
+  return values, nil
+}
+
+
+
+
+
+/*
+  Parse a parameter given on the command line as  name=spec ,
+  where spec is anything Parse_values accepts.
+*/
+func Parse_parameter ( arg string ) ( Parameter, error ) {
+  equals := strings.Index ( arg, "=" )
+  if equals < 1 {
+    return Parameter{}, fmt.Errorf ( "parameter |%s| : expected name=values", arg )
+  }
+  p := Parameter { Name : arg[:equals] }
+  spec := arg[equals+1:]
+  if strings.Contains ( spec, ":" ) {
+    p.Range = spec
+  } else {
+    p.Values = strings.Split ( spec, "," )
+  }
+  return p, nil
+}
+
+
+
+
+
+func ( p * Parameter ) values ( ) ( [] string, error ) {
+  if p.Name == "" {
+    return nil, errors.New ( "parameter with no name" )
+  }
+  if p.Range != "" && len ( p.Values ) > 0 {
+    return nil, fmt.Errorf ( "parameter |%s| has both values and a range", p.Name )
+  }
+
+  spec := p.Range
+  if spec == "" {
+    spec = strings.Join ( p.Values, "," )
+  }
+  values, err := Parse_values ( spec )
+  if err != nil {
+    return nil, fmt.Errorf ( "parameter |%s| : %s", p.Name, err.Error() )
+  }
+  return values, nil
+}
+
+
+
+
+
+/*
+  A run's directory name is made from its settings, in the
+  order the parameters were declared:  n_routers_2__n_client_pairs_500
+  so that runs with the same settings always get the same name.
+*/
+func run_name ( settings [] Setting ) ( string ) {
+  var parts [] string
+  for _, setting := range settings {
+    parts = append ( parts, setting.Name + "_" + setting.Value )
+  }
+  return strings.Join ( parts, "__" )
+}
+
+
+
+
+
+func ( s * Sweep ) excluded ( settings [] Setting ) ( bool ) {
+  for _, exclusion := range s.Exclude {
+    if len ( exclusion ) == 0 {
+      continue
+    }
+    matches := 0
+    for _, setting := range settings {
+      if value, present := exclusion [ setting.Name ]; present && value == setting.Value {
+        matches ++
+      }
+    }
+    if matches == len ( exclusion ) {
+      return true
+    }
+  }
+  return false
+}
+
+
+
+
+
+/*
+  List every run of the sweep, in the order they will run.
+  Within a stage, the last parameter changes fastest.
+*/
+func ( s * Sweep ) Runs ( ) ( [] Run, error ) {
+  if len ( s.Stages ) == 0 {
+    return nil, errors.New ( "sweep has no stages" )
+  }
+
+  var runs [] Run
+  names := make ( map [ string ] bool )
+
+  for stage_number, stage := range s.Stages {
+    if len ( stage.Parameters ) == 0 {
+      return nil, fmt.Errorf ( "stage %d has no parameters", stage_number )
+    }
+
+    var all_values [] [] string
+    for i := range stage.Parameters {
+      values, err := stage.Parameters[i].values ( )
+      if err != nil {
+        return nil, err
+      }
+      all_values = append ( all_values, values )
+    }
+
+    // Count through every combination like an odometer.
+    indices := make ( [] int, len(all_values) )
+    for {
+      var settings [] Setting
+      for i, p := range stage.Parameters {
+        settings = append ( settings, Setting { p.Name, all_values[i][indices[i]] } )
+      }
+
+      if ! s.excluded ( settings ) {
+        name := run_name ( settings )
+        // The same combination in two stages only runs once.
+        if ! names [ name ] {
+          names [ name ] = true
+          runs = append ( runs, Run { Name : name, Stage : stage.Name, Settings : settings } )
+        }
+      }
+
+      digit := len(indices) - 1
+      for digit >= 0 {
+        indices [ digit ] ++
+        if indices [ digit ] < len ( all_values [ digit ] ) {
+          break
+        }
+        indices [ digit ] = 0
+        digit --
+      }
+      if digit < 0 {
+        break
+      }
+    }
+  }
+
+  if len ( runs ) == 0 {
+    return nil, errors.New ( "every run of the sweep is excluded" )
+  }
+  return runs, nil
+}
+
+
+
+
+
+/*
+  The names of all the parameters that any stage changes,
+  in the order they first appear.
+*/
+func ( s * Sweep ) Parameter_names ( ) ( [] string ) {
+  var names [] string
+  seen := make ( map [ string ] bool )
+  for _, stage := range s.Stages {
+    for _, p := range stage.Parameters {
+      if ! seen [ p.Name ] {
+        seen [ p.Name ] = true
+        names = append ( names, p.Name )
+      }
+    }
+  }
+  return names
+}
+
+
+
+
+
+func ( r * Run ) Value ( name string ) ( string, bool ) {
+  for _, setting := range r.Settings {
+    if setting.Name == name {
+      return setting.Value, true
+    }
+  }
+  return "", false
+}
@@ -0,0 +1,112 @@
+package utils
+
+import ( "os"
+         "sort"
+         "strings"
+       )
+
+
+
+
+
+/*===================================================================
+
+  The environment variables that one router or client process
+  is started with, on top of mercury's own. Each process carries
+  its own, and they are given to it through its exec.Cmd, so that
+  routers of different versions can be started side by side
+  without mercury's own environment ever being changed.
+
+===================================================================*/
+
+type Environment map [ string ] string
+
+
+
+
+
+/*
+  A copy that can be changed without changing this one.
+*/
+func ( e Environment ) Copy ( ) ( Environment ) {
+  c := make ( Environment, len ( e ) )
+  for name, value := range e {
+    c [ name ] = value
+  }
+  return c
+}
+
+
+
+
+
+/*
+  The names, in order, so that files written from this
+  environment are the same from one run to the next.
+*/
+func ( e Environment ) Names ( ) ( [] string ) {
+  names := make ( [] string, 0, len ( e ) )
+  for name := range e {
+    names = append ( names, name )
+  }
+  sort.Strings ( names )
+  return names
+}
+
+
+
+
+
+/*
+  Mercury's own environment with these variables added, or put
+  in place of mercury's own values, in the form that exec.Cmd.Env
+  wants.
+*/
+func ( e Environment ) List ( ) ( [] string ) {
+  var list [] string
+  for _, name_value := range os.Environ ( ) {
+    name := strings.SplitN ( name_value, "=", 2 ) [ 0 ]
+    if _, present := e [ name ]; ! present {
+      list = append ( list, name_value )
+    }
+  }
+  for _, name := range e.Names ( ) {
+    list = append ( list, name + "=" + e [ name ] )
+  }
+  return list
+}
+
+
+
+
+
+/*
+  Write the variables as shell export commands, so that
+  someone can reproduce the process by hand.
+*/
+func ( e Environment ) Write_file ( file_name string ) ( error ) {
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+  defer f.Close ( )
+
+  for _, name := range e.Names ( ) {
+    if _, err = fp ( f, "export %s=%s\n", name, shell_quote ( e [ name ] ) ); err != nil {
+      return err
+    }
+  }
+  return nil
+}
+
+
+
+
+
+// Quote a value only if the shell would otherwise mangle it.
+func shell_quote ( value string ) ( string ) {
+  if value != "" && ! strings.ContainsAny ( value, " \t\n'\"\\$`;&|<>(){}*?!#~" ) {
+    return value
+  }
+  return "'" + strings.Replace ( value, "'", `'\''`, -1 ) + "'"
+}
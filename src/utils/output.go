@@ -0,0 +1,120 @@
+package utils
+
+import ( "bytes"
+         "io"
+         "os"
+         "os/exec"
+         "sync"
+       )
+
+
+
+
+
+/*===================================================================
+
+  Keep what a process writes to stdout and stderr, which is where
+  assertion failures, Python tracebacks, and complaints about bad
+  options go -- things no log file will ever see.
+
+===================================================================*/
+
+// So that lines from processes that tee at once don't get mixed.
+var console_lock sync.Mutex
+
+
+
+
+
+/*
+  Writes to a file, and also to the console, with each line
+  marked with the process it came from.
+  The file is not embedded, or its ReadFrom() would let
+  io.Copy() go around Write().
+*/
+type tee_file struct {
+  file            * os.File
+  console         io.Writer
+  prefix          string
+  line_start      bool
+}
+
+
+
+func ( t * tee_file ) Write ( data [] byte ) ( int, error ) {
+  n, err := t.file.Write ( data )
+
+  console_lock.Lock ( )
+  defer console_lock.Unlock ( )
+  for len ( data ) > 0 {
+    if t.line_start {
+      io.WriteString ( t.console, t.prefix )
+    }
+    line := data
+    if newline := bytes.IndexByte ( data, '\n' ); newline >= 0 {
+      line = data [ : newline + 1 ]
+    }
+    t.console.Write ( line )
+    t.line_start = line [ len(line) - 1 ] == '\n'
+    data = data [ len(line) : ]
+  }
+
+  return n, err
+}
+
+
+
+func ( t * tee_file ) Close ( ) ( error ) {
+  return t.file.Close ( )
+}
+
+
+
+
+
+/*
+  Send the command's stdout and stderr to  path_base.stdout  and
+  path_base.stderr . If tee is true, they also go to this process's
+  stdout and stderr, each line starting with "[name] ".
+
+  This must be done before the command is started. The files are
+  closed when the command is waited for by its Supervisor; if the
+  command can't be started, the caller must Close_output() .
+*/
+func Capture_output ( cmd * exec.Cmd, path_base, name string, tee bool ) ( error ) {
+  stdout, err := os.Create ( path_base + ".stdout" )
+  if err != nil {
+    return err
+  }
+  stderr, err := os.Create ( path_base + ".stderr" )
+  if err != nil {
+    stdout.Close ( )
+    return err
+  }
+
+  if ! tee {
+    cmd.Stdout = stdout
+    cmd.Stderr = stderr
+    return nil
+  }
+
+  prefix := "[" + name + "] "
+  cmd.Stdout = & tee_file { file : stdout, console : os.Stdout, prefix : prefix, line_start : true }
+  cmd.Stderr = & tee_file { file : stderr, console : os.Stderr, prefix : prefix, line_start : true }
+  return nil
+}
+
+
+
+
+
+/*
+  Close the files that Capture_output() gave the command.
+*/
+func Close_output ( cmd * exec.Cmd ) {
+  for _, w := range [] io.Writer { cmd.Stdout, cmd.Stderr } {
+    if closer, ok := w.(io.Closer); ok {
+      closer.Close ( )
+    }
+  }
+}
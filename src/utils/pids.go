@@ -0,0 +1,190 @@
+package utils
+
+import ( "bufio"
+         "errors"
+         "io/ioutil"
+         "os"
+         "strconv"
+         "strings"
+         "sync"
+         "syscall"
+         "time"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A PID registry is a file, in a test's output, that lists every
+  router and client process the test started. If mercury dies
+  without halting them -- a panic, a kill -9 -- they keep running
+  and hold their ports, and the registry is how they are found
+  again and killed.
+
+  Each process is recorded with the time it started, in clock
+  ticks since boot, as /proc has it. A PID that has since been
+  reused by some other process has a different start time, so
+  nothing but mercury's own leftovers is ever killed.
+
+===================================================================*/
+
+type Registered_process struct {
+  Kind                 string
+  Name                 string
+  Pid                  int
+  Start_ticks          int64
+  Executable           string
+}
+
+
+
+// Processes are registered from many goroutines.
+var registry_lock sync.Mutex
+
+
+
+
+
+/*
+  The state of a process, e.g. "R" or "Z", and when it started,
+  from /proc/<pid>/stat . The command name there is in parentheses
+  and may contain spaces, so the fields are counted from the last
+  ')'. After it come the state (field 3), ... starttime (22).
+*/
+func proc_state ( pid int ) ( state string, start_ticks int64, err error ) {
+  content, err := ioutil.ReadFile ( "/proc/" + strconv.Itoa ( pid ) + "/stat" )
+  if err != nil {
+    return "", 0, err
+  }
+  close_paren := strings.LastIndex ( string(content), ")" )
+  if close_paren < 0 {
+    return "", 0, os.ErrInvalid
+  }
+  fields := strings.Fields ( string(content[close_paren+1:]) )
+  if len ( fields ) < 20 {
+    return "", 0, os.ErrInvalid
+  }
+  start_ticks, err = strconv.ParseInt ( fields [ 22 - 3 ], 10, 64 )
+  return fields[0], start_ticks, err
+}
+
+
+
+
+
+/*
+  Add a process that has just been started to the registry.
+*/
+func Register_process ( file_name    string,
+                        kind         string,
+                        name         string,
+                        pid          int,
+                        executable   string ) ( error ) {
+  _, start_ticks, err := proc_state ( pid )
+  if err != nil {
+    return err
+  }
+
+  registry_lock.Lock ( )
+  defer registry_lock.Unlock ( )
+
+  f, err := os.OpenFile ( file_name, os.O_APPEND | os.O_CREATE | os.O_WRONLY, 0644 )
+  if err != nil {
+    return err
+  }
+  defer f.Close ( )
+
+  _, err = fp ( f, "%s %s %d %d %s\n", kind, name, pid, start_ticks, executable )
+  return err
+}
+
+
+
+
+
+/*
+  Every process in a registry, in the order they were started.
+*/
+func Read_process_registry ( file_name string ) ( [] Registered_process, error ) {
+  f, err := os.Open ( file_name )
+  if err != nil {
+    return nil, err
+  }
+  defer f.Close ( )
+
+  var processes [] Registered_process
+  scanner := bufio.NewScanner ( f )
+  for scanner.Scan ( ) {
+    fields := strings.SplitN ( scanner.Text(), " ", 5 )
+    if len ( fields ) != 5 {
+      continue
+    }
+    p := Registered_process { Kind : fields[0], Name : fields[1], Executable : fields[4] }
+    if p.Pid, err = strconv.Atoi ( fields[2] ); err != nil {
+      continue
+    }
+    if p.Start_ticks, err = strconv.ParseInt ( fields[3], 10, 64 ); err != nil {
+      continue
+    }
+    processes = append ( processes, p )
+  }
+  return processes, scanner.Err()
+}
+
+
+
+
+
+/*
+  Is this same process still running? A zombie isn't.
+*/
+func ( p Registered_process ) Is_running ( ) ( bool ) {
+  state, start_ticks, err := proc_state ( p.Pid )
+  return err == nil && start_ticks == p.Start_ticks && state != "Z"
+}
+
+
+
+
+
+/*
+  Ask the process to stop, and kill it if it hasn't after grace.
+  forced is true if it had to be killed.
+*/
+func ( p Registered_process ) Kill ( grace time.Duration ) ( forced bool, err error ) {
+  if err = syscall.Kill ( p.Pid, syscall.SIGTERM ); err != nil {
+    return false, err
+  }
+  if p.wait_for_exit ( grace ) {
+    return false, nil
+  }
+
+  if p.Is_running ( ) {
+    if err = syscall.Kill ( p.Pid, syscall.SIGKILL ); err != nil {
+      return true, err
+    }
+  }
+  if p.wait_for_exit ( time.Second ) {
+    return true, nil
+  }
+  return true, errors.New ( "still running after SIGKILL" )
+}
+
+
+
+
+
+func ( p Registered_process ) wait_for_exit ( timeout time.Duration ) ( bool ) {
+  deadline := time.Now().Add ( timeout )
+  for {
+    if ! p.Is_running ( ) {
+      return true
+    }
+    if time.Now().After ( deadline ) {
+      return false
+    }
+    time.Sleep ( 100 * time.Millisecond )
+  }
+}
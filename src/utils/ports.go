@@ -0,0 +1,291 @@
+package utils
+
+import ( "errors"
+         "fmt"
+         "io/ioutil"
+         "net"
+         "os"
+         "sort"
+         "strconv"
+         "strings"
+         "sync"
+         "syscall"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A Port_allocator hands out listener ports to one network.
+
+  Asking the kernel for a free port and then closing it, as
+  Available_port() does, races with everything else on the host:
+  the port is free when it is handed out, but may not be by the
+  time the router gets round to listening on it. So instead each
+  allocator reserves blocks of ports, and only hands out ports
+  from its own blocks.
+
+  A block is reserved by holding a lock on a file named for it in
+  the temp dir, so two networks -- in this process or any other
+  mercury -- never get the same block. The locks go when the
+  allocator is released, or when the process dies.
+
+  The blocks are taken from below the kernel's ephemeral port
+  range, so that outgoing connections, which get their local
+  ports from that range, don't take ours either.
+
+===================================================================*/
+
+const port_block_size = 100
+const lowest_port     = 10000
+
+
+
+type Port_allocator struct {
+  lock                 sync.Mutex
+  low                  int
+  high                 int
+  blocks            [] * os.File
+  next                 int
+  block_end            int
+  handed_out           map [ int ] bool
+}
+
+
+
+
+
+func New_port_allocator ( ) ( * Port_allocator ) {
+  a := & Port_allocator { low        : lowest_port,
+                          high       : ephemeral_port_low ( ) - 1,
+                          handed_out : make ( map [ int ] bool ) }
+  return a
+}
+
+
+
+
+
+/*
+  The bottom of the kernel's ephemeral port range,
+  or its usual value if that can't be read.
+*/
+func ephemeral_port_low ( ) ( int ) {
+  content, err := ioutil.ReadFile ( "/proc/sys/net/ipv4/ip_local_port_range" )
+  if err == nil {
+    if fields := strings.Fields ( string(content) ); len ( fields ) > 0 {
+      if low, err := strconv.Atoi ( fields[0] ); err == nil && low > lowest_port + port_block_size {
+        return low
+      }
+    }
+  }
+  return 32768
+}
+
+
+
+
+
+/*
+  Take the first block that nobody else holds.
+*/
+func ( a * Port_allocator ) reserve_block ( ) ( error ) {
+  dir := os.TempDir() + "/mercury_ports"
+  os.MkdirAll ( dir, 0777 )
+
+  start := a.low
+  if a.block_end > start {
+    start = a.block_end
+  }
+  for ; start + port_block_size - 1 <= a.high; start += port_block_size {
+    f, err := os.OpenFile ( fmt.Sprintf ( "%s/%d", dir, start ), os.O_CREATE | os.O_RDWR, 0666 )
+    if err != nil {
+      // Most likely some other user's lock file.
+      continue
+    }
+    if err = syscall.Flock ( int(f.Fd()), syscall.LOCK_EX | syscall.LOCK_NB ); err != nil {
+      f.Close ( )
+      continue
+    }
+    a.blocks    = append ( a.blocks, f )
+    a.next      = start
+    a.block_end = start + port_block_size
+    return nil
+  }
+
+  return fmt.Errorf ( "no free block of %d ports between %d and %d", port_block_size, a.low, a.high )
+}
+
+
+
+
+
+/*
+  A port that has not been handed out before, and that nothing
+  is listening on right now.
+*/
+func ( a * Port_allocator ) Next ( ) ( string, error ) {
+  a.lock.Lock ( )
+  defer a.lock.Unlock ( )
+
+  for {
+    for ; a.next < a.block_end; a.next ++ {
+      if ! a.handed_out [ a.next ] && port_is_free ( a.next ) {
+        a.handed_out [ a.next ] = true
+        port := a.next
+        a.next ++
+        return strconv.Itoa ( port ), nil
+      }
+    }
+    if err := a.reserve_block ( ); err != nil {
+      return "", err
+    }
+  }
+}
+
+
+
+
+
+/*
+  All the ports handed out so far, in order.
+*/
+func ( a * Port_allocator ) Handed_out ( ) ( [] string ) {
+  a.lock.Lock ( )
+  defer a.lock.Unlock ( )
+
+  var numbers [] int
+  for port := range a.handed_out {
+    numbers = append ( numbers, port )
+  }
+  sort.Ints ( numbers )
+
+  ports := make ( [] string, len ( numbers ) )
+  for i, port := range numbers {
+    ports [ i ] = strconv.Itoa ( port )
+  }
+  return ports
+}
+
+
+
+
+
+/*
+  Give up the allocator's blocks, for other networks to use.
+  Do this only when nothing is listening on its ports any more.
+*/
+func ( a * Port_allocator ) Release ( ) {
+  a.lock.Lock ( )
+  defer a.lock.Unlock ( )
+
+  for _, f := range a.blocks {
+    // Closing the file drops the lock.
+    f.Close ( )
+  }
+  a.blocks     = nil
+  a.next       = 0
+  a.block_end  = 0
+  a.handed_out = make ( map [ int ] bool )
+}
+
+
+
+
+
+func port_is_free ( port int ) ( bool ) {
+  listener, err := net.Listen ( "tcp", ":" + strconv.Itoa ( port ) )
+  if err != nil {
+    return false
+  }
+  listener.Close ( )
+  return true
+}
+
+
+
+
+
+/*
+  The TCP ports that the process is listening on.
+*/
+func Listening_ports ( pid int ) ( map [ string ] bool, error ) {
+  return socket_ports ( pid, tcp_listen )
+}
+
+
+
+
+
+/*
+  The remote TCP ports that the process has connections to.
+*/
+func Connected_ports ( pid int ) ( map [ string ] bool, error ) {
+  return socket_ports ( pid, tcp_established )
+}
+
+
+
+// Socket states, as /proc/net/tcp shows them.
+const tcp_established = "01"
+const tcp_listen      = "0A"
+
+
+
+
+
+/*
+  The ports of the process's TCP sockets that are in the given
+  state, from the socket inodes among its open files and the
+  sockets in its network namespace. For listening sockets these
+  are the local ports; for the others, the remote ones.
+*/
+func socket_ports ( pid int, state string ) ( map [ string ] bool, error ) {
+  proc_dir := "/proc/" + strconv.Itoa ( pid )
+
+  fds, err := ioutil.ReadDir ( proc_dir + "/fd" )
+  if err != nil {
+    return nil, err
+  }
+  inodes := make ( map [ string ] bool )
+  for _, fd := range fds {
+    link, err := os.Readlink ( proc_dir + "/fd/" + fd.Name() )
+    if err == nil && strings.HasPrefix ( link, "socket:[" ) {
+      inodes [ strings.TrimSuffix ( strings.TrimPrefix ( link, "socket:[" ), "]" ) ] = true
+    }
+  }
+
+  address := 1
+  if state != tcp_listen {
+    address = 2
+  }
+
+  ports := make ( map [ string ] bool )
+  found := false
+  for _, table := range [] string { "tcp", "tcp6" } {
+    content, err := ioutil.ReadFile ( proc_dir + "/net/" + table )
+    if err != nil {
+      continue
+    }
+    found = true
+    //   sl  local_address rem_address   st tx_queue:rx_queue tr:tm->when retrnsmt   uid  timeout inode
+    //   0: 00000000:1628 00000000:0000 0A 00000000:00000000 00:00000000 00000000  1000        0 41021
+    for _, line := range strings.Split ( string(content), "\n" ) [ 1 : ] {
+      fields := strings.Fields ( line )
+      if len ( fields ) < 10 || fields[3] != state || ! inodes [ fields[9] ] {
+        continue
+      }
+      colon := strings.LastIndex ( fields[address], ":" )
+      if port, err := strconv.ParseInt ( fields[address][colon+1:], 16, 32 ); err == nil {
+        ports [ strconv.Itoa ( int(port) ) ] = true
+      }
+    }
+  }
+  if ! found {
+    return nil, errors.New ( "can't read " + proc_dir + "/net/tcp" )
+  }
+
+  return ports, nil
+}
@@ -0,0 +1,280 @@
+package utils
+
+import ( "bufio"
+         "io/ioutil"
+         "os"
+         "strconv"
+         "strings"
+         "time"
+       )
+
+
+
+
+
+/*===================================================================
+
+  What the kernel says about a process, read from /proc/<pid> .
+  Sampling these while a test runs shows how the routers and
+  clients behave under load, at no cost to them.
+
+===================================================================*/
+
+/*
+  The kernel reports CPU time in clock ticks of 1/USER_HZ seconds.
+  USER_HZ is 100 on every Linux that mercury runs on, and Go can't
+  ask sysconf() without cgo.
+*/
+const clock_ticks_per_second = 100
+
+
+
+type Proc_sample struct {
+  Rss_kb                 int
+  Vsz_kb                 int
+  // Total CPU time since the process started.
+  User_sec               float64
+  Sys_sec                float64
+  Threads                int
+  Fds                    int
+  Voluntary_switches     int
+  Involuntary_switches   int
+  // From /proc/<pid>/io , which only the process's owner can read.
+  // -1 if it could not be read.
+  Read_bytes             int64
+  Write_bytes            int64
+}
+
+
+
+/*
+  A process for the sampler to look at. Kind is what sort of
+  process it is, such as "router" or "client".
+*/
+type Sampled_process struct {
+  Kind                   string
+  Name                   string
+  Pid                    int
+}
+
+
+
+
+
+/*
+  Read everything about a process at once. An error means that
+  the process is gone; the parts that only some users may read
+  are left at -1 instead.
+*/
+func Read_proc ( pid int ) ( Proc_sample, error ) {
+  dir := "/proc/" + strconv.Itoa ( pid )
+  s   := Proc_sample { Read_bytes : -1, Write_bytes : -1 }
+
+  if err := s.read_stat ( dir ); err != nil {
+    return s, err
+  }
+  s.read_statm  ( dir )
+  s.read_status ( dir )
+  s.read_io     ( dir )
+
+  if fds, err := ioutil.ReadDir ( dir + "/fd" ); err == nil {
+    s.Fds = len ( fds )
+  } else {
+    s.Fds = -1
+  }
+
+  return s, nil
+}
+
+
+
+
+
+/*
+  The command name in /proc/<pid>/stat is in parentheses and may
+  contain spaces, so the fields are counted from the last ')'.
+  After it come the state (field 3), ... utime (14), stime (15),
+  ... num_threads (20), ... vsize (23), in bytes.
+*/
+func ( s * Proc_sample ) read_stat ( dir string ) ( error ) {
+  content, err := ioutil.ReadFile ( dir + "/stat" )
+  if err != nil {
+    return err
+  }
+  close_paren := strings.LastIndex ( string(content), ")" )
+  if close_paren < 0 {
+    return os.ErrInvalid
+  }
+  fields := strings.Fields ( string(content[close_paren+1:]) )
+  field  := func ( n int ) ( int64 ) {
+    // fields[0] is field 3.
+    if n - 3 >= len ( fields ) {
+      return 0
+    }
+    value, _ := strconv.ParseInt ( fields [ n - 3 ], 10, 64 )
+    return value
+  }
+
+  s.User_sec = float64 ( field(14) ) / clock_ticks_per_second
+  s.Sys_sec  = float64 ( field(15) ) / clock_ticks_per_second
+  s.Threads  = int ( field(20) )
+  s.Vsz_kb   = int ( field(23) / 1024 )
+  return nil
+}
+
+
+
+
+
+/*
+  The first two numbers in statm are the total program size
+  and the resident set size, both in pages.
+*/
+func ( s * Proc_sample ) read_statm ( dir string ) {
+  content, err := ioutil.ReadFile ( dir + "/statm" )
+  if err != nil {
+    return
+  }
+  fields := strings.Fields ( string(content) )
+  if len ( fields ) < 2 {
+    return
+  }
+  rss_pages, _ := strconv.Atoi ( fields[1] )
+  s.Rss_kb = rss_pages * os.Getpagesize() / 1024
+}
+
+
+
+
+
+/*
+  Lines of "Name:  value" .
+*/
+func read_proc_fields ( file_name string ) ( map [ string ] string ) {
+  values := make ( map [ string ] string )
+  f, err := os.Open ( file_name )
+  if err != nil {
+    return values
+  }
+  defer f.Close ( )
+
+  scanner := bufio.NewScanner ( f )
+  for scanner.Scan ( ) {
+    line  := scanner.Text ( )
+    colon := strings.Index ( line, ":" )
+    if colon > 0 {
+      values [ line[:colon] ] = strings.TrimSpace ( line[colon+1:] )
+    }
+  }
+  return values
+}
+
+
+
+
+
+func ( s * Proc_sample ) read_status ( dir string ) {
+  status := read_proc_fields ( dir + "/status" )
+  s.Voluntary_switches,   _ = strconv.Atoi ( status [ "voluntary_ctxt_switches" ] )
+  s.Involuntary_switches, _ = strconv.Atoi ( status [ "nonvoluntary_ctxt_switches" ] )
+  // stat has this too, but status is the one people look at.
+  if threads, err := strconv.Atoi ( status [ "Threads" ] ); err == nil {
+    s.Threads = threads
+  }
+}
+
+
+
+
+
+func ( s * Proc_sample ) read_io ( dir string ) {
+  io := read_proc_fields ( dir + "/io" )
+  if len ( io ) == 0 {
+    return
+  }
+  s.Read_bytes,  _ = strconv.ParseInt ( io [ "read_bytes" ],  10, 64 )
+  s.Write_bytes, _ = strconv.ParseInt ( io [ "write_bytes" ], 10, 64 )
+}
+
+
+
+
+
+/*
+  The header line of a process metrics file.
+*/
+const Proc_metrics_header = "# seconds kind name pid rss_kb vsz_kb user_sec sys_sec cpu_percent threads fds voluntary_switches involuntary_switches read_bytes write_bytes"
+
+
+
+/*
+  Every 'interval', until 'stop' is closed, sample every process
+  that 'processes' returns, and write one line for each to
+  file_name, in the columns of Proc_metrics_header. Seconds are
+  counted from start_time, a Timestamp(). The processes are asked
+  for again each time, since they may be restarted with new pids.
+
+  cpu_percent is the CPU time that the process used since its last
+  sample, as a percentage of one core; it is -1 on the first one.
+*/
+func Sample_processes ( file_name     string,
+                        interval      time.Duration,
+                        start_time    float64,
+                        processes     func ( ) ( [] Sampled_process ),
+                        stop          chan struct{} ) ( error ) {
+  f, err := os.Create ( file_name )
+  if err != nil {
+    return err
+  }
+  defer f.Close ( )
+  w := bufio.NewWriter ( f )
+  fp ( w, "%s\n", Proc_metrics_header )
+  w.Flush ( )
+
+  type previous struct {
+    seconds   float64
+    cpu_sec   float64
+  }
+  last := make ( map [ int ] previous )
+
+  ticker := time.NewTicker ( interval )
+  defer ticker.Stop ( )
+
+  for {
+    select {
+      case <-stop :
+        return w.Flush ( )
+      case <-ticker.C :
+    }
+
+    for _, p := range processes ( ) {
+      if p.Pid <= 0 {
+        continue
+      }
+      s, err := Read_proc ( p.Pid )
+      if err != nil {
+        continue
+      }
+
+      seconds     := Timestamp() - start_time
+      cpu_sec     := s.User_sec + s.Sys_sec
+      cpu_percent := -1.0
+      if prev, present := last [ p.Pid ]; present && seconds > prev.seconds {
+        cpu_percent = ( cpu_sec - prev.cpu_sec ) / ( seconds - prev.seconds ) * 100
+      }
+      last [ p.Pid ] = previous { seconds, cpu_sec }
+
+      fp ( w, "%.3f %s %s %d %d %d %.2f %.2f %.1f %d %d %d %d %d %d\n",
+           seconds, p.Kind, p.Name, p.Pid,
+           s.Rss_kb, s.Vsz_kb,
+           s.User_sec, s.Sys_sec, cpu_percent,
+           s.Threads, s.Fds,
+           s.Voluntary_switches, s.Involuntary_switches,
+           s.Read_bytes, s.Write_bytes )
+    }
+    // Flush every round, so a run that is killed still has its samples.
+    if err = w.Flush ( ); err != nil {
+      return err
+    }
+  }
+}
@@ -0,0 +1,88 @@
+package utils
+
+import ( "crypto/rand"
+         "encoding/hex"
+         "fmt"
+         "io/ioutil"
+         "os"
+         "os/exec"
+         "strings"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A Sasl_config is the Cyrus SASL setup that routers authenticate
+  their peers with in a test: a config file that allows only the
+  chosen mechanisms, and a sasldb with one user in it, which every
+  router and client in the test logs in as.
+
+  The sasldb is made with saslpasswd2, from Cyrus SASL, which must
+  be on the PATH.
+
+===================================================================*/
+
+type Sasl_config struct {
+  // The router reads Dir/Name.conf .
+  Dir                  string
+  Name                 string
+  // What peers log in with. The user includes the realm.
+  User                 string
+  Password             string
+}
+
+
+
+// The realm that the user is in.
+const sasl_realm = "mercury"
+
+
+
+
+
+/*
+  Make a SASL config named 'name' in dir, allowing these
+  mechanisms, e.g. "SCRAM-SHA-1", and with 'user' in its sasldb
+  with a new random password.
+*/
+func New_sasl_config ( dir, name string, mechanisms [] string, user string ) ( * Sasl_config, error ) {
+  saslpasswd2, err := exec.LookPath ( "saslpasswd2" )
+  if err != nil {
+    return nil, fmt.Errorf ( "can't make a sasldb : %s", err.Error() )
+  }
+
+  if err = os.MkdirAll ( dir, 0755 ); err != nil {
+    return nil, err
+  }
+
+  secret := make ( [] byte, 16 )
+  if _, err = rand.Read ( secret ); err != nil {
+    return nil, err
+  }
+
+  s := & Sasl_config { Dir      : dir,
+                       Name     : name,
+                       User     : user + "@" + sasl_realm,
+                       Password : hex.EncodeToString ( secret ) }
+
+  db := dir + "/" + name + ".sasldb"
+  os.Remove ( db )
+  cmd := exec.Command ( saslpasswd2, "-c", "-p", "-f", db, "-u", sasl_realm, user )
+  cmd.Stdin = strings.NewReader ( s.Password + "\n" )
+  if out, err := cmd.CombinedOutput ( ); err != nil {
+    return nil, fmt.Errorf ( "saslpasswd2 failed : %s : %s", err.Error(), strings.TrimSpace ( string(out) ) )
+  }
+
+  conf := "pwcheck_method: auxprop\n" +
+          "auxprop_plugin: sasldb\n" +
+          "sasldb_path: " + db + "\n" +
+          "mech_list: " + strings.Join ( mechanisms, " " ) + "\n"
+  if err = ioutil.WriteFile ( dir + "/" + name + ".conf", [] byte ( conf ), 0644 ); err != nil {
+    return nil, err
+  }
+
+  return s, nil
+}
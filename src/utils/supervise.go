@@ -0,0 +1,285 @@
+package utils
+
+import ( "errors"
+         "fmt"
+         "os"
+         "os/exec"
+         "strings"
+         "sync"
+         "syscall"
+         "time"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A Supervisor is the one goroutine that waits for a process that
+  mercury started. It notices the moment the process exits, and
+  records how, so that a router that crashes in the middle of a
+  run is reported then and there rather than discovered at the
+  end of the test -- or not at all.
+
+  Nothing else may call Wait() on the process's command.
+  Once it has, any output files that Capture_output() gave
+  the command are closed.
+
+===================================================================*/
+
+/*
+  How a process ended.
+*/
+type Process_exit struct {
+  Kind                 string      // "router" or "client"
+  Name                 string
+  Pid                  int
+  Time                 time.Time
+  // The exit code, or -1 if a signal ended the process.
+  Code                 int
+  Signal               string
+  // True if mercury asked the process to stop.
+  Expected             bool
+  // True if it had to be killed because it didn't stop
+  // when it was asked to.
+  Forced               bool
+  // From when it was asked to stop until it exited.
+  Shutdown             time.Duration
+  // The last lines of the process's log, if it has one,
+  // and of its stderr, if that was captured, when the exit
+  // was not expected.
+  Log_tail          [] string
+  Stderr_tail       [] string
+}
+
+
+
+type Supervisor struct {
+  lock                 sync.Mutex
+  cmd                * exec.Cmd
+  exit               * Process_exit
+  exited               chan struct{}
+  stopping             bool
+  stop_time            time.Time
+  forced               bool
+}
+
+
+
+/*
+  How many lines of a log to keep when a process exits unexpectedly.
+*/
+const log_tail_lines = 20
+
+
+
+
+
+func ( e * Process_exit ) String ( ) ( string ) {
+  how := fmt.Sprintf ( "exited with code %d", e.Code )
+  if e.Signal != "" {
+    how = "was killed by signal " + e.Signal
+  }
+  return fmt.Sprintf ( "%s |%s| (pid %d) %s", e.Kind, e.Name, e.Pid, how )
+}
+
+
+
+
+
+/*
+  Start waiting for a process that has already been started.
+  When it exits, on_exit, if it is not nil, is called with
+  how it ended, from the supervisor's goroutine. log_file may
+  be empty if the process has no log, and stderr_file if its
+  stderr was not captured.
+*/
+func Supervise ( cmd         * exec.Cmd,
+                 kind          string,
+                 name          string,
+                 log_file      string,
+                 stderr_file   string,
+                 on_exit       func ( Process_exit ) ) ( * Supervisor ) {
+  s := & Supervisor { cmd    : cmd,
+                      exited : make ( chan struct{} ) }
+
+  go func ( ) {
+    cmd.Wait ( )
+    Close_output ( cmd )
+
+    e := Process_exit { Kind : kind,
+                        Name : name,
+                        Pid  : cmd.Process.Pid,
+                        Time : time.Now(),
+                        Code : -1 }
+    if state := cmd.ProcessState; state != nil {
+      if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+        e.Signal = fmt.Sprintf ( "%d (%s)", int(status.Signal()), status.Signal().String() )
+      } else {
+        e.Code = state.ExitCode ( )
+      }
+    }
+
+    s.lock.Lock ( )
+    e.Expected = s.stopping
+    if s.stopping {
+      e.Forced   = s.forced
+      e.Shutdown = e.Time.Sub ( s.stop_time )
+    }
+    if ! e.Expected && log_file != "" {
+      e.Log_tail = Tail_lines ( log_file, log_tail_lines )
+    }
+    if ! e.Expected && stderr_file != "" {
+      e.Stderr_tail = Tail_lines ( stderr_file, log_tail_lines )
+    }
+    s.exit = & e
+    close ( s.exited )
+    s.lock.Unlock ( )
+
+    if on_exit != nil {
+      on_exit ( e )
+    }
+  } ( )
+
+  return s
+}
+
+
+
+
+
+/*
+  Closed when the process has exited.
+*/
+func ( s * Supervisor ) Exited ( ) ( <-chan struct{} ) {
+  return s.exited
+}
+
+
+
+
+
+/*
+  How the process ended, or nil if it is still running.
+*/
+func ( s * Supervisor ) Exit ( ) ( * Process_exit ) {
+  s.lock.Lock ( )
+  defer s.lock.Unlock ( )
+  return s.exit
+}
+
+
+
+
+
+/*
+  Ask the process to stop by sending it a signal, so that its
+  exit will be expected. If it has already exited, nothing is
+  sent, and how it exited is returned instead. That includes
+  a process that has been reaped but not yet recorded: Stop
+  waits for the record.
+*/
+func ( s * Supervisor ) Stop ( signal os.Signal ) ( * Process_exit, error ) {
+  s.lock.Lock ( )
+  if s.exit != nil {
+    defer s.lock.Unlock ( )
+    return s.exit, nil
+  }
+  was_stopping := s.stopping
+  if ! s.stopping {
+    s.stopping  = true
+    s.stop_time = time.Now()
+  }
+  err := s.cmd.Process.Signal ( signal )
+  if errors.Is ( err, os.ErrProcessDone ) && ! was_stopping {
+    // It went by itself, before the signal.
+    s.stopping = false
+  }
+  s.lock.Unlock ( )
+
+  if errors.Is ( err, os.ErrProcessDone ) {
+    <- s.exited
+    return s.Exit ( ), nil
+  }
+  if err != nil {
+    return nil, errors.New ( "failed to signal process: " + err.Error() )
+  }
+  return nil, nil
+}
+
+
+
+
+
+/*
+  Stop the process with a signal, and wait up to grace for it
+  to exit. If it hasn't, kill it, and wait for that. Either way
+  it has been reaped when this returns, and how it ended is
+  returned.
+  early is true if it had already exited before it was ever
+  asked to.
+*/
+func ( s * Supervisor ) Halt ( signal  os.Signal,
+                               grace   time.Duration ) ( exit * Process_exit, early bool, err error ) {
+  if exit, err = s.Stop ( signal ); err != nil || exit != nil {
+    return exit, exit != nil && ! exit.Expected, err
+  }
+
+  timer := time.NewTimer ( grace )
+  defer timer.Stop ( )
+
+  select {
+    case <- s.exited :
+    case <- timer.C :
+      s.lock.Lock ( )
+      if s.exit == nil {
+        s.forced = true
+        s.cmd.Process.Kill ( )
+      }
+      s.lock.Unlock ( )
+      <- s.exited
+  }
+
+  return s.Exit ( ), false, nil
+}
+
+
+
+
+
+/*
+  The last n lines of a file, or none if it can't be read.
+  Only the end of the file is read, however big it is.
+*/
+func Tail_lines ( file_name string, n int ) ( [] string ) {
+  f, err := os.Open ( file_name )
+  if err != nil {
+    return nil
+  }
+  defer f.Close ( )
+
+  const max_bytes = 16 * 1024
+  info, err := f.Stat ( )
+  if err != nil {
+    return nil
+  }
+  offset := info.Size() - max_bytes
+  if offset < 0 {
+    offset = 0
+  }
+  buffer := make ( [] byte, info.Size() - offset )
+  if _, err = f.ReadAt ( buffer, offset ); err != nil {
+    return nil
+  }
+
+  lines := strings.Split ( strings.TrimRight ( string(buffer), "\n" ), "\n" )
+  // The first line may have been cut in half.
+  if offset > 0 && len ( lines ) > 1 {
+    lines = lines[1:]
+  }
+  if len ( lines ) > n {
+    lines = lines [ len(lines) - n : ]
+  }
+  return lines
+}
@@ -0,0 +1,172 @@
+package utils
+
+import ( "crypto/ecdsa"
+         "crypto/elliptic"
+         "crypto/rand"
+         "crypto/x509"
+         "crypto/x509/pkix"
+         "encoding/pem"
+         "math/big"
+         "net"
+         "os"
+         "sync"
+         "time"
+       )
+
+
+
+
+
+/*===================================================================
+
+  A Certificate_authority makes the certificates that routers use
+  for TLS in a test. It exists only for the test: its key is
+  written next to its certificate, and nothing outside the test
+  should ever trust it.
+
+  Keys are ECDSA P-256, which are quick to make even for networks
+  of hundreds of routers.
+
+===================================================================*/
+
+type Certificate_authority struct {
+  // The CA's certificate, in PEM, for everything that
+  // has to trust the certificates it issues.
+  Cert_file            string
+  Key_file             string
+
+  lock                 sync.Mutex
+  cert               * x509.Certificate
+  key                * ecdsa.PrivateKey
+  serial               int64
+}
+
+
+
+// Long enough for any test, short enough that nobody keeps them.
+const certificate_lifetime = 7 * 24 * time.Hour
+
+
+
+
+
+/*
+  Make a new CA, and write its certificate and key to
+  dir/ca.crt and dir/ca.key .
+*/
+func New_certificate_authority ( dir, name string ) ( * Certificate_authority, error ) {
+  if err := os.MkdirAll ( dir, 0755 ); err != nil {
+    return nil, err
+  }
+
+  key, err := ecdsa.GenerateKey ( elliptic.P256(), rand.Reader )
+  if err != nil {
+    return nil, err
+  }
+
+  now := time.Now()
+  template := & x509.Certificate { SerialNumber          : big.NewInt ( 1 ),
+                                   Subject               : pkix.Name { CommonName : name },
+                                   NotBefore             : now.Add ( - time.Hour ),
+                                   NotAfter              : now.Add ( certificate_lifetime ),
+                                   KeyUsage              : x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+                                   BasicConstraintsValid : true,
+                                   IsCA                  : true }
+  der, err := x509.CreateCertificate ( rand.Reader, template, template, & key.PublicKey, key )
+  if err != nil {
+    return nil, err
+  }
+  cert, err := x509.ParseCertificate ( der )
+  if err != nil {
+    return nil, err
+  }
+
+  ca := & Certificate_authority { Cert_file : dir + "/ca.crt",
+                                  Key_file  : dir + "/ca.key",
+                                  cert      : cert,
+                                  key       : key,
+                                  serial    : 1 }
+  if err = write_pem ( ca.Cert_file, "CERTIFICATE", der, 0644 ); err != nil {
+    return nil, err
+  }
+  if err = write_key ( ca.Key_file, key ); err != nil {
+    return nil, err
+  }
+  return ca, nil
+}
+
+
+
+
+
+/*
+  Issue a certificate, for both servers and clients, to 'name'
+  on this host. The certificate and its key are written to
+  cert_file and key_file.
+
+  Everything in a test connects over the loopback, so the
+  certificate names localhost and the loopback and wildcard
+  addresses. They are listed as DNS names too, because Proton
+  checks a peer's name only against those and the common name.
+*/
+func ( ca * Certificate_authority ) Issue ( name, cert_file, key_file string ) ( error ) {
+  key, err := ecdsa.GenerateKey ( elliptic.P256(), rand.Reader )
+  if err != nil {
+    return err
+  }
+
+  ca.lock.Lock ( )
+  ca.serial ++
+  serial := ca.serial
+  ca.lock.Unlock ( )
+
+  now := time.Now()
+  template := & x509.Certificate { SerialNumber : big.NewInt ( serial ),
+                                   Subject      : pkix.Name { CommonName : name },
+                                   NotBefore    : now.Add ( - time.Hour ),
+                                   NotAfter     : now.Add ( certificate_lifetime ),
+                                   KeyUsage     : x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+                                   ExtKeyUsage  : [] x509.ExtKeyUsage { x509.ExtKeyUsageServerAuth,
+                                                                        x509.ExtKeyUsageClientAuth },
+                                   DNSNames     : [] string { "localhost", "127.0.0.1", "0.0.0.0" },
+                                   IPAddresses  : [] net.IP { net.IPv4 ( 127, 0, 0, 1 ),
+                                                              net.IPv4zero,
+                                                              net.IPv6loopback } }
+  der, err := x509.CreateCertificate ( rand.Reader, template, ca.cert, & key.PublicKey, ca.key )
+  if err != nil {
+    return err
+  }
+
+  if err = write_pem ( cert_file, "CERTIFICATE", der, 0644 ); err != nil {
+    return err
+  }
+  return write_key ( key_file, key )
+}
+
+
+
+
+
+func write_key ( file_name string, key * ecdsa.PrivateKey ) ( error ) {
+  der, err := x509.MarshalPKCS8PrivateKey ( key )
+  if err != nil {
+    return err
+  }
+  return write_pem ( file_name, "PRIVATE KEY", der, 0600 )
+}
+
+
+
+
+
+func write_pem ( file_name, block_type string, der [] byte, mode os.FileMode ) ( error ) {
+  f, err := os.OpenFile ( file_name, os.O_CREATE | os.O_TRUNC | os.O_WRONLY, mode )
+  if err != nil {
+    return err
+  }
+  if err = pem.Encode ( f, & pem.Block { Type : block_type, Bytes : der } ); err != nil {
+    f.Close ( )
+    return err
+  }
+  return f.Close ( )
+}
@@ -1,10 +1,12 @@
 package utils
 
 import ( "fmt"
+         "io/ioutil"
          "net"
          "os"
          "os/exec"
          "os/user"
+         "path/filepath"
          "strconv"
          "strings"
          "runtime" 
@@ -79,6 +81,10 @@ func M_info_seconds ( start_time float64, format string, args ...interface{}) {
 
 
 
+/*
+  A port that is free right now -- which it may not be by the
+  time anything listens on it. Networks use a Port_allocator.
+*/
 func Available_port () ( port string, err error ) {
 
   server, err := net.Listen ( "tcp", ":0" )
@@ -154,11 +160,12 @@ func Find_or_create_dir ( path string ) {
 
 
 
-func End_test_and_exit ( result_path string, test_error string ) {
+// Write the one-line result file for a test: either
+// "success" or "failure : <reason>".
+func Write_result_file ( result_path string, test_error string ) ( error ) {
   f, err := os.Create ( result_path + "/result" )
   if err != nil {
-    fp ( os.Stderr, "Can't write results file!\n" )
-    os.Exit ( 1 )
+    return err
   }
   defer f.Close ( )
 
@@ -168,6 +175,19 @@ func End_test_and_exit ( result_path string, test_error string ) {
     fp ( f, "failure : %s\n", test_error )
   }
 
+  return nil
+}
+
+
+
+
+
+func End_test_and_exit ( result_path string, test_error string ) {
+  if err := Write_result_file ( result_path, test_error ); err != nil {
+    fp ( os.Stderr, "Can't write results file!\n" )
+    os.Exit ( 1 )
+  }
+
   os.Exit ( 0 )
 }
 
@@ -189,22 +209,16 @@ func Make_paths ( mercury_root, test_id, test_name string ) ( router_path, resul
 
 
 
-// This doesn't work either
+/*
+  The resident set size of a process, in kilobytes,
+  or -1 if it can't be read.
+*/
 func Memory_usage ( pid int ) ( rss int ) {
-  return -666
-
-  proc_file_name := "/proc/" + strconv.Itoa(pid) + "/statm"
-  proc_file, err := os.Open ( proc_file_name )
+  s, err := Read_proc ( pid )
   if err != nil {
-    fp ( os.Stderr, "util.Memory_usage error: can't open |%s|\n", proc_file_name )
     return -1
   }
-  defer proc_file.Close ( )
-
-  fp ( os.Stdout, "MDEBUG Memory_usage proc file is |%s|\n", proc_file_name )
-  var vm_size int
-  fmt.Fscanf ( proc_file, "%d%d", & vm_size, & rss )
-  return rss
+  return s.Rss_kb
 }
 
 
@@ -256,9 +270,10 @@ func Timestamp ( ) ( string ) {
 
 
 // Use cpupower command to discover allowable frequencies.
+// sudo must not ask for a password, or there are none.
 func Get_CPU_freqs ( ) ( freqs [] string ) {
   command   := "sudo"
-  args      := "cpupower frequency-info"
+  args      := "-n cpupower frequency-info"
   args_list := strings.Fields ( args )
 
   out, _ := exec.Command ( command, args_list... ).Output()
@@ -285,6 +300,57 @@ func Get_CPU_freqs ( ) ( freqs [] string ) {
 
 
 
+/*
+  The frequency governor of each CPU, counted by governor, as in
+  { "performance" : 8 } . This needs no privileges, but is empty
+  where the kernel has no cpufreq, as in most virtual machines.
+*/
+func Get_CPU_governors ( ) ( governors map [ string ] int ) {
+  governors = make ( map [ string ] int )
+  files, _ := filepath.Glob ( "/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_governor" )
+  for _, file := range files {
+    content, err := ioutil.ReadFile ( file )
+    if err == nil {
+      governors [ strings.TrimSpace ( string(content) ) ] ++
+    }
+  }
+  return governors
+}
+
+
+
+
+
+/*
+  The least and greatest current frequencies of the CPUs,
+  in MHz, or zeros if the kernel has no cpufreq.
+*/
+func Get_CPU_current_freqs ( ) ( min_mhz, max_mhz float64 ) {
+  files, _ := filepath.Glob ( "/sys/devices/system/cpu/cpu[0-9]*/cpufreq/scaling_cur_freq" )
+  for _, file := range files {
+    content, err := ioutil.ReadFile ( file )
+    if err != nil {
+      continue
+    }
+    khz, err := strconv.ParseFloat ( strings.TrimSpace ( string(content) ), 64 )
+    if err != nil {
+      continue
+    }
+    mhz := khz / 1000
+    if min_mhz == 0 || mhz < min_mhz {
+      min_mhz = mhz
+    }
+    if mhz > max_mhz {
+      max_mhz = mhz
+    }
+  }
+  return min_mhz, max_mhz
+}
+
+
+
+
+
 func Set_Top_Freq ( freqs [] string ) ( err error ) {
   command := "sudo"
   args    := "cpupower frequency-set --freq " + freqs [ 0 ] + "GHz"